@@ -0,0 +1,498 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	errAccountInUse         = errors.New("account is already seated")
+	errAccountBanned        = errors.New("account is banned")
+	errBuyInRange           = errors.New("buy-in is outside the table limits")
+	errInsufficientBankroll = errors.New("bankroll is too small for that buy-in")
+	errBotAccount           = errors.New("that name belongs to a bot")
+	errHumanAccount         = errors.New("that name belongs to a player")
+	errNoAccount            = errors.New("no account has that name")
+)
+
+// Account is a persistent player identity. Bankroll is the chips the player
+// has off the tables. Sitting down moves a buy-in from the bankroll to the
+// table; InPlay is the stack there, written after every hand, and leaving
+// moves it back.
+type Account struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Bankroll  int       `json:"bankroll"`
+	InPlay    int       `json:"inPlay,omitempty"`
+	Table     string    `json:"table,omitempty"` // table holding InPlay
+	CreatedAt time.Time `json:"createdAt"`
+	LastSeen  time.Time `json:"lastSeen"`
+	Banned    bool      `json:"banned,omitempty"`
+	Bot       bool      `json:"bot,omitempty"` // see bots.go
+	// PasswordHash and APIKeyHash are stored but never leave the
+	// AccountBook; see auth.go and botapi.go.
+	PasswordHash string `json:"passwordHash,omitempty"`
+	APIKeyHash   string `json:"apiKeyHash,omitempty"`
+}
+
+// view is the copy of an account handed out by the AccountBook.
+func (a *Account) view() Account {
+	v := *a
+	v.PasswordHash, v.APIKeyHash = "", ""
+	return v
+}
+
+// AccountBook is the in-memory view of all accounts and the chip ledger,
+// written through to the store. Tables call into it while holding their own
+// lock, so it must never call back into a Hub.
+type AccountBook struct {
+	mu        sync.Mutex
+	store     Store
+	accounts  map[string]*Account
+	byName    map[string]string // lower-cased name -> account ID
+	balances  map[string]int    // ledger account -> balance, see ledger.go
+	rake      map[string]TableRake
+	ledgerSeq int64
+}
+
+func newAccountBook(store Store) (*AccountBook, error) {
+	accounts, err := store.LoadAccounts()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := store.LoadLedger("", 0)
+	if err != nil {
+		return nil, err
+	}
+	checkpoints, err := store.LoadCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+	b := &AccountBook{
+		store:    store,
+		accounts: make(map[string]*Account, len(accounts)),
+		byName:   make(map[string]string, len(accounts)),
+		balances: make(map[string]int),
+		rake:     make(map[string]TableRake),
+	}
+	for i := range accounts {
+		a := accounts[i]
+		b.accounts[a.ID] = &a
+		b.byName[strings.ToLower(a.Name)] = a.ID
+	}
+	b.mu.Lock()
+	b.reconcileLocked(entries, checkpoints)
+	b.mu.Unlock()
+	return b, nil
+}
+
+// createLocked opens an account called name, granting it bankroll chips
+// from the house.
+func (b *AccountBook) createLocked(name string, bankroll int) *Account {
+	a := &Account{
+		ID:        uuid.New().String(),
+		Name:      name,
+		CreatedAt: time.Now(),
+	}
+	b.accounts[a.ID] = a
+	b.byName[strings.ToLower(name)] = a.ID
+	if bankroll > 0 {
+		b.grantLocked(a, LedgerGrant, bankroll)
+	}
+	b.saveLocked(a)
+	slog.Info("account created", "account", a.ID, "name", name)
+	return a
+}
+
+func (b *AccountBook) grantLocked(a *Account, kind string, chips int) {
+	b.postLocked(LedgerEntry{Kind: kind, From: houseLedger, To: bankrollLedger(a.ID), Amount: chips, AccountID: a.ID})
+	a.Bankroll += chips
+}
+
+// Claim buys the account called name in at the table: buyIn chips, or the
+// table's default buy-in when buyIn is 0, move from the bankroll to the
+// table. Only bots get an account made for them here; a guest name with no
+// account gets errNoAccount, so no guest ever plays from an empty bankroll
+// or holds on to a name it could not sit down with.
+func (b *AccountBook) Claim(name string, table TableConfig, buyIn int) (Account, error) {
+	return b.claim(name, false, table, buyIn)
+}
+
+// ClaimBot is Claim for a bot, creating its account with the starting
+// bankroll if needed. Bot and player accounts cannot claim each other's
+// names.
+func (b *AccountBook) ClaimBot(name string, table TableConfig, buyIn int) (Account, error) {
+	return b.claim(name, true, table, buyIn)
+}
+
+func (b *AccountBook) claim(name string, bot bool, table TableConfig, buyIn int) (Account, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	a, ok := b.accounts[b.byName[strings.ToLower(name)]]
+	bankroll := StartingBankroll // of a bot account yet to be created
+	switch {
+	case !ok && !bot:
+		return Account{}, errNoAccount
+	case !ok:
+	case a.Bot && !bot:
+		return Account{}, errBotAccount
+	case !a.Bot && bot:
+		return Account{}, errHumanAccount
+	case a.Banned:
+		return Account{}, errAccountBanned
+	case a.Table != "":
+		return Account{}, errAccountInUse
+	default:
+		bankroll = a.Bankroll
+	}
+	if buyIn == 0 {
+		buyIn = max(table.MinBuyIn, min(table.StartingChips, bankroll))
+	}
+	if buyIn < table.MinBuyIn || buyIn > table.MaxBuyIn {
+		return Account{}, errBuyInRange
+	}
+	if buyIn > bankroll {
+		return Account{}, errInsufficientBankroll
+	}
+	if !ok {
+		a = b.createLocked(name, StartingBankroll)
+		a.Bot = true
+	}
+	b.postLocked(LedgerEntry{Kind: LedgerBuyIn, From: bankrollLedger(a.ID), To: tableLedger(table.ID),
+		Amount: buyIn, AccountID: a.ID, TableID: table.ID})
+	a.Bankroll -= buyIn
+	a.InPlay = buyIn
+	a.Table = table.ID
+	a.LastSeen = time.Now()
+	b.saveLocked(a)
+	return a.view(), nil
+}
+
+// Resume returns the account whose stack was left at tableID over a restart,
+// so that it sits down again with that stack.
+func (b *AccountBook) Resume(accountID, tableID string) (Account, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	a, ok := b.accounts[accountID]
+	if !ok || a.Table != tableID {
+		return Account{}, errNotFound
+	}
+	if a.Banned {
+		return Account{}, errAccountBanned
+	}
+	a.LastSeen = time.Now()
+	b.saveLocked(a)
+	return a.view(), nil
+}
+
+// BuyMore moves chips from the bankroll of a seated account to its table,
+// for a rebuy or an add-on.
+func (b *AccountBook) BuyMore(accountID string, chips int, kind string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	a, ok := b.accounts[accountID]
+	if !ok || a.Table == "" {
+		return errNotFound
+	}
+	if chips > a.Bankroll {
+		return errInsufficientBankroll
+	}
+	b.postLocked(LedgerEntry{Kind: kind, From: bankrollLedger(a.ID), To: tableLedger(a.Table),
+		Amount: chips, AccountID: a.ID, TableID: a.Table})
+	a.Bankroll -= chips
+	a.InPlay += chips
+	a.LastSeen = time.Now()
+	b.saveLocked(a)
+	return nil
+}
+
+// UpdateStack records the current table stack of a seated account.
+func (b *AccountBook) UpdateStack(accountID string, chips int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if a, ok := b.accounts[accountID]; ok && a.InPlay != chips {
+		a.InPlay = chips
+		a.LastSeen = time.Now()
+		b.saveLocked(a)
+	}
+}
+
+// Release cashes out the final stack and frees the account to sit
+// elsewhere.
+func (b *AccountBook) Release(accountID string, chips int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	a, ok := b.accounts[accountID]
+	if !ok || a.Table == "" {
+		return
+	}
+	b.postLocked(LedgerEntry{Kind: LedgerCashOut, From: tableLedger(a.Table), To: bankrollLedger(a.ID),
+		Amount: chips, AccountID: a.ID, TableID: a.Table})
+	a.Bankroll += chips
+	a.InPlay, a.Table = 0, ""
+	a.LastSeen = time.Now()
+	b.saveLocked(a)
+}
+
+func (b *AccountBook) Get(accountID string) (Account, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	a, ok := b.accounts[accountID]
+	if !ok {
+		return Account{}, false
+	}
+	return a.view(), true
+}
+
+// Lookup finds an account by ID or by name and reports the table holding
+// its chips, if any.
+func (b *AccountBook) Lookup(idOrName string) (account Account, tableID string, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	a, ok := b.accounts[idOrName]
+	if !ok {
+		a, ok = b.accounts[b.byName[strings.ToLower(idOrName)]]
+	}
+	if !ok {
+		return Account{}, "", false
+	}
+	return a.view(), a.Table, true
+}
+
+// SetBanned bans an account from sitting down, or lifts the ban.
+func (b *AccountBook) SetBanned(accountID string, banned bool) (Account, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	a, ok := b.accounts[accountID]
+	if !ok {
+		return Account{}, false
+	}
+	if a.Banned != banned {
+		a.Banned = banned
+		b.saveLocked(a)
+	}
+	return a.view(), true
+}
+
+// AdjustBankroll adds delta to the bankroll of an account that is not
+// seated; a seated account's chips are adjusted at its table.
+func (b *AccountBook) AdjustBankroll(accountID string, delta int, reason string) (Account, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	a, ok := b.accounts[accountID]
+	if !ok {
+		return Account{}, errNotFound
+	}
+	if a.Table != "" {
+		return Account{}, errAccountInUse
+	}
+	if a.Bankroll+delta < 0 {
+		return Account{}, fmt.Errorf("bankroll of %d cannot go below zero", a.Bankroll)
+	}
+	b.postLocked(LedgerEntry{Kind: LedgerAdjustment, From: houseLedger, To: bankrollLedger(a.ID),
+		Amount: delta, AccountID: a.ID, Memo: reason})
+	a.Bankroll += delta
+	b.saveLocked(a)
+	return a.view(), nil
+}
+
+// AdjustStack records an operator change of delta chips to the stack of a
+// seated account.
+func (b *AccountBook) AdjustStack(accountID string, delta int, reason string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	a, ok := b.accounts[accountID]
+	if !ok || a.Table == "" {
+		return
+	}
+	b.postLocked(LedgerEntry{Kind: LedgerAdjustment, From: houseLedger, To: tableLedger(a.Table),
+		Amount: delta, AccountID: a.ID, TableID: a.Table, Memo: reason})
+	a.InPlay += delta
+	b.saveLocked(a)
+}
+
+// Register creates an account called name with a password. Any account
+// already holding the name, with or without a password, keeps it: guest
+// accounts and those from before passwords cannot be taken over.
+func (b *AccountBook) Register(name, passwordHash string) (Account, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.byName[strings.ToLower(name)]; ok {
+		return Account{}, errNameTaken
+	}
+	a := b.createLocked(name, StartingBankroll)
+	a.PasswordHash = passwordHash
+	a.LastSeen = time.Now()
+	b.saveLocked(a)
+	return a.view(), nil
+}
+
+// Authenticate checks a name and password. The hash is computed outside
+// the lock, since it takes a noticeable fraction of a second.
+func (b *AccountBook) Authenticate(name, password string) (Account, error) {
+	b.mu.Lock()
+	a, ok := b.accounts[b.byName[strings.ToLower(name)]]
+	hash := dummyPasswordHash
+	if ok && a.PasswordHash != "" {
+		hash = a.PasswordHash
+	}
+	var id string
+	if ok {
+		id = a.ID
+	}
+	b.mu.Unlock()
+
+	if !checkPassword(hash, password) || hash == dummyPasswordHash {
+		return Account{}, errBadCredentials
+	}
+	account, ok := b.Get(id)
+	if !ok {
+		return Account{}, errBadCredentials
+	}
+	if account.Banned {
+		return Account{}, errAccountBanned
+	}
+	return account, nil
+}
+
+// HasPassword reports whether the name belongs to a registered account.
+func (b *AccountBook) HasPassword(name string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	a, ok := b.accounts[b.byName[strings.ToLower(name)]]
+	return ok && a.PasswordHash != ""
+}
+
+func (b *AccountBook) Count() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.accounts)
+}
+
+func (b *AccountBook) saveLocked(a *Account) {
+	if err := b.store.SaveAccount(*a); err != nil {
+		slog.Error("saving account failed", "account", a.ID, "err", err)
+	}
+}
+
+// pendingJoin is a join waiting for the current hand to end.
+type pendingJoin struct {
+	name  string
+	buyIn int
+}
+
+// bindAccountUnsafe attaches the account called name to the player and buys
+// it in, buyIn chips or the table default when 0, as the player's stack. A
+// player switching names cashes out their previous account.
+func (h *Hub) bindAccountUnsafe(player *Player, name string, buyIn int) error {
+	if h.gameState.GameStarted && player.IsInHand {
+		return protocolErrorf(CodeHandInProgress, "cannot change name during a hand")
+	}
+	if current, ok := h.accounts.Get(player.AccountID); ok && strings.EqualFold(current.Name, name) {
+		return nil
+	}
+	var account Account
+	var err error
+	if restored, ok := h.restoredSeatUnsafe(name); ok {
+		// The stack never left the table
+		account, err = h.accounts.Resume(restored.AccountID, h.config.ID)
+	} else {
+		account, err = h.accounts.Claim(name, h.config, buyIn)
+	}
+	if errors.Is(err, errAccountInUse) && h.heldByLeaverUnsafe(name) {
+		// The previous connection is still in the hand; sit down when it ends
+		h.pendingJoins[player.ID] = pendingJoin{name: name, buyIn: buyIn}
+		return protocolErrorf(CodeJoinPending, "%v until the current hand ends; you will be seated then", err)
+	}
+	if errors.Is(err, errAccountInUse) {
+		return protocolErrorf(CodeNameInUse, "%s is already playing", name)
+	}
+	if errors.Is(err, errAccountBanned) {
+		return protocolErrorf(CodeBanned, "%s is banned from this server", name)
+	}
+	if errors.Is(err, errBotAccount) {
+		return protocolErrorf(CodeNameInUse, "%s is the name of a bot", name)
+	}
+	if errors.Is(err, errNoAccount) {
+		return protocolErrorf(CodeLoginRequired, "register %s to take a seat", name)
+	}
+	if errors.Is(err, errBuyInRange) {
+		return protocolErrorf(CodeInvalidBuyIn, "the buy-in at this table is %d to %d chips", h.config.MinBuyIn, h.config.MaxBuyIn)
+	}
+	if errors.Is(err, errInsufficientBankroll) {
+		return protocolErrorf(CodeInsufficientBankroll, "your bankroll does not cover a buy-in of %d", buyIn)
+	}
+	if err != nil {
+		return err
+	}
+	if player.AccountID != "" {
+		h.accounts.Release(player.AccountID, player.Chips)
+	}
+	player.AccountID = account.ID
+	player.Name = account.Name
+	player.Chips = account.InPlay
+	player.HUD = h.hudUnsafe(account.ID)
+	return nil
+}
+
+// heldByLeaverUnsafe reports whether the account called name belongs to a
+// disconnected player still waiting for the current hand to end.
+func (h *Hub) heldByLeaverUnsafe(name string) bool {
+	for _, p := range h.gameState.Players {
+		if !p.IsConnected && p.AccountID != "" && strings.EqualFold(p.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// seatPendingJoinsUnsafe retries joins that waited for a hand to end.
+func (h *Hub) seatPendingJoinsUnsafe() {
+	for playerID, join := range h.pendingJoins {
+		delete(h.pendingJoins, playerID)
+		if _, watching := h.gameState.Spectators[playerID]; watching {
+			if err := h.seatSpectatorUnsafe(playerID, join.name, 0, join.buyIn); err != nil {
+				h.logUnsafe().Info("spectator cannot sit", "client", playerID, "name", join.name, "err", err)
+			}
+			continue
+		}
+		player, ok := h.gameState.Players[playerID]
+		if !ok || !player.IsConnected {
+			continue
+		}
+		if err := h.bindAccountUnsafe(&player, join.name, join.buyIn); err != nil {
+			h.logUnsafe().Info("player cannot join", "player", player.Name, "name", join.name, "err", err)
+			continue
+		}
+		h.gameState.Players[playerID] = player
+		h.emitEventUnsafe(GameEvent{Type: EventPlayerRenamed, PlayerID: playerID, Name: player.Name})
+	}
+}
+
+// removePlayerUnsafe takes a player off the table, returning their stack to
+// their account.
+func (h *Hub) removePlayerUnsafe(playerID string) {
+	p, ok := h.gameState.Players[playerID]
+	if !ok {
+		return
+	}
+	if p.AccountID != "" {
+		h.accounts.Release(p.AccountID, p.Chips)
+	}
+	delete(h.gameState.Players, playerID)
+	delete(h.playerReady, playerID)
+	delete(h.clientSeeds, playerID)
+	delete(h.pendingJoins, playerID)
+	delete(h.busted, playerID)
+	delete(h.handsDealt, playerID)
+	delete(h.addOnTaken, playerID)
+	h.dropBotUnsafe(playerID)
+	h.emitEventUnsafe(GameEvent{Type: EventPlayerLeft, PlayerID: playerID})
+}
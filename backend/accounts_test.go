@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestOnlyRegisteredAndBotAccountsGetABankroll(t *testing.T) {
+	lobby := newTestLobby(t)
+	accounts := lobby.accounts
+	table := lobby.table(defaultTableID).config
+
+	if _, err := accounts.Claim("Guest", table, 0); !errors.Is(err, errNoAccount) {
+		t.Errorf("guest sat down: error %v, want %v", err, errNoAccount)
+	}
+	if _, _, ok := accounts.Lookup("Guest"); ok {
+		t.Error("the guest's failed claim created an account")
+	}
+	player, err := accounts.Register("Alice", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if player.Bankroll != StartingBankroll {
+		t.Errorf("registered account has %d chips, want %d", player.Bankroll, StartingBankroll)
+	}
+	if _, err := accounts.ClaimBot("Robo", table, table.MaxBuyIn+1); !errors.Is(err, errBuyInRange) {
+		t.Errorf("bot bought in above the maximum: error %v, want %v", err, errBuyInRange)
+	}
+	if _, _, ok := accounts.Lookup("Robo"); ok {
+		t.Error("the bot's failed claim created an account")
+	}
+	bot, err := accounts.ClaimBot("Robo", table, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bot.Bankroll+bot.InPlay != StartingBankroll {
+		t.Errorf("bot has %d chips, want %d", bot.Bankroll+bot.InPlay, StartingBankroll)
+	}
+}
+
+func TestBustedBankrollIsNotRestaked(t *testing.T) {
+	lobby := newTestLobby(t)
+	accounts := lobby.accounts
+	table := lobby.table(defaultTableID).config
+	a, err := accounts.Register("Alice", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := accounts.AdjustBankroll(a.ID, table.MinBuyIn-StartingBankroll-1, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := accounts.Claim("Alice", table, 0); !errors.Is(err, errInsufficientBankroll) {
+		t.Errorf("short bankroll sat down: error %v, want %v", err, errInsufficientBankroll)
+	}
+	if a, _, _ := accounts.Lookup("Alice"); a.Bankroll != table.MinBuyIn-1 {
+		t.Errorf("bankroll is %d, want %d", a.Bankroll, table.MinBuyIn-1)
+	}
+	if report := lobby.ledgerReport(); !report.Balanced {
+		t.Errorf("ledger does not balance: %+v", report)
+	}
+}
@@ -0,0 +1,588 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// --- Admin API ---
+//
+// Operator endpoints under /api/admin, enabled by -admin-token. Every
+// request must carry "Authorization: Bearer <token>", and every change is
+// written to the audit log in the store.
+
+const maxAuditEntries = 500
+
+var serverStartedAt = time.Now()
+
+var (
+	errNoHandRunning = errors.New("no hand is running")
+	errNotAtTable    = errors.New("player is not at this table")
+)
+
+// AuditEntry records one operator action.
+type AuditEntry struct {
+	At      time.Time `json:"at"`
+	Action  string    `json:"action"`
+	TableID string    `json:"tableId,omitempty"`
+	Target  string    `json:"target,omitempty"` // account or connection
+	Amount  int       `json:"amount,omitempty"`
+	Reason  string    `json:"reason,omitempty"`
+	Remote  string    `json:"remote"`
+}
+
+// AdminStats is the live overview returned by GET /api/admin/stats.
+type AdminStats struct {
+	StartedAt     time.Time         `json:"startedAt"`
+	UptimeSeconds int64             `json:"uptimeSeconds"`
+	Connections   int               `json:"connections"`
+	Accounts      int               `json:"accounts"`
+	Goroutines    int               `json:"goroutines"`
+	MemoryBytes   uint64            `json:"memoryBytes"`
+	Tables        []AdminTableStats `json:"tables"`
+}
+
+type AdminTableStats struct {
+	TableSummary
+	Connections    int `json:"connections"`
+	HandsPlayed    int `json:"handsPlayed"` // since the server started
+	HandsPerMinute int `json:"handsPerMinute"`
+}
+
+type adminReasonRequest struct {
+	Reason string `json:"reason"`
+}
+
+type adminKickRequest struct {
+	Player string `json:"player"` // connection ID or name
+	Reason string `json:"reason"`
+}
+
+type adminChipsRequest struct {
+	Amount int    `json:"amount"` // added to the stack, negative to remove chips
+	Reason string `json:"reason"`
+}
+
+type adminBotRequest struct {
+	Strategy string `json:"strategy"`
+	Name     string `json:"name"`    // "Bot <n>" when empty
+	ThinkMs  *int   `json:"thinkMs"` // 1000 when left out
+	BuyIn    int    `json:"buyIn"`
+	Reason   string `json:"reason"`
+}
+
+type adminBroadcastRequest struct {
+	Message string `json:"message"`
+	Table   string `json:"table"` // every table when empty
+}
+
+func registerAdminAPI(mux *http.ServeMux, lobby *Lobby, token string) {
+	handle := func(pattern string, handler http.HandlerFunc) {
+		mux.HandleFunc(pattern, requireAdmin(token, handler))
+	}
+	tableHandler := func(fn func(w http.ResponseWriter, r *http.Request, hub *Hub)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			hub := lobby.table(r.PathValue("id"))
+			if hub == nil {
+				writeAPIError(w, http.StatusNotFound, "table not found")
+				return
+			}
+			fn(w, r, hub)
+		}
+	}
+
+	handle("GET /api/admin/stats", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, lobby.adminStats())
+	})
+	handle("GET /api/admin/audit", func(w http.ResponseWriter, r *http.Request) {
+		limit := maxAuditEntries
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxAuditEntries {
+				writeAPIError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxAuditEntries))
+				return
+			}
+			limit = n
+		}
+		entries, err := lobby.store.LoadAudit(limit)
+		if err != nil {
+			slog.Error("loading audit log failed", "err", err)
+			writeAPIError(w, http.StatusInternalServerError, "could not load the audit log")
+			return
+		}
+		writeJSON(w, http.StatusOK, entries)
+	})
+
+	handle("GET /api/admin/ledger", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		limit := maxLedgerEntries
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxLedgerEntries {
+				writeAPIError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxLedgerEntries))
+				return
+			}
+			limit = n
+		}
+		var account string
+		switch {
+		case q.Get("player") != "":
+			found, _, ok := lobby.accounts.Lookup(q.Get("player"))
+			if !ok {
+				writeAPIError(w, http.StatusNotFound, "player not found")
+				return
+			}
+			account = bankrollLedger(found.ID)
+		case q.Get("table") != "":
+			account = tableLedger(q.Get("table"))
+		}
+		entries, err := lobby.store.LoadLedger(account, limit)
+		if err != nil {
+			slog.Error("loading ledger failed", "err", err)
+			writeAPIError(w, http.StatusInternalServerError, "could not load the ledger")
+			return
+		}
+		writeJSON(w, http.StatusOK, entries)
+	})
+	handle("GET /api/admin/ledger/check", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, lobby.ledgerReport())
+	})
+	handle("GET /api/admin/rake", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, lobby.accounts.RakeReport())
+	})
+
+	handle("POST /api/tables", func(w http.ResponseWriter, r *http.Request) {
+		var req createTableRequest
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+			return
+		}
+		cfg, err := req.tableConfig()
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		hub, err := lobby.createTable(cfg)
+		if errors.Is(err, errTableExists) {
+			writeAPIError(w, http.StatusConflict, err.Error())
+			return
+		}
+		if err != nil {
+			slog.Error("creating table failed", "table", cfg.ID, "err", err)
+			writeAPIError(w, http.StatusInternalServerError, "could not create table")
+			return
+		}
+		lobby.audit(r, AuditEntry{Action: "create_table", TableID: cfg.ID, Reason: req.Reason})
+		w.Header().Set("Location", "/api/tables/"+cfg.ID)
+		writeJSON(w, http.StatusCreated, hub.summary())
+	})
+
+	for _, action := range []string{"pause", "resume"} {
+		paused := action == "pause"
+		handle("POST /api/admin/tables/{id}/"+action, tableHandler(func(w http.ResponseWriter, r *http.Request, hub *Hub) {
+			var req adminReasonRequest
+			if !readAdminRequest(w, r, &req) {
+				return
+			}
+			if hub.setPaused(paused) {
+				lobby.audit(r, AuditEntry{Action: action, TableID: hub.config.ID, Reason: req.Reason})
+			}
+			writeJSON(w, http.StatusOK, hub.summary())
+		}))
+	}
+	handle("POST /api/admin/tables/{id}/end-hand", tableHandler(func(w http.ResponseWriter, r *http.Request, hub *Hub) {
+		var req adminReasonRequest
+		if !readAdminRequest(w, r, &req) {
+			return
+		}
+		handID, err := hub.forceEndHand(req.Reason)
+		if err != nil {
+			writeAPIError(w, http.StatusConflict, err.Error())
+			return
+		}
+		lobby.audit(r, AuditEntry{Action: "end_hand", TableID: hub.config.ID, Target: handID, Reason: req.Reason})
+		writeJSON(w, http.StatusOK, hub.summary())
+	}))
+	handle("POST /api/admin/tables/{id}/kick", tableHandler(func(w http.ResponseWriter, r *http.Request, hub *Hub) {
+		var req adminKickRequest
+		if !readAdminRequest(w, r, &req) {
+			return
+		}
+		id, ok := hub.kick(req.Player, "removed by an operator")
+		if !ok {
+			writeAPIError(w, http.StatusNotFound, "no connection with that ID or name at this table")
+			return
+		}
+		lobby.audit(r, AuditEntry{Action: "kick", TableID: hub.config.ID, Target: id, Reason: req.Reason})
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	handle("GET /api/admin/tables/{id}/bots", tableHandler(func(w http.ResponseWriter, r *http.Request, hub *Hub) {
+		writeJSON(w, http.StatusOK, hub.listBots())
+	}))
+	handle("POST /api/admin/tables/{id}/bots", tableHandler(func(w http.ResponseWriter, r *http.Request, hub *Hub) {
+		var req adminBotRequest
+		if !readAdminRequest(w, r, &req) {
+			return
+		}
+		think := defaultBotThink
+		if req.ThinkMs != nil {
+			think = time.Duration(*req.ThinkMs) * time.Millisecond
+		}
+		if think < 0 || think > maxBotThink {
+			writeAPIError(w, http.StatusBadRequest, "thinkMs must be between 0 and "+strconv.FormatInt(maxBotThink.Milliseconds(), 10))
+			return
+		}
+		if req.Name != "" {
+			if err := validateBotName(req.Name); err != nil {
+				writeAPIError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		info, err := hub.addBot(req.Strategy, req.Name, think, req.BuyIn)
+		var perr *ProtocolError
+		switch {
+		case errors.As(err, &perr):
+			writeAPIError(w, http.StatusConflict, perr.Message)
+			return
+		case err != nil:
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		lobby.audit(r, AuditEntry{Action: "add_bot", TableID: hub.config.ID, Target: info.Name, Amount: info.Chips, Reason: req.Reason})
+		writeJSON(w, http.StatusCreated, info)
+	}))
+	handle("DELETE /api/admin/tables/{id}/bots/{bot}", tableHandler(func(w http.ResponseWriter, r *http.Request, hub *Hub) {
+		var req adminReasonRequest
+		if !readAdminRequest(w, r, &req) {
+			return
+		}
+		info, ok := hub.removeBot(r.PathValue("bot"))
+		if !ok {
+			writeAPIError(w, http.StatusNotFound, "no bot with that ID or name at this table")
+			return
+		}
+		lobby.audit(r, AuditEntry{Action: "remove_bot", TableID: hub.config.ID, Target: info.Name, Reason: req.Reason})
+		writeJSON(w, http.StatusOK, info)
+	}))
+	handle("POST /api/admin/bot-keys", func(w http.ResponseWriter, r *http.Request) {
+		var req issueBotKeyRequest
+		if !readAdminRequest(w, r, &req) {
+			return
+		}
+		if err := validateBotName(req.Name); err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		account, key, err := lobby.accounts.IssueBotKey(req.Name)
+		if errors.Is(err, errHumanAccount) {
+			writeAPIError(w, http.StatusConflict, req.Name+" is a player's name")
+			return
+		} else if err != nil {
+			slog.Error("issuing bot key failed", "err", err)
+			writeAPIError(w, http.StatusInternalServerError, "could not issue a key")
+			return
+		}
+		lobby.audit(r, AuditEntry{Action: "issue_bot_key", Target: account.ID, Reason: req.Reason})
+		writeJSON(w, http.StatusCreated, BotKey{Account: account, APIKey: key})
+	})
+	handle("POST /api/admin/broadcast", func(w http.ResponseWriter, r *http.Request) {
+		var req adminBroadcastRequest
+		if !readAdminRequest(w, r, &req) {
+			return
+		}
+		req.Message = strings.TrimSpace(req.Message)
+		if req.Message == "" || len(req.Message) > 500 {
+			writeAPIError(w, http.StatusBadRequest, "message must be 1 to 500 characters")
+			return
+		}
+		hubs := lobby.tableList()
+		if req.Table != "" {
+			hub := lobby.table(req.Table)
+			if hub == nil {
+				writeAPIError(w, http.StatusNotFound, "table not found")
+				return
+			}
+			hubs = []*Hub{hub}
+		}
+		for _, hub := range hubs {
+			hub.announce(req.Message)
+		}
+		lobby.audit(r, AuditEntry{Action: "broadcast", TableID: req.Table, Reason: req.Message})
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	setBanned := func(banned bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			var req adminReasonRequest
+			if !readAdminRequest(w, r, &req) {
+				return
+			}
+			found, _, ok := lobby.accounts.Lookup(r.PathValue("id"))
+			if !ok {
+				writeAPIError(w, http.StatusNotFound, "player not found")
+				return
+			}
+			account, _ := lobby.accounts.SetBanned(found.ID, banned)
+			action := "unban"
+			if banned {
+				action = "ban"
+				for _, hub := range lobby.tableList() {
+					hub.kickAccount(account.ID, "banned by an operator")
+				}
+			}
+			lobby.audit(r, AuditEntry{Action: action, Target: account.ID, Reason: req.Reason})
+			writeJSON(w, http.StatusOK, PlayerProfile{Account: account})
+		}
+	}
+	handle("POST /api/admin/players/{id}/ban", setBanned(true))
+	handle("DELETE /api/admin/players/{id}/ban", setBanned(false))
+	handle("POST /api/admin/players/{id}/chips", func(w http.ResponseWriter, r *http.Request) {
+		var req adminChipsRequest
+		if !readAdminRequest(w, r, &req) {
+			return
+		}
+		if req.Amount == 0 || strings.TrimSpace(req.Reason) == "" {
+			writeAPIError(w, http.StatusBadRequest, "amount must not be zero and a reason is required")
+			return
+		}
+		account, tableID, ok := lobby.accounts.Lookup(r.PathValue("id"))
+		if !ok {
+			writeAPIError(w, http.StatusNotFound, "player not found")
+			return
+		}
+		profile, err := lobby.adjustChips(account.ID, tableID, req.Amount, req.Reason)
+		var perr *ProtocolError
+		switch {
+		case errors.As(err, &perr):
+			writeAPIError(w, http.StatusConflict, perr.Message)
+			return
+		case errors.Is(err, errAccountInUse):
+			writeAPIError(w, http.StatusConflict, "the player just sat down; try again")
+			return
+		case err != nil:
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		lobby.audit(r, AuditEntry{Action: "adjust_chips", TableID: profile.SeatedAt, Target: account.ID, Amount: req.Amount, Reason: req.Reason})
+		writeJSON(w, http.StatusOK, profile)
+	})
+}
+
+// requireAdmin only lets requests with the admin bearer token through.
+func requireAdmin(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeAPIError(w, http.StatusUnauthorized, "admin token required")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// readAdminRequest decodes an optional JSON body into v.
+func readAdminRequest(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		writeAPIError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func (l *Lobby) audit(r *http.Request, e AuditEntry) {
+	e.At = time.Now()
+	e.Remote = r.RemoteAddr
+	slog.Info("admin action", "action", e.Action, "table", e.TableID, "target", e.Target, "amount", e.Amount, "reason", e.Reason, "remote", e.Remote)
+	if err := l.store.AppendAudit(e); err != nil {
+		slog.Error("writing audit entry failed", "err", err)
+	}
+}
+
+func (l *Lobby) adminStats() AdminStats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	stats := AdminStats{
+		StartedAt:     serverStartedAt,
+		UptimeSeconds: int64(time.Since(serverStartedAt).Seconds()),
+		Accounts:      l.accounts.Count(),
+		Goroutines:    runtime.NumGoroutine(),
+		MemoryBytes:   mem.Alloc,
+		Tables:        []AdminTableStats{},
+	}
+	for _, hub := range l.tableList() {
+		m := hub.readMetrics()
+		stats.Connections += m.connections
+		stats.Tables = append(stats.Tables, AdminTableStats{
+			TableSummary:   hub.summary(),
+			Connections:    m.connections,
+			HandsPlayed:    int(metrics.handsTotal.value(hub.config.ID)),
+			HandsPerMinute: m.handsPerMin,
+		})
+	}
+	return stats
+}
+
+// adjustChips changes a player's chips: the table stack while they are
+// seated, otherwise the bankroll.
+func (l *Lobby) adjustChips(accountID, tableID string, delta int, reason string) (PlayerProfile, error) {
+	if hub := l.table(tableID); hub != nil {
+		chips, err := hub.adjustChips(accountID, delta, reason)
+		if err == nil {
+			account, _ := l.accounts.Get(accountID)
+			return PlayerProfile{Account: account, SeatedAt: tableID, TableChips: &chips}, nil
+		}
+		if !errors.Is(err, errNotAtTable) {
+			return PlayerProfile{}, err
+		}
+		// Stood up in the meantime, so the bankroll holds the chips
+	}
+	account, err := l.accounts.AdjustBankroll(accountID, delta, reason)
+	return PlayerProfile{Account: account}, err
+}
+
+// setPaused pauses or resumes the table and reports whether that changed
+// anything. A paused table deals no new hands and refuses betting actions.
+func (h *Hub) setPaused(paused bool) bool {
+	h.gameStateMutex.Lock()
+	defer h.gameStateMutex.Unlock()
+	if h.gameState.Paused == paused {
+		return false
+	}
+	h.gameState.Paused = paused
+	if paused {
+		h.addSystemChatMessage("The table has been paused by an operator.")
+	} else {
+		h.addSystemChatMessage("The table has been resumed.")
+		h.startIfReadyUnsafe()
+	}
+	h.logUnsafe().Info("table paused", "paused", paused)
+	h.broadcastGameStateUnsafe()
+	return true
+}
+
+// announce posts a system chat message.
+func (h *Hub) announce(message string) {
+	h.gameStateMutex.Lock()
+	defer h.gameStateMutex.Unlock()
+	h.addSystemChatMessage(message)
+	h.broadcastGameStateUnsafe()
+}
+
+// kick disconnects a player or spectator, found by connection ID or name,
+// and returns their connection ID. A seated player in a hand folds.
+func (h *Hub) kick(idOrName, reason string) (string, bool) {
+	h.gameStateMutex.Lock()
+	defer h.gameStateMutex.Unlock()
+	id := idOrName
+	if _, ok := h.clients[id]; !ok {
+		id = ""
+		for pid, p := range h.gameState.Players {
+			if strings.EqualFold(p.Name, idOrName) && p.IsConnected {
+				id = pid
+			}
+		}
+		for sid, s := range h.gameState.Spectators {
+			if s.Name != "" && strings.EqualFold(s.Name, idOrName) {
+				id = sid
+			}
+		}
+	}
+	if _, ok := h.clients[id]; !ok {
+		return "", false
+	}
+	h.kickUnsafe(id, reason)
+	return id, true
+}
+
+// kickAccount disconnects everything the account has open at the table: its
+// seat, its spectator connections and its event streams.
+func (h *Hub) kickAccount(accountID, reason string) {
+	h.gameStateMutex.Lock()
+	defer h.gameStateMutex.Unlock()
+	for id, p := range h.gameState.Players {
+		if p.AccountID == accountID && h.bots[id] != nil && p.IsConnected {
+			h.disconnectBotUnsafe(id)
+		}
+	}
+	for id, client := range h.clients {
+		if client.accountID == accountID || h.gameState.Players[id].AccountID == accountID {
+			h.kickUnsafe(id, reason)
+		}
+	}
+	for watcher := range h.watchers {
+		if watcher.accountID == accountID {
+			delete(h.watchers, watcher)
+			close(watcher.send)
+		}
+	}
+}
+
+func (h *Hub) kickUnsafe(clientID, reason string) {
+	client := h.clients[clientID]
+	client.closeMsg = websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+	delete(h.clients, clientID)
+	close(client.send)
+	h.logUnsafe().Info("connection kicked", "client", clientID, "reason", reason)
+	h.handleDisconnectUnsafe(clientID)
+}
+
+// adjustChips adds delta to the stack of the player holding the account. It
+// is refused while the player is dealt into a hand.
+func (h *Hub) adjustChips(accountID string, delta int, reason string) (int, error) {
+	h.gameStateMutex.Lock()
+	defer h.gameStateMutex.Unlock()
+	for id, p := range h.gameState.Players {
+		if p.AccountID != accountID {
+			continue
+		}
+		if h.gameState.GameStarted && slices.Contains(h.gameState.PlayerOrder, id) {
+			return 0, protocolErrorf(CodeHandInProgress, "%s is in a hand; try again when it ends", p.Name)
+		}
+		if p.Chips+delta < 0 {
+			return 0, errors.New("the stack cannot go below zero")
+		}
+		p.Chips += delta
+		h.gameState.Players[id] = p
+		h.emitEventUnsafe(GameEvent{Type: EventChipsAdjusted, PlayerID: id, Amount: delta, Reason: reason})
+		h.accounts.AdjustStack(accountID, delta, reason)
+		h.broadcastGameStateUnsafe()
+		return p.Chips, nil
+	}
+	return 0, errNotAtTable
+}
+
+// forceEndHand ends a stuck hand and returns its ID.
+func (h *Hub) forceEndHand(reason string) (string, error) {
+	h.gameStateMutex.Lock()
+	defer h.gameStateMutex.Unlock()
+	if !h.gameState.GameStarted {
+		return "", errNoHandRunning
+	}
+	if reason == "" {
+		reason = "Hand ended by an operator"
+	}
+	handID := h.gameState.HandID
+	if h.gameState.GamePhase == "showdown" {
+		h.endGameUnsafe(reason) // the pot is already paid out
+	} else {
+		h.cancelHandUnsafe(reason) // everyone gets back what they put in
+	}
+	h.broadcastGameStateUnsafe()
+	return handID, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestForceEndHandRefundsWhatEachPlayerPutIn(t *testing.T) {
+	lobby := newTestLobby(t)
+	h := lobby.table(defaultTableID)
+	alice := sitDown(t, h, "Alice", 400)
+	bob := sitDown(t, h, "Bob", 1000)
+	carol := sitDown(t, h, "Carol", 2000)
+	dave := sitDown(t, h, "Dave", 1000)
+	dealHand(t, h, alice, bob, carol, dave)
+
+	// Alice has the button and goes all in; Dave calls the blind and folds
+	act(t, h, dave, "call", 0)
+	act(t, h, alice, "raise", 400)
+	act(t, h, bob, "call", 0)
+	act(t, h, carol, "call", 0)
+	act(t, h, dave, "fold", 0)
+	act(t, h, bob, "raise", 700)
+	if _, err := h.forceEndHand("test"); err != nil {
+		t.Fatal(err)
+	}
+	checkEventLog(t, h, "after the hand was ended")
+
+	s := snapshot(h)
+	want := map[string]int{alice: 400, bob: 1000, carol: 2000, dave: 1000}
+	for id, chips := range want {
+		if got := s.Players[id].Chips; got != chips {
+			t.Errorf("%s has %d chips, want %d", s.Players[id].Name, got, chips)
+		}
+	}
+	if report := lobby.ledgerReport(); !report.Balanced {
+		t.Errorf("ledger does not balance: %v", report.Mismatches)
+	}
+}
+
+func TestBanClosesEveryConnection(t *testing.T) {
+	lobby := newTestLobby(t)
+	mux := http.NewServeMux()
+	registerAPI(mux, lobby)
+	registerAdminAPI(mux, lobby, "secret")
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) { serveWs(lobby, w, r) })
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	account, err := lobby.accounts.Register("Mallory", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _ := lobby.sessions.issue(account)
+
+	// A spectator connection and an event stream, both as Mallory
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?token="+token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	hello, _ := json.Marshal(HelloPayload{ProtocolVersion: ProtocolVersion})
+	if err := conn.WriteJSON(Message{Type: "hello", Payload: hello}); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	var welcome Message
+	if err := conn.ReadJSON(&welcome); err != nil || welcome.Type != "welcome" {
+		t.Fatalf("got %s, %v, want welcome", welcome.Type, err)
+	}
+	req, _ := http.NewRequest("GET", srv.URL+"/api/tables/"+defaultTableID+"/events", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	client := &http.Client{Timeout: 10 * time.Second}
+	stream, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Body.Close()
+	events := bufio.NewReader(stream.Body)
+	if line, err := events.ReadString('\n'); err != nil || line != "event: state\n" {
+		t.Fatalf("stream starts with %q, %v", line, err)
+	}
+
+	r, _ := http.NewRequest("POST", srv.URL+"/api/admin/players/"+account.ID+"/ban", strings.NewReader(`{"reason":"test"}`))
+	r.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("ban: status %d", resp.StatusCode)
+	}
+
+	for {
+		var msg Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+				t.Errorf("spectator connection ended with %v, want a policy violation close", err)
+			}
+			break
+		}
+	}
+	if _, err := io.Copy(io.Discard, events); err != nil {
+		t.Errorf("event stream ended with %v, want it closed", err)
+	}
+
+	// A banned account cannot open a new stream
+	stream, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Body.Close()
+	if stream.StatusCode != http.StatusForbidden {
+		t.Errorf("banned account opened a stream: status %d", stream.StatusCode)
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// --- HTTP API ---
+//
+// A read-mostly JSON API over the same hubs the WebSocket clients play on.
+// Hole cards are never exposed while a hand is running.
+
+const maxAPIHands = 200
+
+var tableIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// TableSummary is a table as listed by the API.
+type TableSummary struct {
+	TableConfig
+	Players     int    `json:"players"`
+	Connected   int    `json:"connected"`
+	Spectators  int    `json:"spectators"`
+	Waiting     int    `json:"waiting"`
+	Paused      bool   `json:"paused"`
+	GameStarted bool   `json:"gameStarted"`
+	GamePhase   string `json:"gamePhase"`
+	HandID      string `json:"handId,omitempty"`
+}
+
+// TableDetail is a table with its public state.
+type TableDetail struct {
+	TableSummary
+	State GameState `json:"state"`
+}
+
+// PlayerProfile is an account as shown by the API.
+type PlayerProfile struct {
+	Account
+	SeatedAt   string `json:"seatedAt,omitempty"`
+	TableChips *int   `json:"tableChips,omitempty"` // live stack while seated
+}
+
+// HandSummary is a hand history without its actions, events and frames.
+type HandSummary struct {
+	ID        string       `json:"id"`
+	TableID   string       `json:"tableId"`
+	StartedAt time.Time    `json:"startedAt"`
+	EndedAt   time.Time    `json:"endedAt"`
+	Players   []HandPlayer `json:"players"`
+	Board     []Card       `json:"board"`
+	Winners   []string     `json:"winners"`
+	Result    string       `json:"result"`
+}
+
+type createTableRequest struct {
+	ID            string  `json:"id"`
+	Name          string  `json:"name"`
+	SmallBlind    int     `json:"smallBlind"`
+	BigBlind      int     `json:"bigBlind"`
+	StartingChips int     `json:"startingChips"`
+	MinBuyIn      int     `json:"minBuyIn"`
+	MaxBuyIn      int     `json:"maxBuyIn"`
+	RebuyTo       int     `json:"rebuyTo"`
+	AddOnChips    int     `json:"addOnChips"`
+	AddOnHands    int     `json:"addOnHands"`
+	RakePercent   float64 `json:"rakePercent"`
+	RakeCap       int     `json:"rakeCap"`
+	NoFlopNoDrop  bool    `json:"noFlopNoDrop"`
+	HUD           bool    `json:"hud"`
+	MaxSeats      int     `json:"maxSeats"`
+	Reason        string  `json:"reason"` // for the audit log
+}
+
+func registerAPI(mux *http.ServeMux, lobby *Lobby) {
+	mux.HandleFunc("GET /api/tables", func(w http.ResponseWriter, r *http.Request) {
+		hubs := lobby.tableList()
+		tables := make([]TableSummary, 0, len(hubs))
+		for _, hub := range hubs {
+			tables = append(tables, hub.summary())
+		}
+		writeJSON(w, http.StatusOK, tables)
+	})
+	mux.HandleFunc("GET /api/tables/{id}", func(w http.ResponseWriter, r *http.Request) {
+		hub := lobby.table(r.PathValue("id"))
+		if hub == nil {
+			writeAPIError(w, http.StatusNotFound, "table not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, TableDetail{TableSummary: hub.summary(), State: hub.publicState()})
+	})
+	mux.HandleFunc("GET /api/tables/{id}/events", func(w http.ResponseWriter, r *http.Request) {
+		serveTableEvents(lobby, w, r)
+	})
+	mux.HandleFunc("GET /api/players/{id}", func(w http.ResponseWriter, r *http.Request) {
+		account, tableID, ok := lobby.accounts.Lookup(r.PathValue("id"))
+		if !ok {
+			writeAPIError(w, http.StatusNotFound, "player not found")
+			return
+		}
+		profile := PlayerProfile{Account: account, SeatedAt: tableID}
+		if hub := lobby.table(tableID); tableID != "" && hub != nil {
+			if chips, ok := hub.accountChips(account.ID); ok {
+				profile.TableChips = &chips
+			}
+		}
+		writeJSON(w, http.StatusOK, profile)
+	})
+	mux.HandleFunc("GET /api/hands", func(w http.ResponseWriter, r *http.Request) {
+		limit := 50
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxAPIHands {
+				writeAPIError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxAPIHands))
+				return
+			}
+			limit = n
+		}
+		hubs := lobby.tableList()
+		if id := r.URL.Query().Get("table"); id != "" {
+			hub := lobby.table(id)
+			if hub == nil {
+				writeAPIError(w, http.StatusNotFound, "table not found")
+				return
+			}
+			hubs = []*Hub{hub}
+		}
+		var hands []HandSummary
+		for _, hub := range hubs {
+			hands = append(hands, hub.recentHands(limit)...)
+		}
+		sort.Slice(hands, func(i, j int) bool { return hands[i].EndedAt.After(hands[j].EndedAt) })
+		if len(hands) > limit {
+			hands = hands[:limit]
+		}
+		writeJSON(w, http.StatusOK, hands)
+	})
+	mux.HandleFunc("GET /api/players/{id}/stats", func(w http.ResponseWriter, r *http.Request) {
+		account, _, ok := lobby.accounts.Lookup(r.PathValue("id"))
+		if !ok {
+			writeAPIError(w, http.StatusNotFound, "player not found")
+			return
+		}
+		stats := lobby.stats.Player(account.ID)
+		stats.Name = account.Name
+		writeJSON(w, http.StatusOK, stats)
+	})
+	mux.HandleFunc("GET /api/leaderboards", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		period, board, limit := q.Get("period"), q.Get("board"), defaultLeaderboardSize
+		if period == "" {
+			period = "all_time"
+		}
+		if board == "" {
+			board = "net"
+		}
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				writeAPIError(w, http.StatusBadRequest, "limit must be a number")
+				return
+			}
+			limit = n
+		}
+		lb, err := lobby.leaders.Board(period, board, limit)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, lb)
+	})
+	mux.HandleFunc("GET /api/hands/{id}", func(w http.ResponseWriter, r *http.Request) {
+		hist := lobby.handHistory(r.PathValue("id"))
+		if hist == nil {
+			writeAPIError(w, http.StatusNotFound, "hand not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, hist.public())
+	})
+}
+
+func (req createTableRequest) tableConfig() (TableConfig, error) {
+	cfg := TableConfig{
+		ID:            strings.ToLower(strings.TrimSpace(req.ID)),
+		Name:          strings.TrimSpace(req.Name),
+		SmallBlind:    req.SmallBlind,
+		BigBlind:      req.BigBlind,
+		StartingChips: req.StartingChips,
+		MinBuyIn:      req.MinBuyIn,
+		MaxBuyIn:      req.MaxBuyIn,
+		RebuyTo:       req.RebuyTo,
+		AddOnChips:    req.AddOnChips,
+		AddOnHands:    req.AddOnHands,
+		RakePercent:   req.RakePercent,
+		RakeCap:       req.RakeCap,
+		NoFlopNoDrop:  req.NoFlopNoDrop,
+		HUD:           req.HUD,
+		MaxSeats:      req.MaxSeats,
+		CreatedAt:     time.Now(),
+	}
+	if cfg.ID == "" {
+		cfg.ID = uuid.New().String()[:8]
+	}
+	if cfg.SmallBlind == 0 && cfg.BigBlind == 0 {
+		cfg.SmallBlind, cfg.BigBlind = SmallBlindAmt, BigBlindAmt
+	}
+	if cfg.MaxSeats == 0 {
+		cfg.MaxSeats = defaultMaxSeats
+	}
+	if cfg.StartingChips == 0 {
+		cfg.StartingChips = cfg.BigBlind * 50
+	}
+	cfg.setBuyInDefaults()
+	switch {
+	case !tableIDPattern.MatchString(cfg.ID):
+		return cfg, errors.New("id must be 1-32 lower-case letters, digits or dashes")
+	case cfg.Name == "" || len(cfg.Name) > 64:
+		return cfg, errors.New("name must be 1-64 characters")
+	case cfg.SmallBlind <= 0 || cfg.BigBlind < cfg.SmallBlind:
+		return cfg, errors.New("blinds must be positive and the big blind at least the small blind")
+	case cfg.StartingChips < cfg.BigBlind:
+		return cfg, errors.New("startingChips must cover at least one big blind")
+	case cfg.MinBuyIn < cfg.BigBlind || cfg.MinBuyIn > cfg.StartingChips || cfg.MaxBuyIn < cfg.StartingChips:
+		return cfg, errors.New("buy-in limits must be at least one big blind and take in startingChips")
+	case cfg.RebuyTo < cfg.MinBuyIn || cfg.RebuyTo > cfg.MaxBuyIn:
+		return cfg, errors.New("rebuyTo must be within the buy-in limits")
+	case cfg.AddOnChips < 0 || cfg.AddOnHands < 0:
+		return cfg, errors.New("addOnChips and addOnHands cannot be negative")
+	case cfg.RakePercent < 0 || cfg.RakePercent > maxRakePercent || cfg.RakeCap < 0:
+		return cfg, fmt.Errorf("rakePercent must be between 0 and %d and rakeCap cannot be negative", maxRakePercent)
+	case cfg.MaxSeats < minSeats || cfg.MaxSeats > maxSeats:
+		return cfg, fmt.Errorf("maxSeats must be between %d and %d", minSeats, maxSeats)
+	}
+	return cfg, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("writing API response failed", "err", err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+func (h *Hub) summary() TableSummary {
+	h.gameStateMutex.RLock()
+	defer h.gameStateMutex.RUnlock()
+	return h.summaryUnsafe()
+}
+
+func (h *Hub) summaryUnsafe() TableSummary {
+	s := TableSummary{
+		TableConfig: h.config,
+		Players:     len(h.gameState.Players),
+		Spectators:  len(h.gameState.Spectators),
+		Waiting:     len(h.waitlist),
+		Paused:      h.gameState.Paused,
+		GameStarted: h.gameState.GameStarted,
+		GamePhase:   h.gameState.GamePhase,
+	}
+	if h.gameState.GameStarted {
+		s.HandID = h.gameState.HandID
+	}
+	for _, p := range h.gameState.Players {
+		if p.IsConnected {
+			s.Connected++
+		}
+	}
+	return s
+}
+
+// publicState is the table state anyone may see: hole cards are only shown
+// for the players still in the hand at showdown.
+func (h *Hub) publicState() GameState {
+	h.gameStateMutex.RLock()
+	defer h.gameStateMutex.RUnlock()
+	return h.publicStateUnsafe()
+}
+
+func (h *Hub) publicStateUnsafe() GameState {
+	state := h.gameState
+	state.Players = make(map[string]Player, len(h.gameState.Players))
+	for id, p := range h.gameState.Players {
+		state.Players[id] = publicPlayer(p, state.GamePhase)
+	}
+	state.PlayerReady = make(map[string]bool, len(h.playerReady))
+	for id, ready := range h.playerReady {
+		state.PlayerReady[id] = ready
+	}
+	state.SidePots = slices.Clone(h.gameState.SidePots)
+	state.PlayerOrder = slices.Clone(h.gameState.PlayerOrder)
+	state.CommunityCards = slices.Clone(h.gameState.CommunityCards)
+	state.ChatMessages = slices.Clone(h.gameState.ChatMessages)
+	state.Spectators = maps.Clone(h.gameState.Spectators)
+	return state
+}
+
+// publicPlayer hides the hole cards of a player who did not show them down,
+// and their account.
+func publicPlayer(p Player, phase string) Player {
+	if !(phase == "showdown" && p.IsInHand) {
+		p.Hand = nil
+	}
+	p.AccountID = ""
+	return p
+}
+
+// accountChips returns the live stack of the player bound to an account.
+func (h *Hub) accountChips(accountID string) (int, bool) {
+	h.gameStateMutex.RLock()
+	defer h.gameStateMutex.RUnlock()
+	for _, p := range h.gameState.Players {
+		if p.AccountID == accountID {
+			return p.Chips, true
+		}
+	}
+	return 0, false
+}
+
+// recentHands summarises up to limit of the table's latest hands, newest first.
+func (h *Hub) recentHands(limit int) []HandSummary {
+	h.gameStateMutex.RLock()
+	defer h.gameStateMutex.RUnlock()
+	hands := make([]HandSummary, 0, min(limit, len(h.handHistories)))
+	for i := len(h.handHistories) - 1; i >= 0 && len(hands) < limit; i-- {
+		hist := h.handHistories[i]
+		hands = append(hands, HandSummary{
+			ID:        hist.ID,
+			TableID:   hist.TableID,
+			StartedAt: hist.StartedAt,
+			EndedAt:   hist.EndedAt,
+			Players:   publicHandPlayers(hist.Players, showdownPlayers(hist.Events)),
+			Board:     hist.Board,
+			Winners:   hist.Winners,
+			Result:    hist.Result,
+		})
+	}
+	return hands
+}
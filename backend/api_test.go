@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCreateTableNeedsAdminToken(t *testing.T) {
+	lobby := newTestLobby(t)
+	mux := http.NewServeMux()
+	registerAPI(mux, lobby)
+	registerAdminAPI(mux, lobby, "secret")
+
+	tests := []struct {
+		auth string
+		want int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer guess", http.StatusUnauthorized},
+		{"Bearer secret", http.StatusCreated},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/api/tables", strings.NewReader(`{"id":"side","name":"Side Table","reason":"test"}`))
+		if tt.auth != "" {
+			r.Header.Set("Authorization", tt.auth)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("POST /api/tables with %q: status %d, want %d", tt.auth, w.Code, tt.want)
+		}
+	}
+	if lobby.table("side") == nil {
+		t.Error("the table was not created")
+	}
+}
+
+func TestHandsAPIHidesMuckedCards(t *testing.T) {
+	lobby := newTestLobby(t)
+	h := lobby.table(defaultTableID)
+	playFoldAndShowdown(t, h)
+	handID := snapshot(h).HandID
+	endShowdown(t, h)
+	mux := http.NewServeMux()
+	registerAPI(mux, lobby)
+
+	for _, path := range []string{"/api/hands", "/api/hands/" + handID} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: status %d", path, w.Code)
+		}
+		if strings.Contains(w.Body.String(), `"accountId"`) {
+			t.Errorf("GET %s returns account IDs", path)
+		}
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// --- Accounts and sessions ---
+//
+// Players register an account with a password and log in over the HTTP API,
+// which hands out a signed session token. serveWs checks the token before
+// upgrading, and a connection can only sit down as its own account.
+// Passwords are stored as salted PBKDF2-SHA256 hashes. Connections without a
+// token may watch; with -allow-guests they may also sit down as an existing
+// account without a password.
+
+const (
+	sessionTTL        = 7 * 24 * time.Hour
+	pbkdf2Iterations  = 600_000
+	minPasswordLength = 8
+	maxPasswordLength = 128
+	maxNameLength     = 32
+	// Each address may try authAttemptBurst logins or registrations at once,
+	// then one more every authAttemptEvery.
+	authAttemptBurst = 10
+	authAttemptEvery = 6 * time.Second
+)
+
+var (
+	errNoSession      = errors.New("no session token")
+	errBadSession     = errors.New("invalid or expired session")
+	errBadCredentials = errors.New("wrong name or password")
+	errNameTaken      = errors.New("that name is already registered")
+)
+
+// allowGuests is set by -allow-guests: connections without a session may
+// sit down as an account that has no password.
+var allowGuests bool
+
+// Session is the signed content of a session token.
+type Session struct {
+	AccountID string `json:"sub"`
+	Name      string `json:"name"`
+	Expires   int64  `json:"exp"` // Unix seconds
+}
+
+// sessionSigner issues and checks tokens of the form
+// base64url(json) "." base64url(HMAC-SHA256(key, json)).
+type sessionSigner struct {
+	key []byte
+}
+
+func (s sessionSigner) issue(a Account) (string, time.Time) {
+	expires := time.Now().Add(sessionTTL)
+	payload, _ := json.Marshal(Session{AccountID: a.ID, Name: a.Name, Expires: expires.Unix()})
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(s.mac(payload)), expires
+}
+
+func (s sessionSigner) verify(token string) (Session, error) {
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return Session{}, errBadSession
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return Session{}, errBadSession
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, s.mac(payload)) {
+		return Session{}, errBadSession
+	}
+	var sess Session
+	if err := json.Unmarshal(payload, &sess); err != nil || time.Now().Unix() >= sess.Expires {
+		return Session{}, errBadSession
+	}
+	return sess, nil
+}
+
+func (s sessionSigner) mac(payload []byte) []byte {
+	m := hmac.New(sha256.New, s.key)
+	m.Write(payload)
+	return m.Sum(nil)
+}
+
+// hashPassword returns "pbkdf2-sha256$<iterations>$<salt>$<hash>".
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, pbkdf2Iterations, 32)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", pbkdf2Iterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func checkPassword(encoded, password string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	got, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(want))
+	return err == nil && subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// validateBotName checks a name for a bot account, by the rules for
+// players' names.
+func validateBotName(name string) error {
+	switch {
+	case name == "" || utf8.RuneCountInString(name) > maxNameLength || name != strings.TrimSpace(name):
+		return fmt.Errorf("name must be 1 to %d characters without leading or trailing spaces", maxNameLength)
+	case strings.EqualFold(name, "system"):
+		return errors.New("that name is reserved")
+	}
+	return nil
+}
+
+// dummyPasswordHash is checked against when a name is unknown, so a failed
+// login takes as long whether or not the account exists.
+var dummyPasswordHash, _ = hashPassword("not a real password")
+
+func validateCredentials(name, password string) error {
+	switch {
+	case name == "" || utf8.RuneCountInString(name) > maxNameLength || name != strings.TrimSpace(name):
+		return fmt.Errorf("name must be 1 to %d characters without leading or trailing spaces", maxNameLength)
+	case strings.EqualFold(name, "system"):
+		return errors.New("that name is reserved")
+	case len(password) < minPasswordLength || len(password) > maxPasswordLength:
+		return fmt.Errorf("password must be %d to %d characters", minPasswordLength, maxPasswordLength)
+	}
+	return nil
+}
+
+type credentialsRequest struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+// SessionResponse is returned by register and login.
+type SessionResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Account   Account   `json:"account"`
+}
+
+func registerAuthAPI(mux *http.ServeMux, lobby *Lobby) {
+	limiter := newIPLimiter(authAttemptBurst, authAttemptEvery)
+	mux.HandleFunc("POST /api/auth/register", func(w http.ResponseWriter, r *http.Request) {
+		if !limiter.admit(w, r) {
+			return
+		}
+		var req credentialsRequest
+		if !readCredentials(w, r, &req) {
+			return
+		}
+		if err := validateCredentials(req.Name, req.Password); err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		hash, err := hashPassword(req.Password)
+		if err != nil {
+			slog.Error("hashing password failed", "err", err)
+			writeAPIError(w, http.StatusInternalServerError, "could not register")
+			return
+		}
+		account, err := lobby.accounts.Register(req.Name, hash)
+		switch {
+		case errors.Is(err, errNameTaken):
+			writeAPIError(w, http.StatusConflict, err.Error())
+			return
+		case err != nil:
+			writeAPIError(w, http.StatusInternalServerError, "could not register")
+			return
+		}
+		slog.Info("account registered", "account", account.ID, "name", account.Name)
+		token, expires := lobby.sessions.issue(account)
+		writeJSON(w, http.StatusCreated, SessionResponse{Token: token, ExpiresAt: expires, Account: account})
+	})
+	mux.HandleFunc("POST /api/auth/login", func(w http.ResponseWriter, r *http.Request) {
+		if !limiter.admit(w, r) {
+			return
+		}
+		var req credentialsRequest
+		if !readCredentials(w, r, &req) {
+			return
+		}
+		account, err := lobby.accounts.Authenticate(req.Name, req.Password)
+		switch {
+		case errors.Is(err, errAccountBanned):
+			writeAPIError(w, http.StatusForbidden, err.Error())
+			return
+		case err != nil:
+			writeAPIError(w, http.StatusUnauthorized, errBadCredentials.Error())
+			return
+		}
+		token, expires := lobby.sessions.issue(account)
+		writeJSON(w, http.StatusOK, SessionResponse{Token: token, ExpiresAt: expires, Account: account})
+	})
+	mux.HandleFunc("GET /api/auth/session", func(w http.ResponseWriter, r *http.Request) {
+		account, err := lobby.authenticate(r)
+		if err != nil {
+			writeAPIError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, account)
+	})
+}
+
+// ipLimiter is a token bucket per client address. It keeps password guessing
+// slow and stops one client from tying up the CPU with password hashing.
+type ipLimiter struct {
+	mu      sync.Mutex
+	burst   float64
+	every   time.Duration
+	buckets map[string]*ipBucket
+}
+
+type ipBucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// maxIPBuckets bounds the addresses an ipLimiter tracks; full buckets are
+// forgotten first.
+const maxIPBuckets = 10_000
+
+func newIPLimiter(burst int, every time.Duration) *ipLimiter {
+	return &ipLimiter{burst: float64(burst), every: every, buckets: make(map[string]*ipBucket)}
+}
+
+// allow takes a token from addr's bucket and reports whether there was one,
+// or else how long until there is.
+func (l *ipLimiter) allow(addr string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[addr]
+	if !ok {
+		if len(l.buckets) >= maxIPBuckets {
+			l.pruneLocked(now)
+		}
+		b = &ipBucket{tokens: l.burst, at: now}
+		l.buckets[addr] = b
+	}
+	b.tokens = min(l.burst, b.tokens+float64(now.Sub(b.at))/float64(l.every))
+	b.at = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) * float64(l.every))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// pruneLocked forgets the addresses whose buckets have refilled, or all of
+// them if none has.
+func (l *ipLimiter) pruneLocked(now time.Time) {
+	for addr, b := range l.buckets {
+		if b.tokens+float64(now.Sub(b.at))/float64(l.every) >= l.burst {
+			delete(l.buckets, addr)
+		}
+	}
+	if len(l.buckets) >= maxIPBuckets {
+		clear(l.buckets)
+	}
+}
+
+// admit answers 429 Too Many Requests if the request's address is over its
+// limit.
+func (l *ipLimiter) admit(w http.ResponseWriter, r *http.Request) bool {
+	addr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		addr = r.RemoteAddr
+	}
+	ok, wait := l.allow(addr, time.Now())
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		writeAPIError(w, http.StatusTooManyRequests, "too many attempts; try again later")
+	}
+	return ok
+}
+
+func readCredentials(w http.ResponseWriter, r *http.Request, req *credentialsRequest) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// authenticate returns the account of the request's session token, taken
+// from the Authorization header or, since browsers cannot set headers on
+// WebSocket requests, the token query parameter.
+func (l *Lobby) authenticate(r *http.Request) (Account, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		token = r.URL.Query().Get("token")
+	}
+	if token == "" {
+		return Account{}, errNoSession
+	}
+	sess, err := l.sessions.verify(token)
+	if err != nil {
+		return Account{}, err
+	}
+	account, ok := l.accounts.Get(sess.AccountID)
+	if !ok {
+		return Account{}, errBadSession
+	}
+	if account.Banned {
+		return Account{}, errAccountBanned
+	}
+	return account, nil
+}
+
+// seatNameUnsafe is the name a connection sits down under: its account's
+// name, or for guests the name they asked for.
+func (h *Hub) seatNameUnsafe(clientID, requested string) (string, error) {
+	if client := h.clients[clientID]; client != nil && client.accountName != "" {
+		return client.accountName, nil
+	}
+	if !allowGuests {
+		return "", protocolErrorf(CodeLoginRequired, "log in to take a seat")
+	}
+	if requested == "" {
+		return "", protocolErrorf(CodeInvalidMessage, "name is required to sit down")
+	}
+	if h.accounts.HasPassword(requested) {
+		return "", protocolErrorf(CodeLoginRequired, "%s is a registered name; log in to play as it", requested)
+	}
+	return requested, nil
+}
+
+// checkOrigin returns the upgrader's origin check for the comma-separated
+// -allowed-origins list. Requests without an Origin header do not come from a
+// browser and are let through. Without a list only the server's own host is
+// accepted; "*" accepts any origin.
+func checkOrigin(list string) func(r *http.Request) bool {
+	var allowed []string
+	for _, origin := range strings.Split(list, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			allowed = append(allowed, strings.TrimSuffix(origin, "/"))
+		}
+	}
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		if len(allowed) == 0 {
+			u, err := url.Parse(origin)
+			return err == nil && strings.EqualFold(u.Host, r.Host)
+		}
+		for _, a := range allowed {
+			if a == "*" || strings.EqualFold(a, origin) {
+				return true
+			}
+		}
+		return false
+	}
+}
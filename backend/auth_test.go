@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestIPLimiter(t *testing.T) {
+	l := newIPLimiter(3, time.Second)
+	now := time.Now()
+	for i := range 3 {
+		if ok, _ := l.allow("1.2.3.4", now); !ok {
+			t.Fatalf("attempt %d refused within the burst", i+1)
+		}
+	}
+	ok, wait := l.allow("1.2.3.4", now)
+	if ok || wait <= 0 || wait > time.Second {
+		t.Errorf("fourth attempt = %v, retry after %v", ok, wait)
+	}
+	if ok, _ := l.allow("5.6.7.8", now); !ok {
+		t.Error("another address was refused")
+	}
+	if ok, _ := l.allow("1.2.3.4", now.Add(time.Second)); !ok {
+		t.Error("the bucket did not refill")
+	}
+	if ok, _ := l.allow("1.2.3.4", now.Add(time.Second)); ok {
+		t.Error("the bucket refilled by more than one attempt")
+	}
+}
+
+func TestRegisterRefusesTakenNames(t *testing.T) {
+	lobby := newTestLobby(t)
+	accounts := lobby.accounts
+	if _, err := accounts.Register("Alice", "hash"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := accounts.IssueBotKey("Robo"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Alice", "alice", "Robo"} {
+		if _, err := accounts.Register(name, "other"); !errors.Is(err, errNameTaken) {
+			t.Errorf("registering %s: error %v, want %v", name, err, errNameTaken)
+		}
+	}
+	if _, err := accounts.Register("Carol", "hash"); err != nil {
+		t.Errorf("registering a new name: %v", err)
+	}
+}
+
+func TestGuestClaimLeavesTheNameFree(t *testing.T) {
+	lobby := newTestLobby(t)
+	h := lobby.table(defaultTableID)
+	allowGuests = true
+	defer func() { allowGuests = false }()
+
+	h.gameStateMutex.Lock()
+	h.addSpectatorUnsafe("guest", "Bob")
+	err := h.seatSpectatorUnsafe("guest", "Bob", 0, 0)
+	h.gameStateMutex.Unlock()
+	var perr *ProtocolError
+	if !errors.As(err, &perr) || perr.Code != CodeLoginRequired {
+		t.Fatalf("guest sat down: error %v, want %s", err, CodeLoginRequired)
+	}
+	if _, err := lobby.accounts.Register("Bob", "hash"); err != nil {
+		t.Errorf("registering the name a guest tried: %v", err)
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// --- Bot API ---
+//
+// Bots written outside the server play over a WebSocket at /bot. An
+// operator issues a bot account an API key; the bot connects with
+// "Authorization: Bearer <key>" and ?table=<id>, optionally &buyIn=<n>, and is
+// seated like the built-in bots in bots.go, with a remoteBot as its
+// strategy. On each of its turns it gets a decision_request with its legal
+// actions and its view of the table, and answers with a decision echoing the
+// requestId within botDecisionTimeout. A late answer, no answer or one that
+// is not among the legal actions is played as a check, or a fold. Closing the
+// connection takes the bot off the table.
+
+const (
+	botDecisionTimeout = 10 * time.Second
+	botKeyPrefix       = "dpb_"
+)
+
+var errBadBotKey = errors.New("invalid bot API key")
+
+// BotSeatedPayload is sent as "bot_seated" once the bot has its seat.
+type BotSeatedPayload struct {
+	TableID  string `json:"tableId"`
+	PlayerID string `json:"playerId"`
+	Name     string `json:"name"`
+	Seat     int    `json:"seat"`
+	Chips    int    `json:"chips"`
+}
+
+// DecisionRequestPayload is sent as "decision_request" on the bot's turn.
+type DecisionRequestPayload struct {
+	HandID       string        `json:"handId"`
+	TimeoutMs    int           `json:"timeoutMs"`
+	LegalActions []LegalAction `json:"legalActions"`
+	State        BotView       `json:"state"`
+}
+
+type issueBotKeyRequest struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// BotKey is returned once when a key is issued; only its hash is stored.
+type BotKey struct {
+	Account Account `json:"account"`
+	APIKey  string  `json:"apiKey"`
+}
+
+func hashBotKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueBotKey gives the bot account called name a new API key, creating the
+// account if needed. Any earlier key stops working.
+func (b *AccountBook) IssueBotKey(name string) (Account, string, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return Account{}, "", err
+	}
+	key := botKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	a, ok := b.accounts[b.byName[strings.ToLower(name)]]
+	if !ok {
+		a = b.createLocked(name, StartingBankroll)
+		a.Bot = true
+	} else if !a.Bot {
+		return Account{}, "", errHumanAccount
+	}
+	a.APIKeyHash = hashBotKey(key)
+	b.saveLocked(a)
+	return a.view(), key, nil
+}
+
+// BotByKey returns the bot account holding an API key.
+func (b *AccountBook) BotByKey(key string) (Account, error) {
+	if !strings.HasPrefix(key, botKeyPrefix) {
+		return Account{}, errBadBotKey
+	}
+	hash := []byte(hashBotKey(key))
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, a := range b.accounts {
+		if a.APIKeyHash != "" && subtle.ConstantTimeCompare([]byte(a.APIKeyHash), hash) == 1 {
+			if a.Banned {
+				return Account{}, errAccountBanned
+			}
+			return a.view(), nil
+		}
+	}
+	return Account{}, errBadBotKey
+}
+
+// remoteBot is the strategy of a bot playing over the bot API.
+type remoteBot struct {
+	conn      *websocket.Conn
+	codec     Codec
+	writeMu   sync.Mutex
+	decisions chan Message
+	done      chan struct{} // closed when the connection drops
+
+	mu      sync.Mutex
+	pending string // requestId of the decision_request being waited on
+}
+
+func (r *remoteBot) Name() string { return "remote" }
+
+// Decide asks the bot and waits for its answer.
+func (r *remoteBot) Decide(v BotView) PlayerActionPayload {
+	requestID := uuid.NewString()
+	r.setPending(requestID)
+	defer r.setPending("")
+	r.send(Message{RequestID: requestID}, "decision_request", DecisionRequestPayload{
+		HandID:       v.HandID,
+		TimeoutMs:    int(botDecisionTimeout.Milliseconds()),
+		LegalActions: v.legalActions(),
+		State:        v,
+	})
+	timeout := time.NewTimer(botDecisionTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case msg := <-r.decisions:
+			if msg.RequestID != requestID {
+				continue // left over from an earlier request
+			}
+			var d PlayerActionPayload
+			if err := json.Unmarshal(msg.Payload, &d); err != nil || !v.allows(d) {
+				r.send(msg, "action_rejected", ErrorPayload{Code: CodeInvalidMessage,
+					Message: "not one of the legal actions; checking or folding instead", RequestType: msg.Type})
+				return v.checkOrFold()
+			}
+			return d
+		case <-timeout.C:
+			return v.checkOrFold()
+		case <-r.done:
+			return v.checkOrFold()
+		}
+	}
+}
+
+func (r *remoteBot) setPending(requestID string) {
+	r.mu.Lock()
+	r.pending = requestID
+	r.mu.Unlock()
+}
+
+// deliver passes a decision on to Decide if it answers the pending request.
+// Answers to earlier requests are dropped, and an answer still in the buffer
+// gives way to the newer one.
+func (r *remoteBot) deliver(msg Message) bool {
+	r.mu.Lock()
+	pending := r.pending
+	r.mu.Unlock()
+	if pending == "" || msg.RequestID != pending {
+		return false
+	}
+	for {
+		select {
+		case r.decisions <- msg:
+			return true
+		default:
+		}
+		select {
+		case <-r.decisions:
+		default:
+		}
+	}
+}
+
+// send writes a message to the bot, echoing req's requestId.
+func (r *remoteBot) send(req Message, msgType string, payload any) {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	msg, err := json.Marshal(Message{Type: msgType, RequestID: req.RequestID, Payload: payloadBytes})
+	if err != nil {
+		return
+	}
+	if checkOutgoingMessages {
+		checkOutgoing(msg)
+	}
+	frame, err := r.codec.Encode(msg)
+	if err != nil {
+		return
+	}
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	r.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	r.conn.WriteMessage(r.codec.FrameType(), frame)
+}
+
+// Close is called when the bot leaves the table.
+func (r *remoteBot) Close() error {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	r.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "removed from the table"),
+		time.Now().Add(time.Second))
+	return r.conn.Close()
+}
+
+// serveBot seats a bot that authenticates with its API key and plays its
+// turns over the connection.
+func serveBot(lobby *Lobby, w http.ResponseWriter, r *http.Request) {
+	hub := lobby.table(r.URL.Query().Get("table"))
+	if hub == nil {
+		http.Error(w, "table not found", http.StatusNotFound)
+		return
+	}
+	if hub.isClosed() {
+		http.Error(w, "server is restarting", http.StatusServiceUnavailable)
+		return
+	}
+	key, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	account, err := lobby.accounts.BotByKey(key)
+	if errors.Is(err, errAccountBanned) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	buyIn := 0
+	if v := r.URL.Query().Get("buyIn"); v != "" {
+		if buyIn, err = strconv.Atoi(v); err != nil || buyIn < 0 {
+			http.Error(w, "buyIn must be a number of chips", http.StatusBadRequest)
+			return
+		}
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	bot := &remoteBot{conn: conn, codec: codecFor(conn.Subprotocol()), decisions: make(chan Message, 1), done: make(chan struct{})}
+	defer conn.Close()
+	defer close(bot.done)
+
+	info, err := hub.addBotWith(bot, account.Name, 0, buyIn)
+	if err != nil {
+		var perr *ProtocolError
+		if !errors.As(err, &perr) {
+			perr = &ProtocolError{Code: CodeInternal, Message: "internal error"}
+		}
+		bot.send(Message{}, "error", ErrorPayload{Code: perr.Code, Message: perr.Message})
+		return
+	}
+	defer hub.removeBot(info.ID)
+	bot.send(Message{}, "bot_seated", BotSeatedPayload{TableID: hub.config.ID, PlayerID: info.ID, Name: info.Name, Seat: info.Seat, Chips: info.Chips})
+	hub.log.Info("remote bot connected", "player", info.Name, "remote", r.RemoteAddr)
+
+	// The same keepalive as players' connections
+	conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		return nil
+	})
+	go func() {
+		ticker := time.NewTicker(54 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				bot.writeMu.Lock()
+				err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second))
+				bot.writeMu.Unlock()
+				if err != nil {
+					return
+				}
+			case <-bot.done:
+				return
+			}
+		}
+	}()
+
+	for {
+		_, frame, err := conn.ReadMessage()
+		if err != nil {
+			hub.log.Info("remote bot disconnected", "player", info.Name)
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		var msg Message
+		data, err := bot.codec.Decode(frame)
+		if err == nil {
+			err = json.Unmarshal(data, &msg)
+		}
+		if err != nil {
+			bot.send(msg, "error", ErrorPayload{Code: CodeInvalidMessage, Message: "message is not valid JSON"})
+			continue
+		}
+		if _, known, err := validateMessage(data, "client"); !known || msg.Type != "decision" {
+			bot.send(msg, "error", ErrorPayload{Code: CodeUnknownType, Message: "bots only send decision messages", RequestType: msg.Type})
+			continue
+		} else if err != nil {
+			bot.send(msg, "error", ErrorPayload{Code: CodeInvalidMessage, Message: err.Error(), RequestType: msg.Type})
+			continue
+		}
+		bot.deliver(msg)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRemoteBotDeliver(t *testing.T) {
+	bot := &remoteBot{decisions: make(chan Message, 1)}
+	decision := func(requestID, action string) Message {
+		payload, _ := json.Marshal(PlayerActionPayload{Action: action})
+		return Message{Type: "decision", RequestID: requestID, Payload: payload}
+	}
+	if bot.deliver(decision("r1", "call")) {
+		t.Error("a decision was passed on with no request pending")
+	}
+	bot.setPending("r2")
+	if bot.deliver(decision("r1", "call")) {
+		t.Error("an answer to an earlier request was passed on")
+	}
+	if !bot.deliver(decision("r2", "fold")) || !bot.deliver(decision("r2", "call")) {
+		t.Fatal("an answer to the pending request was dropped")
+	}
+	var d PlayerActionPayload
+	json.Unmarshal((<-bot.decisions).Payload, &d)
+	if d.Action != "call" {
+		t.Errorf("Decide gets %q, want the newer answer", d.Action)
+	}
+}
+
+// dialBot seats the bot account name on the default table over the bot API
+// and returns the connection once it is seated. The lobby is shut down at the
+// end of the test, so the hand in progress is settled before the store closes.
+func dialBot(t *testing.T, lobby *Lobby, name string) *websocket.Conn {
+	t.Helper()
+	t.Cleanup(func() { lobby.shutdown(0) })
+	_, key, err := lobby.accounts.IssueBotKey(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { serveBot(lobby, w, r) }))
+	t.Cleanup(srv.Close)
+	header := http.Header{"Authorization": {"Bearer " + key}}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/bot?table="+defaultTableID, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if msg := readBotMessage(t, conn); msg.Type != "bot_seated" {
+		t.Fatalf("got %s, want bot_seated", msg.Type)
+	}
+	return conn
+}
+
+func readBotMessage(t *testing.T, conn *websocket.Conn) Message {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(15 * time.Second))
+	var msg Message
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func sendDecision(t *testing.T, conn *websocket.Conn, requestID string, d PlayerActionPayload) {
+	t.Helper()
+	payload, _ := json.Marshal(d)
+	if err := conn.WriteJSON(Message{Type: "decision", RequestID: requestID, Payload: payload}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// awaitDecisionRequest plays Alice's turns with a call until the bot is asked
+// for a decision.
+func awaitDecisionRequest(t *testing.T, h *Hub, conn *websocket.Conn, alice string) (Message, DecisionRequestPayload) {
+	t.Helper()
+	s := waitFor(t, h, "the hand", func(s GameState) bool { return s.GameStarted && s.CurrentTurnIndex >= 0 })
+	if s.PlayerOrder[s.CurrentTurnIndex] == alice {
+		act(t, h, alice, "call", 0)
+	}
+	msg := readBotMessage(t, conn)
+	if msg.Type != "decision_request" {
+		t.Fatalf("got %s, want decision_request", msg.Type)
+	}
+	var req DecisionRequestPayload
+	if err := json.Unmarshal(msg.Payload, &req); err != nil {
+		t.Fatal(err)
+	}
+	return msg, req
+}
+
+func TestBotAPIPlaysTheAnswerToTheCurrentRequest(t *testing.T) {
+	lobby := newTestLobby(t)
+	h := lobby.table(defaultTableID)
+	alice := sitDown(t, h, "Alice", 1000)
+	conn := dialBot(t, lobby, "Robo")
+	if err := h.handlePlayerReady(alice, true); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, req := awaitDecisionRequest(t, h, conn, alice)
+	if len(req.LegalActions) == 0 || len(req.State.Hand) != 2 {
+		t.Fatalf("decision_request has %d legal actions and %d hole cards", len(req.LegalActions), len(req.State.Hand))
+	}
+	before := snapshot(h)
+	botID := before.PlayerOrder[before.CurrentTurnIndex]
+	if before.Players[botID].Name != "Robo" {
+		t.Fatalf("it is the turn of %s", before.Players[botID].Name)
+	}
+	sendDecision(t, conn, "stale", PlayerActionPayload{Action: "fold"})
+	sendDecision(t, conn, msg.RequestID, req.State.call())
+
+	s := waitFor(t, h, "the bot to act", func(s GameState) bool {
+		return s.GamePhase != before.GamePhase || s.CurrentTurnIndex != before.CurrentTurnIndex
+	})
+	if p := s.Players[botID]; !p.IsInHand {
+		t.Error("the bot played the answer to an earlier request")
+	}
+}
+
+func TestBotAPIRejectsIllegalDecisions(t *testing.T) {
+	lobby := newTestLobby(t)
+	h := lobby.table(defaultTableID)
+	alice := sitDown(t, h, "Alice", 1000)
+	conn := dialBot(t, lobby, "Robo")
+	if err := h.handlePlayerReady(alice, true); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, req := awaitDecisionRequest(t, h, conn, alice)
+	sendDecision(t, conn, msg.RequestID, PlayerActionPayload{Action: "raise", Amount: req.State.Bet + req.State.Chips + 1})
+	if reply := readBotMessage(t, conn); reply.Type != "action_rejected" || reply.RequestID != msg.RequestID {
+		t.Errorf("got %s for %q, want action_rejected for %q", reply.Type, reply.RequestID, msg.RequestID)
+	}
+}
+
+func TestBotAPIRefusesBadKeys(t *testing.T) {
+	lobby := newTestLobby(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { serveBot(lobby, w, r) }))
+	defer srv.Close()
+	for _, key := range []string{"", "dpb_nope", "not-a-bot-key"} {
+		req, _ := http.NewRequest("GET", srv.URL+"/bot?table="+defaultTableID, nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("key %q: status %d, want 401", key, resp.StatusCode)
+		}
+	}
+}
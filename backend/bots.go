@@ -0,0 +1,310 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// --- Bots ---
+//
+// Operators can seat bots at a table. A bot is a seated Player with an
+// account like anyone else, but no Client: the hub plays its turns itself,
+// asking the bot's BotStrategy (see botstrategy.go) after a think delay. Bots
+// get ready between hands, rebuy when they run out of chips, and only play
+// while at least one person is seated with chips, so a table of bots alone
+// sits idle. A bot removed during a hand leaves like a player who
+// disconnects. Bots are not kept across restarts.
+
+const (
+	defaultBotThink = time.Second
+	maxBotThink     = 30 * time.Second
+)
+
+type bot struct {
+	strategy BotStrategy
+	think    time.Duration
+	turn     int // event sequence of the turn already being decided
+}
+
+// BotInfo describes a seated bot, for the admin API.
+type BotInfo struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Strategy string `json:"strategy"`
+	ThinkMs  int    `json:"thinkMs"`
+	Seat     int    `json:"seat"`
+	Chips    int    `json:"chips"`
+	Leaving  bool   `json:"leaving,omitempty"` // removed, waiting for the hand to end
+}
+
+// delay is the bot's think time, varied by up to half either way so that
+// bots do not act in lockstep.
+func (b *bot) delay() time.Duration {
+	if b.think <= 0 {
+		return 0
+	}
+	return b.think/2 + rand.N(b.think)
+}
+
+// addBot seats a bot playing strategy at the table.
+func (h *Hub) addBot(strategy, name string, think time.Duration, buyIn int) (BotInfo, error) {
+	newStrategy, ok := botStrategies[strategy]
+	if !ok {
+		return BotInfo{}, fmt.Errorf("strategy must be one of %s", botStrategyNames())
+	}
+	return h.addBotWith(newStrategy(), name, think, buyIn)
+}
+
+func (h *Hub) addBotWith(strategy BotStrategy, name string, think time.Duration, buyIn int) (BotInfo, error) {
+	h.gameStateMutex.Lock()
+	defer h.gameStateMutex.Unlock()
+	if h.draining {
+		return BotInfo{}, protocolErrorf(CodeShuttingDown, "the server is restarting")
+	}
+	seat := h.freeSeatUnsafe()
+	if seat == 0 {
+		return BotInfo{}, protocolErrorf(CodeTableFull, "no seat is open")
+	}
+	if name == "" {
+		name = h.botNameUnsafe()
+	}
+	account, err := h.accounts.ClaimBot(name, h.config, buyIn)
+	if errors.Is(err, errHumanAccount) || errors.Is(err, errAccountInUse) {
+		return BotInfo{}, protocolErrorf(CodeNameInUse, "%s is already taken", name)
+	}
+	if errors.Is(err, errBuyInRange) {
+		return BotInfo{}, protocolErrorf(CodeInvalidBuyIn, "the buy-in at this table is %d to %d chips", h.config.MinBuyIn, h.config.MaxBuyIn)
+	}
+	if errors.Is(err, errInsufficientBankroll) {
+		return BotInfo{}, protocolErrorf(CodeInsufficientBankroll, "the bankroll of %s does not cover a buy-in of %d", name, buyIn)
+	}
+	if err != nil {
+		return BotInfo{}, err
+	}
+
+	id := uuid.NewString()
+	player := Player{ID: id, AccountID: account.ID, Name: account.Name, Seat: seat, Chips: account.InPlay,
+		Hand: []Card{}, IsConnected: true, HUD: h.hudUnsafe(account.ID)}
+	h.gameState.Players[id] = player
+	h.playerReady[id] = false
+	h.bots[id] = &bot{strategy: strategy, think: think}
+	h.emitEventUnsafe(GameEvent{Type: EventPlayerJoined, PlayerID: id, Name: player.Name, Seat: seat, Amount: player.Chips, Connected: true})
+	h.logUnsafe().Info("bot sat down", "player", player.Name, "strategy", strategy.Name(), "seat", seat, "chips", player.Chips)
+	h.broadcastGameStateUnsafe()
+	return h.botInfoUnsafe(id), nil
+}
+
+// botNameUnsafe picks the first "Bot <n>" not seated anywhere.
+func (h *Hub) botNameUnsafe() string {
+	for n := 1; ; n++ {
+		name := fmt.Sprintf("Bot %d", n)
+		if a, table, ok := h.accounts.Lookup(name); !ok || (a.Bot && table == "") {
+			return name
+		}
+	}
+}
+
+// removeBot takes a bot, found by ID or name, off the table. A bot dealt
+// into the running hand folds and leaves when the hand ends.
+func (h *Hub) removeBot(idOrName string) (BotInfo, bool) {
+	h.gameStateMutex.Lock()
+	defer h.gameStateMutex.Unlock()
+	id := idOrName
+	if _, ok := h.bots[id]; !ok {
+		for bid := range h.bots {
+			if strings.EqualFold(h.gameState.Players[bid].Name, idOrName) {
+				id = bid
+			}
+		}
+	}
+	if _, ok := h.bots[id]; !ok {
+		return BotInfo{}, false
+	}
+	info := h.botInfoUnsafe(id)
+	if !info.Leaving {
+		h.disconnectBotUnsafe(id)
+		_, info.Leaving = h.bots[id]
+	}
+	h.logUnsafe().Info("bot removed", "player", info.Name, "leaving", info.Leaving)
+	return info, true
+}
+
+// disconnectBotUnsafe makes the bot leave as a player who disconnects would,
+// but without taking the turn from whoever has it.
+func (h *Hub) disconnectBotUnsafe(id string) {
+	p := h.gameState.Players[id]
+	ordered := len(h.gameState.PlayerOrder) > 0 && h.gameState.CurrentTurnIndex >= 0
+	if !h.gameState.GameStarted || !p.IsInHand || (ordered && h.gameState.PlayerOrder[h.gameState.CurrentTurnIndex] == id) {
+		h.handleDisconnectUnsafe(id)
+		return
+	}
+	h.emitEventUnsafe(GameEvent{Type: EventPlayerConnection, PlayerID: id, Connected: false})
+	p.IsConnected, p.IsInHand = false, false
+	h.gameState.Players[id] = p
+	h.broadcastGameStateUnsafe()
+}
+
+// dropBotUnsafe forgets a bot that left the table, and closes the
+// connection of a remote one.
+func (h *Hub) dropBotUnsafe(id string) {
+	b := h.bots[id]
+	if b == nil {
+		return
+	}
+	if c, ok := b.strategy.(io.Closer); ok {
+		c.Close()
+	}
+	delete(h.bots, id)
+}
+
+func (h *Hub) listBots() []BotInfo {
+	h.gameStateMutex.RLock()
+	defer h.gameStateMutex.RUnlock()
+	bots := make([]BotInfo, 0, len(h.bots))
+	for id := range h.bots {
+		bots = append(bots, h.botInfoUnsafe(id))
+	}
+	slices.SortFunc(bots, func(a, b BotInfo) int { return a.Seat - b.Seat })
+	return bots
+}
+
+func (h *Hub) botInfoUnsafe(id string) BotInfo {
+	b, p := h.bots[id], h.gameState.Players[id]
+	return BotInfo{ID: id, Name: p.Name, Strategy: b.strategy.Name(), ThinkMs: int(b.think.Milliseconds()),
+		Seat: p.Seat, Chips: p.Chips, Leaving: !p.IsConnected}
+}
+
+// driveBotsUnsafe runs after every broadcast. It starts a bot's decision
+// when the turn is a bot's, and between hands gets the bots ready.
+func (h *Hub) driveBotsUnsafe() {
+	if len(h.bots) == 0 || h.closed {
+		return
+	}
+	if !h.gameState.GameStarted {
+		h.readyBotsLaterUnsafe()
+		return
+	}
+	if h.gameState.Paused || h.gameState.GamePhase == "showdown" || h.gameState.CurrentTurnIndex < 0 {
+		return
+	}
+	id := h.gameState.PlayerOrder[h.gameState.CurrentTurnIndex]
+	b := h.bots[id]
+	if b == nil || b.turn == h.eventSeq {
+		return
+	}
+	b.turn = h.eventSeq
+	view, seq, strategy := h.botViewUnsafe(id), h.eventSeq, b.strategy
+	time.AfterFunc(b.delay(), func() {
+		h.botAct(id, seq, strategy.Decide(view))
+	})
+}
+
+func (h *Hub) botViewUnsafe(id string) BotView {
+	p := h.gameState.Players[id]
+	v := BotView{
+		HandID:     h.gameState.HandID,
+		Hand:       slices.Clone(p.Hand),
+		Board:      slices.Clone(h.gameState.CommunityCards),
+		Phase:      h.gameState.GamePhase,
+		Pot:        h.gameState.Pot,
+		ToCall:     max(0, h.gameState.LastBet-p.Bet),
+		MinRaiseTo: h.gameState.LastBet + h.gameState.MinRaise,
+		Chips:      p.Chips,
+		Bet:        p.Bet,
+		BigBlind:   h.config.BigBlind,
+		Seat:       p.Seat,
+		ButtonSeat: h.gameState.ButtonSeat,
+		Seats:      []BotSeat{},
+	}
+	for oid, o := range h.gameState.Players {
+		v.Pot += o.Bet
+		if oid != id && o.IsInHand {
+			v.Opponents++
+		}
+	}
+	for _, oid := range h.gameState.PlayerOrder {
+		o := h.gameState.Players[oid]
+		v.Seats = append(v.Seats, BotSeat{Seat: o.Seat, Name: o.Name, Chips: o.Chips, Bet: o.Bet, InHand: o.IsInHand, AllIn: o.IsAllIn})
+	}
+	return v
+}
+
+// botAct plays a bot's decision if the game has not moved on in the
+// meantime. A decision the table refuses becomes a check, or a fold.
+func (h *Hub) botAct(id string, seq int, decision PlayerActionPayload) {
+	h.gameStateMutex.Lock()
+	defer h.gameStateMutex.Unlock()
+	b := h.bots[id]
+	if b == nil || h.eventSeq != seq {
+		return
+	}
+	err := h.playerActionUnsafe(id, decision)
+	for _, fallback := range []string{"check", "fold"} {
+		if err == nil || h.eventSeq != seq {
+			break
+		}
+		h.logUnsafe().Debug("bot action refused", "player", h.gameState.Players[id].Name, "action", decision.Action, "err", err)
+		err = h.playerActionUnsafe(id, PlayerActionPayload{Action: fallback})
+	}
+	if err != nil {
+		// Paused, most likely; decide again on the next broadcast
+		b.turn = 0
+	}
+}
+
+// readyBotsLaterUnsafe gets the bots ready, rebuying for those out of
+// chips, after a short pause once a person with chips is seated.
+func (h *Hub) readyBotsLaterUnsafe() {
+	if h.botsReadying || h.draining || h.gameState.Paused || !h.peopleSeatedUnsafe() {
+		return
+	}
+	for id := range h.bots {
+		if !h.playerReady[id] && h.gameState.Players[id].IsConnected {
+			h.botsReadying = true
+			time.AfterFunc(defaultBotThink, h.readyBots)
+			return
+		}
+	}
+}
+
+func (h *Hub) readyBots() {
+	h.gameStateMutex.Lock()
+	defer h.gameStateMutex.Unlock()
+	defer func() { h.botsReadying = false }()
+	if h.gameState.GameStarted || h.draining || h.closed {
+		return
+	}
+	for id := range h.bots {
+		p := h.gameState.Players[id]
+		if !p.IsConnected {
+			continue
+		}
+		if p.Chips == 0 {
+			if err := h.rebuyUnsafe(id); err != nil {
+				h.logUnsafe().Info("bot cannot rebuy", "player", p.Name, "err", err)
+				continue
+			}
+		}
+		h.playerReady[id] = true
+	}
+	h.startIfReadyUnsafe()
+	h.broadcastGameStateUnsafe()
+}
+
+// peopleSeatedUnsafe reports whether anyone who is not a bot is seated with
+// chips.
+func (h *Hub) peopleSeatedUnsafe() bool {
+	for id, p := range h.gameState.Players {
+		if h.bots[id] == nil && p.IsConnected && p.Chips > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"math/rand/v2"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// --- Bot strategies ---
+//
+// A BotStrategy decides a bot's action from what the bot can see. Decide
+// runs without the table lock, so it may take its time. Its answer goes
+// through the same checks as a player's; if those refuse it, the bot checks
+// or folds instead.
+
+type BotStrategy interface {
+	Name() string
+	Decide(v BotView) PlayerActionPayload
+}
+
+// BotView is the table from the seat of the bot whose turn it is. Remote
+// bots get it as the state of a decision_request, see botapi.go.
+type BotView struct {
+	HandID     string    `json:"handId"`
+	Hand       []Card    `json:"hand"`
+	Board      []Card    `json:"board"`
+	Phase      string    `json:"phase"`
+	Pot        int       `json:"pot"` // including the bets in front of the players
+	ToCall     int       `json:"toCall"`
+	MinRaiseTo int       `json:"minRaiseTo"` // smallest legal total bet for a raise
+	Chips      int       `json:"chips"`
+	Bet        int       `json:"bet"`
+	BigBlind   int       `json:"bigBlind"`
+	Opponents  int       `json:"opponents"` // other players still in the hand
+	Seat       int       `json:"seat"`
+	ButtonSeat int       `json:"buttonSeat"`
+	Seats      []BotSeat `json:"seats"` // everyone dealt in, in seat order
+}
+
+// BotSeat is what everyone can see of a player dealt into the hand.
+type BotSeat struct {
+	Seat   int    `json:"seat"`
+	Name   string `json:"name"`
+	Chips  int    `json:"chips"`
+	Bet    int    `json:"bet"`
+	InHand bool   `json:"inHand"`
+	AllIn  bool   `json:"allIn"`
+}
+
+// LegalAction is an action the bot may take. Call carries the chips it
+// costs, raise the smallest and largest total bet.
+type LegalAction struct {
+	Action string `json:"action"`
+	Amount int    `json:"amount,omitempty"`
+	Min    int    `json:"min,omitempty"`
+	Max    int    `json:"max,omitempty"`
+}
+
+func (v BotView) legalActions() []LegalAction {
+	if v.ToCall == 0 {
+		actions := []LegalAction{{Action: "check"}}
+		if v.Chips > 0 {
+			actions = append(actions, LegalAction{Action: "raise", Min: min(v.MinRaiseTo, v.Bet+v.Chips), Max: v.Bet + v.Chips})
+		}
+		return actions
+	}
+	actions := []LegalAction{{Action: "fold"}, {Action: "call", Amount: min(v.ToCall, v.Chips)}}
+	if v.Chips > v.ToCall {
+		actions = append(actions, LegalAction{Action: "raise", Min: min(v.MinRaiseTo, v.Bet+v.Chips), Max: v.Bet + v.Chips})
+	}
+	return actions
+}
+
+// allows reports whether d is one of the legal actions.
+func (v BotView) allows(d PlayerActionPayload) bool {
+	for _, a := range v.legalActions() {
+		if a.Action == d.Action {
+			return a.Action != "raise" || (d.Amount >= a.Min && d.Amount <= a.Max)
+		}
+	}
+	return false
+}
+
+// raiseTo is a raise to a total bet of target, at least the minimum raise
+// and at most all-in.
+func (v BotView) raiseTo(target int) PlayerActionPayload {
+	return PlayerActionPayload{Action: "raise", Amount: min(max(target, v.MinRaiseTo), v.Bet+v.Chips)}
+}
+
+// checkOrFold checks when there is nothing to call.
+func (v BotView) checkOrFold() PlayerActionPayload {
+	if v.ToCall == 0 {
+		return PlayerActionPayload{Action: "check"}
+	}
+	return PlayerActionPayload{Action: "fold"}
+}
+
+func (v BotView) call() PlayerActionPayload {
+	if v.ToCall == 0 {
+		return PlayerActionPayload{Action: "check"}
+	}
+	return PlayerActionPayload{Action: "call"}
+}
+
+var botStrategies = map[string]func() BotStrategy{
+	"random": func() BotStrategy { return randomStrategy{} },
+	"tag":    func() BotStrategy { return tagStrategy{} },
+	"equity": func() BotStrategy { return equityStrategy{trials: 500} },
+}
+
+func botStrategyNames() string {
+	names := make([]string, 0, len(botStrategies))
+	for name := range botStrategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// randomStrategy picks a legal action at random, leaning towards calling.
+type randomStrategy struct{}
+
+func (randomStrategy) Name() string { return "random" }
+
+func (randomStrategy) Decide(v BotView) PlayerActionPayload {
+	r := rand.Float64()
+	switch {
+	case r < 0.15:
+		return v.checkOrFold()
+	case r < 0.8:
+		return v.call()
+	}
+	return v.raiseTo(v.MinRaiseTo + rand.IntN(max(v.Pot, 1)))
+}
+
+// tagStrategy plays tight-aggressive by rule: a few strong starting hands,
+// raised rather than called, and bets after the flop only with a made hand.
+type tagStrategy struct{}
+
+func (tagStrategy) Name() string { return "tag" }
+
+func (tagStrategy) Decide(v BotView) PlayerActionPayload {
+	if len(v.Board) == 0 {
+		switch startingHandTier(v.Hand) {
+		case 1:
+			return v.raiseTo(max(3*v.BigBlind, 3*(v.Bet+v.ToCall)))
+		case 2:
+			if v.ToCall <= 3*v.BigBlind {
+				return v.call()
+			}
+		}
+		return v.checkOrFold()
+	}
+
+	made := evaluateHand(append(slices.Clone(v.Hand), v.Board...))
+	switch {
+	case made.Rank >= TwoPair && !boardPlaysItself(v, made):
+		return v.raiseTo(v.Bet + v.ToCall + v.Pot*2/3)
+	case made.Rank == OnePair && made.Values[0] >= highestRank(v.Board) && !boardPlaysItself(v, made):
+		// Top pair or an overpair
+		if v.ToCall == 0 {
+			return v.raiseTo(v.Pot / 2)
+		}
+		if v.ToCall <= v.Pot/2 {
+			return v.call()
+		}
+	case made.Rank == OnePair && v.ToCall <= v.Pot/4:
+		return v.call()
+	}
+	return v.checkOrFold()
+}
+
+// startingHandTier sorts two hole cards into 1 (raise), 2 (play for a small
+// price) or 3 (fold).
+func startingHandTier(hand []Card) int {
+	if len(hand) != 2 {
+		return 3
+	}
+	hi, lo := rankToInt(hand[0].Rank), rankToInt(hand[1].Rank)
+	if lo > hi {
+		hi, lo = lo, hi
+	}
+	suited := hand[0].Suit == hand[1].Suit
+	switch {
+	case hi == lo && hi >= 10, hi == 14 && lo >= 13, hi == 14 && lo == 12 && suited:
+		return 1
+	case hi == lo, hi == 14 && lo >= 10, hi == 13 && lo >= 11, suited && hi-lo == 1 && lo >= 9:
+		return 2
+	}
+	return 3
+}
+
+func highestRank(cards []Card) int {
+	best := 0
+	for _, c := range cards {
+		best = max(best, rankToInt(c.Rank))
+	}
+	return best
+}
+
+// boardPlaysItself reports whether the board alone makes the bot's hand, so
+// the hole cards add nothing. The evaluator needs five cards, so before the
+// river it looks for the pairs and trips on the board instead.
+func boardPlaysItself(v BotView, made EvaluatedHand) bool {
+	if len(v.Board) >= 5 {
+		return compareHands(evaluateHand(v.Board), made) >= 0
+	}
+	onBoard := make(map[int]int, len(v.Board))
+	for _, c := range v.Board {
+		onBoard[rankToInt(c.Rank)]++
+	}
+	switch made.Rank {
+	case OnePair:
+		return onBoard[made.Values[0]] >= 2
+	case TwoPair:
+		return onBoard[made.Values[0]] >= 2 && onBoard[made.Values[1]] >= 2
+	case ThreeOfAKind:
+		return onBoard[made.Values[0]] >= 3
+	}
+	return false
+}
+
+// equityStrategy estimates its chance of winning by dealing out the rest of
+// the hand at random many times with the hand evaluator, and compares it with
+// the price of calling.
+type equityStrategy struct {
+	trials int
+}
+
+func (equityStrategy) Name() string { return "equity" }
+
+func (s equityStrategy) Decide(v BotView) PlayerActionPayload {
+	equity := handEquity(v.Hand, v.Board, max(v.Opponents, 1), s.trials)
+	potOdds := float64(v.ToCall) / float64(v.Pot+v.ToCall)
+	switch {
+	case equity > 0.7:
+		return v.raiseTo(v.Bet + v.ToCall + v.Pot)
+	case equity > 0.5 && v.ToCall == 0:
+		return v.raiseTo(v.Pot / 2)
+	case v.ToCall == 0:
+		return PlayerActionPayload{Action: "check"}
+	case equity >= potOdds:
+		return v.call()
+	}
+	return PlayerActionPayload{Action: "fold"}
+}
+
+// handEquity is the share of trials hand wins against opponents random hands
+// once the board is dealt out, counting ties as split.
+func handEquity(hand, board []Card, opponents, trials int) float64 {
+	var unseen []Card
+	for _, c := range newOrderedDeck() {
+		if !containsCard(hand, c) && !containsCard(board, c) {
+			unseen = append(unseen, c)
+		}
+	}
+	need := 5 - len(board) + 2*opponents
+	if trials <= 0 || need > len(unseen) {
+		return 0
+	}
+	won := 0.0
+	full := make([]Card, 0, 7)
+	for range trials {
+		// A partial Fisher-Yates shuffle draws the cards this trial needs
+		for i := range need {
+			j := i + rand.IntN(len(unseen)-i)
+			unseen[i], unseen[j] = unseen[j], unseen[i]
+		}
+		runout := append(slices.Clone(board), unseen[:5-len(board)]...)
+		mine := evaluateHand(append(append(full[:0], hand...), runout...))
+		best, ties := true, 1
+		for o := range opponents {
+			start := 5 - len(board) + 2*o
+			theirs := evaluateHand(append(append(full[:0], unseen[start:start+2]...), runout...))
+			switch compareHands(theirs, mine) {
+			case 1:
+				best = false
+			case 0:
+				ties++
+			}
+			if !best {
+				break
+			}
+		}
+		if best {
+			won += 1 / float64(ties)
+		}
+	}
+	return won / float64(trials)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"math/rand/v2"
+	"reflect"
+	"testing"
+)
+
+func TestLegalActions(t *testing.T) {
+	tests := []struct {
+		name string
+		view BotView
+		want []LegalAction
+	}{
+		{"nothing to call", BotView{Chips: 500, MinRaiseTo: 20},
+			[]LegalAction{{Action: "check"}, {Action: "raise", Min: 20, Max: 500}}},
+		{"all in already", BotView{Bet: 100},
+			[]LegalAction{{Action: "check"}}},
+		{"facing a bet", BotView{ToCall: 40, Bet: 20, Chips: 500, MinRaiseTo: 100},
+			[]LegalAction{{Action: "fold"}, {Action: "call", Amount: 40}, {Action: "raise", Min: 100, Max: 520}}},
+		{"short of the minimum raise", BotView{ToCall: 40, Bet: 20, Chips: 60, MinRaiseTo: 100},
+			[]LegalAction{{Action: "fold"}, {Action: "call", Amount: 40}, {Action: "raise", Min: 80, Max: 80}}},
+		{"calling all in", BotView{ToCall: 400, Chips: 150, MinRaiseTo: 800},
+			[]LegalAction{{Action: "fold"}, {Action: "call", Amount: 150}}},
+	}
+	for _, tt := range tests {
+		if got := tt.view.legalActions(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBotViewAllows(t *testing.T) {
+	v := BotView{ToCall: 40, Bet: 20, Chips: 500, MinRaiseTo: 100}
+	tests := []struct {
+		d    PlayerActionPayload
+		want bool
+	}{
+		{PlayerActionPayload{Action: "fold"}, true},
+		{PlayerActionPayload{Action: "call"}, true},
+		{PlayerActionPayload{Action: "check"}, false},
+		{PlayerActionPayload{Action: "raise", Amount: 100}, true},
+		{PlayerActionPayload{Action: "raise", Amount: 520}, true},
+		{PlayerActionPayload{Action: "raise", Amount: 99}, false},
+		{PlayerActionPayload{Action: "raise", Amount: 521}, false},
+		{PlayerActionPayload{Action: "bet", Amount: 100}, false},
+	}
+	for _, tt := range tests {
+		if got := v.allows(tt.d); got != tt.want {
+			t.Errorf("%s %d: allows = %v, want %v", tt.d.Action, tt.d.Amount, got, tt.want)
+		}
+	}
+	if d := v.checkOrFold(); d.Action != "fold" {
+		t.Errorf("checkOrFold facing a bet: %s", d.Action)
+	}
+	if d := (BotView{Chips: 500}).checkOrFold(); d.Action != "check" {
+		t.Errorf("checkOrFold with nothing to call: %s", d.Action)
+	}
+	if d := v.raiseTo(10); d.Amount != 100 {
+		t.Errorf("raiseTo below the minimum: %d, want 100", d.Amount)
+	}
+	if d := v.raiseTo(10_000); d.Amount != 520 {
+		t.Errorf("raiseTo beyond the stack: %d, want 520", d.Amount)
+	}
+}
+
+// randomView deals a bot a random spot with chips behind to raise.
+func randomView() BotView {
+	deck := newOrderedDeck()
+	rand.Shuffle(len(deck), func(i, j int) { deck[i], deck[j] = deck[j], deck[i] })
+	board := []int{0, 3, 4, 5}[rand.IntN(4)]
+	bet := 20 * rand.IntN(5)
+	toCall := 20 * rand.IntN(10)
+	return BotView{
+		Hand:       deck[:2],
+		Board:      deck[2 : 2+board],
+		Pot:        40 + bet + toCall + 20*rand.IntN(50),
+		ToCall:     toCall,
+		MinRaiseTo: bet + 2*max(toCall, 20),
+		Chips:      toCall + 20 + rand.IntN(2000),
+		Bet:        bet,
+		BigBlind:   20,
+		Opponents:  1 + rand.IntN(3),
+	}
+}
+
+func TestStrategiesPlayLegalActions(t *testing.T) {
+	strategies := map[string]BotStrategy{
+		"random": randomStrategy{},
+		"tag":    tagStrategy{},
+		"equity": equityStrategy{trials: 50},
+	}
+	for name, s := range strategies {
+		if _, ok := botStrategies[name]; !ok || s.Name() != name {
+			t.Errorf("strategy %s is not registered under its name", name)
+		}
+		for range 300 {
+			v := randomView()
+			if d := s.Decide(v); !v.allows(d) {
+				t.Fatalf("%s: %s %d is not legal with %+v", name, d.Action, d.Amount, v.legalActions())
+			}
+		}
+	}
+}
+
+func TestTagStrategy(t *testing.T) {
+	tests := []struct {
+		name string
+		view BotView
+		want string
+	}{
+		{"raises aces", BotView{Hand: cards(t, "As Ad"), ToCall: 20, Chips: 1000, Pot: 30, MinRaiseTo: 40, BigBlind: 20}, "raise"},
+		{"calls a small raise with a pair", BotView{Hand: cards(t, "5s 5d"), ToCall: 40, Chips: 1000, Pot: 70, MinRaiseTo: 80, BigBlind: 20}, "call"},
+		{"folds a pair to a big raise", BotView{Hand: cards(t, "5s 5d"), ToCall: 200, Chips: 1000, Pot: 230, MinRaiseTo: 400, BigBlind: 20}, "fold"},
+		{"folds rags", BotView{Hand: cards(t, "7s 2d"), ToCall: 20, Chips: 1000, Pot: 30, MinRaiseTo: 40, BigBlind: 20}, "fold"},
+		{"checks rags in the big blind", BotView{Hand: cards(t, "7s 2d"), Chips: 1000, Pot: 40, MinRaiseTo: 40, BigBlind: 20}, "check"},
+		{"bets a set", BotView{Hand: cards(t, "9s 9d"), Board: cards(t, "9c 4h 2s"), Chips: 1000, Pot: 100, MinRaiseTo: 20, BigBlind: 20}, "raise"},
+		{"bets top pair", BotView{Hand: cards(t, "Ks Qd"), Board: cards(t, "Kc 7h 2s"), Chips: 1000, Pot: 100, MinRaiseTo: 20, BigBlind: 20}, "raise"},
+		{"checks a pair on the board", BotView{Hand: cards(t, "As 3d"), Board: cards(t, "8c 8h 2s"), Chips: 1000, Pot: 100, MinRaiseTo: 20, BigBlind: 20}, "check"},
+		{"checks two pair that is all board", BotView{Hand: cards(t, "3s 4d"), Board: cards(t, "Kc Kh 9s 9d Ah"), Chips: 1000, Pot: 100, MinRaiseTo: 20, BigBlind: 20}, "check"},
+		{"folds nothing to a bet", BotView{Hand: cards(t, "Js Td"), Board: cards(t, "Ac 7h 2s"), ToCall: 100, Chips: 1000, Pot: 200, MinRaiseTo: 200, BigBlind: 20}, "fold"},
+	}
+	for _, tt := range tests {
+		if d := (tagStrategy{}).Decide(tt.view); d.Action != tt.want {
+			t.Errorf("%s: %s, want %s", tt.name, d.Action, tt.want)
+		}
+	}
+}
+
+func TestStartingHandTier(t *testing.T) {
+	tests := []struct {
+		hand string
+		want int
+	}{
+		{"As Ad", 1}, {"Ts Td", 1}, {"As Kd", 1}, {"As Qs", 1},
+		{"As Qd", 2}, {"2s 2d", 2}, {"Ks Jd", 2}, {"9s Ts", 2},
+		{"9s Td", 3}, {"7s 2d", 3}, {"Ks 9s", 3},
+	}
+	for _, tt := range tests {
+		if got := startingHandTier(cards(t, tt.hand)); got != tt.want {
+			t.Errorf("%s: tier %d, want %d", tt.hand, got, tt.want)
+		}
+	}
+}
+
+func TestHandEquity(t *testing.T) {
+	if e := handEquity(cards(t, "As Ks"), cards(t, "Qs Js Ts"), 2, 200); e != 1 {
+		t.Errorf("a royal flush has equity %v, want 1", e)
+	}
+	if e := handEquity(cards(t, "As Ad"), nil, 1, 2000); e < 0.75 || e > 0.92 {
+		t.Errorf("aces heads-up have equity %v, want about 0.85", e)
+	}
+	if e := handEquity(cards(t, "7s 2d"), nil, 1, 2000); e < 0.25 || e > 0.42 {
+		t.Errorf("seven-deuce heads-up has equity %v, want about 0.35", e)
+	}
+	if e := handEquity(cards(t, "As Ad"), nil, 30, 10); e != 0 {
+		t.Errorf("equity %v with more opponents than cards, want 0", e)
+	}
+}
+
+func TestEquityStrategy(t *testing.T) {
+	s := equityStrategy{trials: 500}
+	nuts := BotView{Hand: cards(t, "As Ks"), Board: cards(t, "Qs Js Ts"), ToCall: 100, Chips: 1000, Pot: 200, MinRaiseTo: 200, BigBlind: 20, Opponents: 1}
+	if d := s.Decide(nuts); d.Action != "raise" {
+		t.Errorf("the nuts: %s, want raise", d.Action)
+	}
+	// The board plays, so every runout is a split
+	boardPlays := BotView{Hand: cards(t, "7d 2c"), Board: cards(t, "As Ks Qs Js Ts"), ToCall: 100, Chips: 1000, Pot: 200, MinRaiseTo: 200, BigBlind: 20, Opponents: 1}
+	if d := s.Decide(boardPlays); d.Action != "call" {
+		t.Errorf("a royal flush on the board: %s, want call", d.Action)
+	}
+	air := BotView{Hand: cards(t, "3d 2c"), Board: cards(t, "As Kh Qs Jd 8s"), ToCall: 1000, Chips: 1000, Pot: 200, MinRaiseTo: 2000, BigBlind: 20, Opponents: 3}
+	if d := s.Decide(air); d.Action != "fold" {
+		t.Errorf("no hand facing a big bet: %s, want fold", d.Action)
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/gorilla/websocket"
+)
+
+// --- Wire codecs ---
+//
+// Inside the server every message is a JSON-encoded Message. A Codec turns
+// that into what goes on the wire for one connection and back, so the hub
+// marshals a broadcast once and each connection only pays for the encoding
+// it asked for. The codec is picked with the WebSocket subprotocol; clients
+// that ask for none get JSON.
+
+// Codec encodes messages for one connection.
+type Codec interface {
+	// Subprotocol is the Sec-WebSocket-Protocol value that selects the codec.
+	Subprotocol() string
+	// FrameType is the WebSocket message type frames are sent as.
+	FrameType() int
+	// Encode converts a JSON-encoded Message to a frame.
+	Encode(msg []byte) ([]byte, error)
+	// Decode converts a frame back to a JSON-encoded Message.
+	Decode(frame []byte) ([]byte, error)
+}
+
+const (
+	jsonSubprotocol    = "dpoker.v1.json"
+	msgpackSubprotocol = "dpoker.v1.msgpack"
+)
+
+// codecs in order of server preference, as offered during the upgrade.
+var codecs = []Codec{msgpackCodec{}, jsonCodec{}}
+
+// codecFor returns the codec for the subprotocol negotiated on a connection.
+func codecFor(subprotocol string) Codec {
+	for _, c := range codecs {
+		if c.Subprotocol() == subprotocol {
+			return c
+		}
+	}
+	return jsonCodec{}
+}
+
+func codecSubprotocols() []string {
+	names := make([]string, len(codecs))
+	for i, c := range codecs {
+		names[i] = c.Subprotocol()
+	}
+	return names
+}
+
+// jsonCodec sends messages unchanged as text frames.
+type jsonCodec struct{}
+
+func (jsonCodec) Subprotocol() string                 { return jsonSubprotocol }
+func (jsonCodec) FrameType() int                      { return websocket.TextMessage }
+func (jsonCodec) Encode(msg []byte) ([]byte, error)   { return msg, nil }
+func (jsonCodec) Decode(frame []byte) ([]byte, error) { return frame, nil }
+
+// msgpackCodec sends the same message structure as MessagePack binary frames:
+// objects become maps, integral numbers become integers, and so on. Binary and
+// extension types are never produced and are refused on input.
+type msgpackCodec struct{}
+
+func (msgpackCodec) Subprotocol() string { return msgpackSubprotocol }
+func (msgpackCodec) FrameType() int      { return websocket.BinaryMessage }
+
+func (msgpackCodec) Encode(msg []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(msg))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := writeMsgpack(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Decode(frame []byte) ([]byte, error) {
+	r := &msgpackReader{data: frame}
+	v, err := r.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if r.pos != len(r.data) {
+		return nil, errors.New("msgpack: trailing data")
+	}
+	return json.Marshal(v)
+}
+
+func writeMsgpack(buf *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if v {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case json.Number:
+		if i, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			writeMsgpackInt(buf, i)
+			return nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(0xcb)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(f))
+	case string:
+		n := len(v)
+		switch {
+		case n < 32:
+			buf.WriteByte(0xa0 | byte(n))
+		case n <= math.MaxUint8:
+			buf.Write([]byte{0xd9, byte(n)})
+		case n <= math.MaxUint16:
+			buf.WriteByte(0xda)
+			binary.Write(buf, binary.BigEndian, uint16(n))
+		default:
+			buf.WriteByte(0xdb)
+			binary.Write(buf, binary.BigEndian, uint32(n))
+		}
+		buf.WriteString(v)
+	case []any:
+		writeMsgpackHeader(buf, len(v), 0x90, 0xdc, 0xdd)
+		for _, item := range v {
+			if err := writeMsgpack(buf, item); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		writeMsgpackHeader(buf, len(v), 0x80, 0xde, 0xdf)
+		for _, k := range keys {
+			writeMsgpack(buf, k)
+			if err := writeMsgpack(buf, v[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("msgpack: cannot encode %T", v)
+	}
+	return nil
+}
+
+func writeMsgpackInt(buf *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0 && i <= 0x7f:
+		buf.WriteByte(byte(i))
+	case i < 0 && i >= -32:
+		buf.WriteByte(byte(int8(i)))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		buf.Write([]byte{0xd0, byte(int8(i))})
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		buf.WriteByte(0xd1)
+		binary.Write(buf, binary.BigEndian, int16(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		buf.WriteByte(0xd2)
+		binary.Write(buf, binary.BigEndian, int32(i))
+	default:
+		buf.WriteByte(0xd3)
+		binary.Write(buf, binary.BigEndian, i)
+	}
+}
+
+// writeMsgpackHeader writes an array or map length using the fix, 16-bit or
+// 32-bit form.
+func writeMsgpackHeader(buf *bytes.Buffer, n int, fix, b16, b32 byte) {
+	switch {
+	case n < 16:
+		buf.WriteByte(fix | byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(b16)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(b32)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+// maxMsgpackDepth bounds nesting so a hostile frame cannot exhaust the stack.
+const maxMsgpackDepth = 32
+
+type msgpackReader struct {
+	data []byte
+	pos  int
+}
+
+var errMsgpackShort = errors.New("msgpack: unexpected end of data")
+
+func (r *msgpackReader) next(n int) ([]byte, error) {
+	if n < 0 || r.pos+n > len(r.data) {
+		return nil, errMsgpackShort
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *msgpackReader) uint(n int) (uint64, error) {
+	b, err := r.next(n)
+	if err != nil {
+		return 0, err
+	}
+	var u uint64
+	for _, c := range b {
+		u = u<<8 | uint64(c)
+	}
+	return u, nil
+}
+
+func (r *msgpackReader) value(depth int) (any, error) {
+	if depth > maxMsgpackDepth {
+		return nil, errors.New("msgpack: nested too deeply")
+	}
+	b, err := r.next(1)
+	if err != nil {
+		return nil, err
+	}
+	t := b[0]
+	switch {
+	case t <= 0x7f:
+		return int64(t), nil
+	case t >= 0xe0:
+		return int64(int8(t)), nil
+	case t&0xf0 == 0x80:
+		return r.mapOf(int(t&0x0f), depth)
+	case t&0xf0 == 0x90:
+		return r.arrayOf(int(t&0x0f), depth)
+	case t&0xe0 == 0xa0:
+		return r.str(int(t & 0x1f))
+	}
+	switch t {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xca:
+		u, err := r.uint(4)
+		return float64(math.Float32frombits(uint32(u))), err
+	case 0xcb:
+		u, err := r.uint(8)
+		return math.Float64frombits(u), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return r.uint(1 << (t - 0xcc))
+	case 0xd0:
+		u, err := r.uint(1)
+		return int64(int8(u)), err
+	case 0xd1:
+		u, err := r.uint(2)
+		return int64(int16(u)), err
+	case 0xd2:
+		u, err := r.uint(4)
+		return int64(int32(u)), err
+	case 0xd3:
+		u, err := r.uint(8)
+		return int64(u), err
+	case 0xd9, 0xda, 0xdb:
+		n, err := r.uint(1 << (t - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return r.str(int(n))
+	case 0xdc, 0xdd:
+		n, err := r.uint(2 << (t - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return r.arrayOf(int(n), depth)
+	case 0xde, 0xdf:
+		n, err := r.uint(2 << (t - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return r.mapOf(int(n), depth)
+	}
+	return nil, fmt.Errorf("msgpack: unsupported type 0x%02x", t)
+}
+
+func (r *msgpackReader) str(n int) (string, error) {
+	b, err := r.next(n)
+	return string(b), err
+}
+
+func (r *msgpackReader) arrayOf(n int, depth int) ([]any, error) {
+	if n > len(r.data)-r.pos {
+		return nil, errMsgpackShort
+	}
+	items := make([]any, n)
+	for i := range items {
+		v, err := r.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = v
+	}
+	return items, nil
+}
+
+func (r *msgpackReader) mapOf(n int, depth int) (map[string]any, error) {
+	if n > len(r.data)-r.pos {
+		return nil, errMsgpackShort
+	}
+	m := make(map[string]any, n)
+	for i := 0; i < n; i++ {
+		k, err := r.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, errors.New("msgpack: map keys must be strings")
+		}
+		if m[key], err = r.value(depth + 1); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// jsonValue decodes msg for comparisons that ignore key order and number
+// formatting.
+func jsonValue(t *testing.T, msg []byte) any {
+	t.Helper()
+	var v any
+	if err := json.Unmarshal(msg, &v); err != nil {
+		t.Fatalf("%s: %v", msg, err)
+	}
+	return v
+}
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	h := newTestTable(t, "random")
+	alice := sitDown(t, h, "Alice", 1000)
+	bob := sitDown(t, h, "Bob", 1000)
+	dealHand(t, h, alice, bob)
+	state, err := json.Marshal(snapshot(h))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gameState, _ := json.Marshal(Message{Type: "gameState", Seq: 7, Payload: state})
+
+	many := make([]int, 70000)
+	for i := range many {
+		many[i] = i - 35000
+	}
+	manyInts, _ := json.Marshal(many)
+	wide := make(map[string]int, 20)
+	for i := range 20 {
+		wide[strings.Repeat("k", i+1)] = i
+	}
+	wideMap, _ := json.Marshal(wide)
+
+	tests := []struct {
+		name string
+		msg  string
+	}{
+		{"game state", string(gameState)},
+		{"nil and empty", `{"nil":null,"array":[],"map":{},"string":"","false":false,"true":true}`},
+		{"negative ints", `[-1,-32,-33,-128,-129,-32768,-32769,-2147483648,-2147483649,-9223372036854775808]`},
+		{"positive ints", `[0,127,128,255,256,65535,65536,4294967295,4294967296,9223372036854775807]`},
+		{"floats", `[0.5,-1.25,3.141592653589793,1e-300,1.7976931348623157e308]`},
+		{"strings at the length boundaries", `["` + strings.Repeat("a", 31) + `","` + strings.Repeat("b", 32) + `","` + strings.Repeat("c", 255) +
+			`","` + strings.Repeat("d", 256) + `","` + strings.Repeat("e", 65535) + `","` + strings.Repeat("f", 65536) + `"]`},
+		{"unicode", `{"chat":"xin chào ♠♥ 🂡","escaped":"\"\\/\n"}`},
+		{"long array", string(manyInts)},
+		{"16-bit map", string(wideMap)},
+		{"nested", `{"a":[{"b":[[],[{}],null]}]}`},
+	}
+	codec := msgpackCodec{}
+	for _, tt := range tests {
+		frame, err := codec.Encode([]byte(tt.msg))
+		if err != nil {
+			t.Errorf("%s: encoding: %v", tt.name, err)
+			continue
+		}
+		back, err := codec.Decode(frame)
+		if err != nil {
+			t.Errorf("%s: decoding: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(jsonValue(t, back), jsonValue(t, []byte(tt.msg))) {
+			t.Errorf("%s: %.200s came back as %.200s", tt.name, tt.msg, back)
+		}
+	}
+}
+
+func TestMsgpackEncoding(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want []byte
+	}{
+		{`{"b":-1,"a":1}`, []byte{0x82, 0xa1, 'a', 0x01, 0xa1, 'b', 0xff}},
+		{`[null,true,false]`, []byte{0x93, 0xc0, 0xc3, 0xc2}},
+		{`[-33,200,-200]`, []byte{0x93, 0xd0, 0xdf, 0xd1, 0x00, 0xc8, 0xd1, 0xff, 0x38}},
+		{`0.5`, []byte{0xcb, 0x3f, 0xe0, 0, 0, 0, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		got, err := msgpackCodec{}.Encode([]byte(tt.msg))
+		if err != nil || !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: got % x, %v, want % x", tt.msg, got, err, tt.want)
+		}
+	}
+}
+
+func TestMsgpackRejectsMalformedFrames(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame []byte
+	}{
+		{"empty", nil},
+		{"string longer than the frame", []byte{0xa5, 'a', 'b'}},
+		{"oversized str32", []byte{0xdb, 0xff, 0xff, 0xff, 0xff, 'a'}},
+		{"oversized array32", []byte{0xdd, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{"oversized map32", []byte{0xdf, 0xff, 0xff, 0xff, 0xff, 0xa1, 'a', 0x01}},
+		{"oversized array16", []byte{0xdc, 0xff, 0xff}},
+		{"truncated length", []byte{0xda, 0x01}},
+		{"truncated float", []byte{0xcb, 0x3f, 0xe0}},
+		{"never used", []byte{0xc1}},
+		{"bin8", []byte{0xc4, 0x01, 0x00}},
+		{"ext8", []byte{0xc7, 0x01, 0x01, 0x00}},
+		{"fixext1", []byte{0xd4, 0x01, 0x00}},
+		{"integer map key", []byte{0x81, 0x01, 0x02}},
+		{"trailing data", []byte{0xc0, 0xc0}},
+		{"nested too deeply", bytes.Repeat([]byte{0x91}, maxMsgpackDepth+2)},
+		{"NaN", []byte{0xcb, 0x7f, 0xf8, 0, 0, 0, 0, 0, 1}},
+	}
+	for _, tt := range tests {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		if got, err := (msgpackCodec{}).Decode(tt.frame); err == nil {
+			t.Errorf("%s: decoded as %s", tt.name, got)
+		}
+		runtime.ReadMemStats(&after)
+		if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+			t.Errorf("%s: allocated %d bytes", tt.name, n)
+		}
+	}
+
+	// Every cut of a valid frame is refused
+	frame, err := msgpackCodec{}.Encode([]byte(`{"type":"player_action","payload":{"action":"raise","amount":-300,"chat":["` + strings.Repeat("x", 300) + `"]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := range len(frame) {
+		if _, err := (msgpackCodec{}).Decode(frame[:n]); err == nil {
+			t.Fatalf("a frame cut to %d of %d bytes decoded", n, len(frame))
+		}
+	}
+}
+
+func TestSubprotocolNegotiation(t *testing.T) {
+	lobby := newTestLobby(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { serveWs(lobby, w, r) }))
+	defer srv.Close()
+	t.Cleanup(func() { lobby.shutdown(0) })
+
+	tests := []struct {
+		name      string
+		offered   []string
+		want      string
+		frameType int
+	}{
+		{"none", nil, "", websocket.TextMessage},
+		{"unknown", []string{"dpoker.v9.cbor"}, "", websocket.TextMessage},
+		{"json", []string{jsonSubprotocol}, jsonSubprotocol, websocket.TextMessage},
+		{"msgpack", []string{msgpackSubprotocol}, msgpackSubprotocol, websocket.BinaryMessage},
+		{"server preference", []string{jsonSubprotocol, msgpackSubprotocol}, msgpackSubprotocol, websocket.BinaryMessage},
+	}
+	for _, tt := range tests {
+		dialer := websocket.Dialer{Subprotocols: tt.offered}
+		conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := conn.Subprotocol(); got != tt.want {
+			t.Errorf("%s: negotiated %q, want %q", tt.name, got, tt.want)
+		}
+		codec := codecFor(conn.Subprotocol())
+		hello, _ := json.Marshal(HelloPayload{ProtocolVersion: ProtocolVersion})
+		msg, _ := json.Marshal(Message{Type: "hello", Payload: hello})
+		frame, _ := codec.Encode(msg)
+		if err := conn.WriteMessage(codec.FrameType(), frame); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+		frameType, frame, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var welcome Message
+		data, err := codec.Decode(frame)
+		if err == nil {
+			err = json.Unmarshal(data, &welcome)
+		}
+		if frameType != tt.frameType || err != nil || welcome.Type != "welcome" {
+			t.Errorf("%s: got frame type %d, %s, %v, want frame type %d and welcome", tt.name, frameType, welcome.Type, err, tt.frameType)
+		}
+		conn.Close()
+	}
+}
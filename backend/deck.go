@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DeckSource decides the card order of each hand. The default source shuffles
+// with the provably fair seed; the seeded and stacked sources exist so tests
+// and bug reports can replay exact deals and are only enabled in dev mode.
+type DeckSource interface {
+	Name() string
+	// NextDeck returns the full 52-card deck for the next hand. fairSeed is
+	// the seed derived from the commit-reveal scheme in fairness.go.
+	NextDeck(fairSeed []byte) []Card
+}
+
+type randomDeckSource struct{}
+
+func (randomDeckSource) Name() string { return "random" }
+
+func (randomDeckSource) NextDeck(fairSeed []byte) []Card {
+	return shuffleDeck(fairSeed)
+}
+
+// seededDeckSource shuffles hand n with SHA-256(seed || uint64be(n)), so a
+// server started with the same seed deals the same sequence of hands.
+type seededDeckSource struct {
+	seed string
+	hand uint64
+}
+
+func (s *seededDeckSource) Name() string { return "seeded" }
+
+func (s *seededDeckSource) NextDeck([]byte) []Card {
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], s.hand)
+	s.hand++
+	sum := sha256.Sum256(append([]byte(s.seed), n[:]...))
+	return shuffleDeck(sum[:])
+}
+
+// stackedDeckSource deals explicit card lists. Each list is placed on top of
+// the deck and the remaining cards follow in canonical order. With several
+// lists, hands cycle through them in order.
+type stackedDeckSource struct {
+	decks [][]Card
+	hand  int
+}
+
+func (s *stackedDeckSource) Name() string { return "stacked" }
+
+func (s *stackedDeckSource) NextDeck([]byte) []Card {
+	top := s.decks[s.hand%len(s.decks)]
+	s.hand++
+	deck := make([]Card, 0, 52)
+	deck = append(deck, top...)
+	for _, c := range newOrderedDeck() {
+		if !containsCard(top, c) {
+			deck = append(deck, c)
+		}
+	}
+	return deck
+}
+
+func containsCard(cards []Card, card Card) bool {
+	for _, c := range cards {
+		if c == card {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDeckSource parses a deck source spec:
+//
+//	random
+//	seeded:<any string>
+//	stacked:<cards>[;<cards>...]
+//
+// Cards are written rank then suit, e.g. "As Kd 10c Th 2♠". The deal order
+// is two hole cards per player in seat order, then a burn card before the
+// flop, turn and river.
+func parseDeckSource(spec string) (DeckSource, error) {
+	kind, arg, _ := strings.Cut(strings.TrimSpace(spec), ":")
+	switch kind {
+	case "", "random":
+		return randomDeckSource{}, nil
+	case "seeded":
+		if arg == "" {
+			return nil, fmt.Errorf("seeded deck needs a seed, e.g. seeded:1234")
+		}
+		return &seededDeckSource{seed: arg}, nil
+	case "stacked":
+		var decks [][]Card
+		for _, list := range strings.Split(arg, ";") {
+			cards, err := parseCards(list)
+			if err != nil {
+				return nil, err
+			}
+			if len(cards) > 0 {
+				decks = append(decks, cards)
+			}
+		}
+		if len(decks) == 0 {
+			return nil, fmt.Errorf("stacked deck needs at least one card")
+		}
+		return &stackedDeckSource{decks: decks}, nil
+	}
+	return nil, fmt.Errorf("unknown deck source %q", kind)
+}
+
+func parseCards(list string) ([]Card, error) {
+	var cards []Card
+	for _, field := range strings.FieldsFunc(list, func(r rune) bool { return r == ' ' || r == ',' }) {
+		card, err := parseCard(field)
+		if err != nil {
+			return nil, err
+		}
+		if containsCard(cards, card) {
+			return nil, fmt.Errorf("card %s listed twice", field)
+		}
+		cards = append(cards, card)
+	}
+	return cards, nil
+}
+
+var suitAliases = map[string]string{
+	"h": "♥", "♥": "♥",
+	"d": "♦", "♦": "♦",
+	"c": "♣", "♣": "♣",
+	"s": "♠", "♠": "♠",
+}
+
+func parseCard(s string) (Card, error) {
+	s = strings.ToLower(s)
+	for alias, suit := range suitAliases {
+		if rank, ok := strings.CutSuffix(s, alias); ok && rank != "" {
+			rank = strings.ToUpper(rank)
+			if rank == "T" {
+				rank = "10"
+			}
+			if rankToInt(rank) == 0 {
+				break
+			}
+			return Card{Suit: suit, Rank: rank}, nil
+		}
+	}
+	return Card{}, fmt.Errorf("invalid card %q", s)
+}
+
+func (h *Hub) setDeckSource(src DeckSource) {
+	h.gameStateMutex.Lock()
+	defer h.gameStateMutex.Unlock()
+	h.deckSource = src
+	h.gameState.Fairness.DeckSource = src.Name()
+}
+
+// useDeckSource makes every table deal from the deck source spec, including
+// tables created later. Each table gets a source of its own, so a seeded or
+// stacked deck deals the same hands at every table.
+func (l *Lobby) useDeckSource(spec string) error {
+	if _, err := parseDeckSource(spec); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	l.deckSpec = spec
+	l.mu.Unlock()
+	for _, hub := range l.tableList() {
+		src, _ := parseDeckSource(spec)
+		hub.setDeckSource(src)
+	}
+	return nil
+}
+
+// serveDevDeck replaces the deck source of ?table=<id>. It is only
+// registered in dev mode. The request body is a deck source spec, see
+// parseDeckSource.
+func serveDevDeck(lobby *Lobby, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	hub := lobby.table(r.URL.Query().Get("table"))
+	if hub == nil {
+		http.Error(w, "table not found", http.StatusNotFound)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 4096))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	src, err := parseDeckSource(string(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	hub.setDeckSource(src)
+	hub.log.Info("deck source set", "deck", src.Name())
+	w.WriteHeader(http.StatusNoContent)
+}
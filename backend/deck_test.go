@@ -0,0 +1,127 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseDeckSource(t *testing.T) {
+	tests := []struct {
+		spec    string
+		name    string
+		wantErr bool
+	}{
+		{"", "random", false},
+		{"random", "random", false},
+		{"seeded:1234", "seeded", false},
+		{"seeded:", "", true},
+		{"stacked:As Kd 10c Th 2♠", "stacked", false},
+		{"stacked:As,Kd;2c 3c", "stacked", false},
+		{"stacked:", "", true},
+		{"stacked:As As", "", true},
+		{"stacked:1s", "", true},
+		{"shuffled", "", true},
+	}
+	for _, tt := range tests {
+		src, err := parseDeckSource(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseDeckSource(%q) error = %v, want error %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if err == nil && src.Name() != tt.name {
+			t.Errorf("parseDeckSource(%q) = %s, want %s", tt.spec, src.Name(), tt.name)
+		}
+	}
+}
+
+func TestSeededDeckRepeats(t *testing.T) {
+	a, _ := parseDeckSource("seeded:1234")
+	b, _ := parseDeckSource("seeded:1234")
+	first := a.NextDeck(nil)
+	if !slices.Equal(first, b.NextDeck(nil)) {
+		t.Fatal("the same seed dealt different first hands")
+	}
+	if slices.Equal(first, a.NextDeck(nil)) {
+		t.Fatal("the second hand repeated the first")
+	}
+}
+
+func TestStackedDeck(t *testing.T) {
+	src, err := parseDeckSource("stacked:As Kd;2c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for hand, top := range [][]Card{cards(t, "As Kd"), cards(t, "2c"), cards(t, "As Kd")} {
+		deck := src.NextDeck(nil)
+		if len(deck) != 52 {
+			t.Fatalf("hand %d: %d cards", hand, len(deck))
+		}
+		if !slices.Equal(deck[:len(top)], top) {
+			t.Errorf("hand %d starts %v, want %v", hand, deck[:len(top)], top)
+		}
+		seen := make(map[Card]bool)
+		for _, c := range deck {
+			if seen[c] {
+				t.Fatalf("hand %d: %v dealt twice", hand, c)
+			}
+			seen[c] = true
+		}
+	}
+}
+
+func TestDeckSourceAppliesToNewTables(t *testing.T) {
+	lobby := newTestLobby(t)
+	if err := lobby.useDeckSource("stacked:As Ad"); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := createTableRequest{ID: "later", Name: "Later"}.tableConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	later, err := lobby.createTable(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, hub := range []*Hub{lobby.table(defaultTableID), later} {
+		if got := snapshot(hub).Fairness.DeckSource; got != "stacked" {
+			t.Errorf("table %s deals from %s, want stacked", hub.config.ID, got)
+		}
+	}
+	if lobby.table(defaultTableID).deckSource == later.deckSource {
+		t.Error("tables share one deck source")
+	}
+}
+
+func TestStackedDeckShowdown(t *testing.T) {
+	// Hole cards go out two at a time in seat order, with a burn card
+	// before each street
+	h := newTestTable(t, "stacked:As Ah Kc Kd 2c Qs 7d 3c 2d 4h 2h 9s")
+	alice := sitDown(t, h, "Alice", 500)
+	bob := sitDown(t, h, "Bob", 500)
+	dealHand(t, h, alice, bob)
+
+	s := snapshot(h)
+	if !slices.Equal(s.Players[alice].Hand, cards(t, "As Ah")) || !slices.Equal(s.Players[bob].Hand, cards(t, "Kc Kd")) {
+		t.Fatalf("dealt %v and %v", s.Players[alice].Hand, s.Players[bob].Hand)
+	}
+	act(t, h, alice, "call", 0)
+	act(t, h, bob, "check", 0)
+	for _, street := range []string{"flop", "turn", "river"} {
+		if s := snapshot(h); s.GamePhase != street {
+			t.Fatalf("phase %s, want %s", s.GamePhase, street)
+		}
+		act(t, h, bob, "check", 0)
+		act(t, h, alice, "check", 0)
+	}
+
+	s = snapshot(h)
+	if s.GamePhase != "showdown" {
+		t.Fatalf("phase %s, want showdown", s.GamePhase)
+	}
+	if want := cards(t, "Qs 7d 3c 4h 9s"); !slices.Equal(s.CommunityCards, want) {
+		t.Errorf("board %v, want %v", s.CommunityCards, want)
+	}
+	if a, b := s.Players[alice].Chips, s.Players[bob].Chips; a != 520 || b != 480 {
+		t.Errorf("stacks after the showdown are %d and %d, want 520 and 480", a, b)
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"time"
+)
+
+// --- Event log ---
+//
+// Every change to the game state is also expressed as an append-only
+// GameEvent. reduceEvents rebuilds the GameState from a hand's events, and
+// verifyEventLogUnsafe compares the rebuilt state with the live one when a
+// hand ends, so any mutation that is not mirrored by an event shows up in
+// the logs instead of silently corrupting histories.
+
+const (
+	EventHandStarted      = "hand_started"
+	EventHoleCardsDealt   = "hole_cards_dealt"
+	EventBlindPosted      = "blind_posted"
+	EventPlayerActed      = "player_acted"
+	EventStreetDealt      = "street_dealt"
+	EventShowdown         = "showdown"
+	EventPotAwarded       = "pot_awarded"
+	EventHandEnded        = "hand_ended"
+	EventPlayerJoined     = "player_joined"
+	EventPlayerRenamed    = "player_renamed"
+	EventPlayerConnection = "player_connection"
+	EventPlayerLeft       = "player_left"
+	EventSeatChanged      = "seat_changed"
+	EventHandCancelled    = "hand_cancelled"
+	EventChipsAdjusted    = "chips_adjusted"
+	EventRebuy            = "rebuy"
+	EventAddOn            = "add_on"
+)
+
+const maxEventLog = 5000
+
+type GameEvent struct {
+	Seq      int       `json:"seq"`
+	Type     string    `json:"type"`
+	At       time.Time `json:"at"`
+	HandID   string    `json:"handId,omitempty"`
+	PlayerID string    `json:"playerId,omitempty"`
+	Name     string    `json:"name,omitempty"`
+	Seat     int       `json:"seat,omitempty"`
+	Action   string    `json:"action,omitempty"`
+	Amount   int       `json:"amount,omitempty"`
+	AllIn    bool      `json:"allIn,omitempty"`
+	Phase    string    `json:"phase,omitempty"`
+	Cards    []Card    `json:"cards,omitempty"`
+	// LastBet and MinRaise are the betting level after the event.
+	LastBet     int              `json:"lastBet,omitempty"`
+	MinRaise    int              `json:"minRaise,omitempty"`
+	Connected   bool             `json:"connected,omitempty"`
+	PlayerOrder []string         `json:"playerOrder,omitempty"`
+	DealerIndex int              `json:"dealerIndex,omitempty"`
+	Players     []PlayerSnapshot `json:"players,omitempty"`
+	Payouts     []Payout         `json:"payouts,omitempty"`
+	Eliminated  []string         `json:"eliminated,omitempty"`
+	Left        []string         `json:"left,omitempty"`
+	Reason      string           `json:"reason,omitempty"`
+	// Commitment is the fairness commitment of a hand_started event.
+	Commitment string `json:"commitment,omitempty"`
+}
+
+// PlayerSnapshot is the part of a Player that carries over between hands.
+type PlayerSnapshot struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Seat        int    `json:"seat"`
+	Chips       int    `json:"chips"`
+	IsConnected bool   `json:"isConnected"`
+}
+
+type Payout struct {
+	PlayerID string `json:"playerId"`
+	Amount   int    `json:"amount"`
+}
+
+// emitEventUnsafe appends an event to the log and to the running hand.
+func (h *Hub) emitEventUnsafe(e GameEvent) {
+	h.eventSeq++
+	e.Seq = h.eventSeq
+	e.At = time.Now()
+	if e.HandID == "" && h.gameState.GameStarted {
+		e.HandID = h.gameState.HandID
+	}
+	h.eventLog = append(h.eventLog, e)
+	if len(h.eventLog) > maxEventLog {
+		h.eventLog = h.eventLog[len(h.eventLog)-maxEventLog:]
+	}
+	if e.Type == EventHandStarted {
+		h.handEvents = nil
+	}
+	if h.gameState.GameStarted || e.Type == EventHandStarted || e.Type == EventHandEnded {
+		h.handEvents = append(h.handEvents, e)
+	}
+	h.broadcastPublicEventUnsafe(e)
+}
+
+func (h *Hub) emitHandStartedUnsafe() {
+	e := GameEvent{
+		Type:        EventHandStarted,
+		HandID:      h.gameState.HandID,
+		PlayerOrder: slices.Clone(h.gameState.PlayerOrder),
+		DealerIndex: h.gameState.DealerIndex,
+		MinRaise:    h.config.BigBlind,
+		Commitment:  h.gameState.Fairness.Commitment,
+	}
+	for id, p := range h.gameState.Players {
+		e.Players = append(e.Players, PlayerSnapshot{ID: id, Name: p.Name, Seat: p.Seat, Chips: p.Chips, IsConnected: p.IsConnected})
+	}
+	slices.SortFunc(e.Players, func(a, b PlayerSnapshot) int {
+		if a.ID < b.ID {
+			return -1
+		}
+		if a.ID > b.ID {
+			return 1
+		}
+		return 0
+	})
+	h.emitEventUnsafe(e)
+}
+
+// reduceEvents rebuilds the game state from the events of one hand,
+// starting at its hand_started event.
+func reduceEvents(events []GameEvent) GameState {
+	s := GameState{
+		Players:        make(map[string]Player),
+		GamePhase:      "waiting",
+		DealerIndex:    -1,
+		CommunityCards: []Card{},
+		SidePots:       []SidePot{},
+	}
+	for _, e := range events {
+		applyEvent(&s, e)
+	}
+	return s
+}
+
+func applyEvent(s *GameState, e GameEvent) {
+	collectBets := func() {
+		for id, p := range s.Players {
+			s.Pot += p.Bet
+			p.Bet = 0
+			s.Players[id] = p
+		}
+	}
+
+	switch e.Type {
+	case EventHandStarted:
+		s.Players = make(map[string]Player, len(e.Players))
+		for _, ps := range e.Players {
+			s.Players[ps.ID] = Player{ID: ps.ID, Name: ps.Name, Seat: ps.Seat, Chips: ps.Chips, IsConnected: ps.IsConnected, Hand: []Card{}}
+		}
+		for _, id := range e.PlayerOrder {
+			p := s.Players[id]
+			p.IsInHand = true
+			s.Players[id] = p
+		}
+		s.HandID = e.HandID
+		s.GameStarted = true
+		s.GamePhase = "pre-flop"
+		s.Pot = 0
+		s.CommunityCards = []Card{}
+		s.PlayerOrder = slices.Clone(e.PlayerOrder)
+		s.DealerIndex = e.DealerIndex
+		if len(s.PlayerOrder) > 0 {
+			s.ButtonSeat = s.Players[s.PlayerOrder[e.DealerIndex]].Seat
+		}
+		s.LastBet = 0
+		s.MinRaise = e.MinRaise
+	case EventHoleCardsDealt:
+		p := s.Players[e.PlayerID]
+		p.Hand = append(p.Hand, e.Cards...)
+		s.Players[e.PlayerID] = p
+	case EventBlindPosted:
+		p := s.Players[e.PlayerID]
+		p.Chips -= e.Amount
+		p.Bet += e.Amount
+		s.Players[e.PlayerID] = p
+		s.LastBet = e.LastBet
+	case EventPlayerActed:
+		p := s.Players[e.PlayerID]
+		if e.Action == "fold" {
+			p.IsInHand = false
+		}
+		p.Chips -= e.Amount
+		p.Bet += e.Amount
+		p.IsAllIn = p.IsAllIn || e.AllIn
+		s.Players[e.PlayerID] = p
+		s.LastBet = e.LastBet
+		s.MinRaise = e.MinRaise
+	case EventStreetDealt:
+		collectBets()
+		s.GamePhase = e.Phase
+		s.CommunityCards = append(s.CommunityCards, e.Cards...)
+		s.LastBet = 0
+		s.MinRaise = e.MinRaise
+	case EventShowdown:
+		collectBets()
+		s.GamePhase = "showdown"
+	case EventPotAwarded, EventHandCancelled:
+		collectBets()
+		for _, payout := range e.Payouts {
+			p := s.Players[payout.PlayerID]
+			p.Chips += payout.Amount
+			s.Players[payout.PlayerID] = p
+		}
+		s.Pot = 0
+	case EventHandEnded:
+		for id, p := range s.Players {
+			p.Hand, p.Bet, p.IsInHand = []Card{}, 0, false
+			p.IsAllIn = false
+			s.Players[id] = p
+		}
+		for _, id := range e.Eliminated {
+			delete(s.Players, id)
+		}
+		for _, id := range e.Left {
+			delete(s.Players, id)
+		}
+		s.GameStarted = false
+		s.GamePhase = "waiting"
+		s.CommunityCards = []Card{}
+		s.SidePots = []SidePot{}
+		s.Pot = 0
+		s.MinRaise = e.MinRaise
+	case EventPlayerJoined:
+		s.Players[e.PlayerID] = Player{ID: e.PlayerID, Name: e.Name, Seat: e.Seat, Chips: e.Amount, IsConnected: e.Connected, Hand: []Card{}}
+	case EventChipsAdjusted, EventRebuy, EventAddOn:
+		p := s.Players[e.PlayerID]
+		p.Chips += e.Amount
+		s.Players[e.PlayerID] = p
+	case EventSeatChanged:
+		p := s.Players[e.PlayerID]
+		p.Seat = e.Seat
+		s.Players[e.PlayerID] = p
+	case EventPlayerRenamed:
+		p := s.Players[e.PlayerID]
+		p.Name = e.Name
+		s.Players[e.PlayerID] = p
+	case EventPlayerConnection:
+		p := s.Players[e.PlayerID]
+		p.IsConnected = e.Connected
+		if !e.Connected && s.GameStarted {
+			p.IsInHand = false
+		}
+		s.Players[e.PlayerID] = p
+	case EventPlayerLeft:
+		delete(s.Players, e.PlayerID)
+	}
+}
+
+type stateDigestPlayer struct {
+	Name        string
+	Seat        int
+	Chips       int
+	Bet         int
+	Hand        []Card
+	IsConnected bool
+	IsInHand    bool
+	IsAllIn     bool
+}
+
+type stateDigest struct {
+	HandID         string
+	GameStarted    bool
+	GamePhase      string
+	Pot            int
+	CommunityCards []Card
+	PlayerOrder    []string
+	DealerIndex    int
+	Players        map[string]stateDigestPlayer
+}
+
+// digestState keeps the fields the event log is expected to reproduce.
+func digestState(s GameState) stateDigest {
+	d := stateDigest{
+		HandID:         s.HandID,
+		GameStarted:    s.GameStarted,
+		GamePhase:      s.GamePhase,
+		Pot:            s.Pot,
+		CommunityCards: slices.Clone(s.CommunityCards),
+		PlayerOrder:    slices.Clone(s.PlayerOrder),
+		DealerIndex:    s.DealerIndex,
+		Players:        make(map[string]stateDigestPlayer, len(s.Players)),
+	}
+	if len(d.CommunityCards) == 0 {
+		d.CommunityCards = nil
+	}
+	for id, p := range s.Players {
+		hand := slices.Clone(p.Hand)
+		if len(hand) == 0 {
+			hand = nil
+		}
+		d.Players[id] = stateDigestPlayer{
+			Name:        p.Name,
+			Seat:        p.Seat,
+			Chips:       p.Chips,
+			Bet:         p.Bet,
+			Hand:        hand,
+			IsConnected: p.IsConnected,
+			IsInHand:    p.IsInHand,
+			IsAllIn:     p.IsAllIn,
+		}
+	}
+	return d
+}
+
+// verifyEventLogUnsafe rebuilds the state from the current hand's events
+// and reports any difference from the live state.
+func (h *Hub) verifyEventLogUnsafe(stage string) bool {
+	if len(h.handEvents) == 0 {
+		return true
+	}
+	live := digestState(h.gameState)
+	rebuilt := digestState(reduceEvents(h.handEvents))
+	if reflect.DeepEqual(live, rebuilt) {
+		return true
+	}
+	h.logUnsafe().Error("EVENT LOG MISMATCH", "stage", stage, "diff", describeDigestDiff(live, rebuilt))
+	return false
+}
+
+// describeDigestDiff names the first difference between two digests. It
+// goes to the error log, so hole cards are left out of it.
+func describeDigestDiff(live, rebuilt stateDigest) string {
+	if !reflect.DeepEqual(live.Players, rebuilt.Players) {
+		for id, lp := range live.Players {
+			rp, ok := rebuilt.Players[id]
+			if !ok {
+				return fmt.Sprintf("player %s missing from rebuilt state", id)
+			}
+			if reflect.DeepEqual(lp, rp) {
+				continue
+			}
+			handsDiffer := !slices.Equal(lp.Hand, rp.Hand)
+			lp.Hand, rp.Hand = nil, nil
+			if handsDiffer && reflect.DeepEqual(lp, rp) {
+				return fmt.Sprintf("player %s hole cards differ", id)
+			}
+			return fmt.Sprintf("player %s live=%+v rebuilt=%+v hole cards differ=%v", id, lp, rp, handsDiffer)
+		}
+		return "rebuilt state has extra players"
+	}
+	lp, rp := live, rebuilt
+	lp.Players, rp.Players = nil, nil
+	return fmt.Sprintf("live=%+v rebuilt=%+v", lp, rp)
+}
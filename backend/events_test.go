@@ -0,0 +1,92 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// checkEventLog fails the test if the state rebuilt from the running hand's
+// events differs from the live state.
+func checkEventLog(t *testing.T, h *Hub, stage string) {
+	t.Helper()
+	h.gameStateMutex.RLock()
+	defer h.gameStateMutex.RUnlock()
+	live := digestState(h.gameState)
+	rebuilt := digestState(reduceEvents(h.handEvents))
+	if !reflect.DeepEqual(live, rebuilt) {
+		t.Errorf("%s: %s", stage, describeDigestDiff(live, rebuilt))
+	}
+}
+
+func TestEventLogReproducesSidePotHand(t *testing.T) {
+	h := newTestTable(t, "stacked:As Ad Ks Kd 7c 2h 3s Qc 8d 4s 3d 9h 3h 5c")
+	alice := sitDown(t, h, "Alice", 400)
+	bob := sitDown(t, h, "Bob", 1000)
+	carol := sitDown(t, h, "Carol", 2000)
+	dealHand(t, h, alice, bob, carol)
+	checkEventLog(t, h, "after the blinds")
+
+	steps := []struct {
+		player, action string
+		amount         int
+	}{
+		{alice, "raise", 400},
+		{bob, "call", 0},
+		{carol, "call", 0},
+		{bob, "raise", 600},
+		{carol, "call", 0},
+		{carol, "check", 0},
+		{carol, "check", 0},
+	}
+	for _, step := range steps {
+		act(t, h, step.player, step.action, step.amount)
+		checkEventLog(t, h, snapshot(h).Players[step.player].Name+" "+step.action)
+	}
+	if s := snapshot(h); len(s.SidePots) != 2 {
+		t.Fatalf("%d pots, want a main pot and a side pot", len(s.SidePots))
+	}
+	endShowdown(t, h)
+	checkEventLog(t, h, "after the hand")
+}
+
+func TestEventLogReproducesFoldedHands(t *testing.T) {
+	h := newTestTable(t, "random")
+	alice, bob, carol := playFoldAndShowdown(t, h)
+	checkEventLog(t, h, "at showdown")
+	endShowdown(t, h)
+	checkEventLog(t, h, "after the showdown")
+
+	// The next hand is folded around before the flop
+	dealHand(t, h, alice, bob, carol)
+	for i := 0; i < 2; i++ {
+		s := snapshot(h)
+		act(t, h, s.PlayerOrder[s.CurrentTurnIndex], "fold", 0)
+		checkEventLog(t, h, "after a fold")
+	}
+}
+
+func TestDigestDiffHidesHoleCards(t *testing.T) {
+	digest := func(hand string, chips int) stateDigest {
+		return stateDigest{Players: map[string]stateDigestPlayer{
+			"p1": {Name: "Alice", Chips: chips, Hand: cards(t, hand)},
+		}}
+	}
+	tests := []struct {
+		live, rebuilt stateDigest
+		want          string
+	}{
+		{digest("As Ad", 100), digest("Ks Kd", 100), "player p1 hole cards differ"},
+		{digest("As Ad", 100), digest("As Ad", 90), "hole cards differ=false"},
+		{digest("As Ad", 100), digest("Ks Kd", 90), "hole cards differ=true"},
+	}
+	for _, tt := range tests {
+		got := describeDigestDiff(tt.live, tt.rebuilt)
+		if !strings.Contains(got, tt.want) {
+			t.Errorf("diff %q, want %q", got, tt.want)
+		}
+		if strings.ContainsAny(got, "♠♥♦♣") {
+			t.Errorf("diff %q shows hole cards", got)
+		}
+	}
+}
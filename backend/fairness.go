@@ -0,0 +1,276 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// --- Provably fair shuffling ---
+//
+// Every hand is shuffled with a commit-reveal scheme so players can check
+// that the deck was fixed before any entropy of theirs was seen:
+//
+//  1. While the table is waiting, the server picks a random 32-byte server
+//     seed and publishes commitment = hex(SHA-256(serverSeed)).
+//  2. Players may send "client_seed" messages. When the hand starts the
+//     contributions are combined into clientSeed = "id1:seed1|id2:seed2|..."
+//     sorted by player ID, and published with the hand.
+//  3. The deck is shuffled with shuffleDeck(HMAC-SHA256(serverSeed, clientSeed)).
+//  4. When the hand ends the server seed is revealed. Anyone can recompute
+//     the commitment and the deck, or ask /fairness/verify to do it.
+//
+// shuffleDeck starts from the canonical deck order (suits ♥ ♦ ♣ ♠, ranks 2
+// through A) and runs a Fisher-Yates shuffle from the last card down. Random
+// numbers come from the stream SHA-256(seed || uint64be(n)) for n = 0, 1, ...
+// read as 8-byte big-endian words; j in [0, i] is drawn with rejection
+// sampling so every permutation is equally likely.
+
+const (
+	maxClientSeedLen  = 64
+	maxRevealedHands  = 200
+	serverSeedByteLen = 32
+)
+
+type FairnessInfo struct {
+	HandID     string          `json:"handId"`
+	Commitment string          `json:"commitment"`
+	ClientSeed string          `json:"clientSeed,omitempty"`
+	DeckSource string          `json:"deckSource"`
+	LastHand   *FairnessReveal `json:"lastHand,omitempty"`
+}
+
+type FairnessReveal struct {
+	HandID     string `json:"handId"`
+	Commitment string `json:"commitment"`
+	ServerSeed string `json:"serverSeed"`
+	ClientSeed string `json:"clientSeed"`
+}
+
+type ClientSeedPayload struct {
+	Seed string `json:"seed"`
+}
+
+// fairDeckSeed derives the shuffle seed from the server seed and the
+// combined client seed.
+func fairDeckSeed(serverSeed []byte, clientSeed string) []byte {
+	mac := hmac.New(sha256.New, serverSeed)
+	mac.Write([]byte(clientSeed))
+	return mac.Sum(nil)
+}
+
+func seedCommitment(serverSeed []byte) string {
+	sum := sha256.Sum256(serverSeed)
+	return hex.EncodeToString(sum[:])
+}
+
+// combineClientSeeds joins client contributions in a stable order.
+func combineClientSeeds(seeds map[string]string) string {
+	ids := make([]string, 0, len(seeds))
+	for id := range seeds {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	parts := make([]string, 0, len(ids))
+	for _, id := range ids {
+		parts = append(parts, id+":"+seeds[id])
+	}
+	return strings.Join(parts, "|")
+}
+
+// newOrderedDeck returns a fresh copy of the deck in canonical order.
+func newOrderedDeck() []Card {
+	deck := deckPool.Get().([]Card)
+	// Create a copy to avoid modifying the pooled deck
+	ordered := make([]Card, len(deck))
+	copy(ordered, deck)
+	deckPool.Put(deck) // Return to pool immediately
+	return ordered
+}
+
+type seedStream struct {
+	seed    []byte
+	counter uint64
+	buf     []byte
+}
+
+func (s *seedStream) uint64() uint64 {
+	if len(s.buf) < 8 {
+		var ctr [8]byte
+		binary.BigEndian.PutUint64(ctr[:], s.counter)
+		s.counter++
+		block := sha256.Sum256(append(append([]byte{}, s.seed...), ctr[:]...))
+		s.buf = block[:]
+	}
+	v := binary.BigEndian.Uint64(s.buf[:8])
+	s.buf = s.buf[8:]
+	return v
+}
+
+// intn returns a uniform value in [0, n) using rejection sampling.
+func (s *seedStream) intn(n uint64) uint64 {
+	limit := ^uint64(0) - (^uint64(0) % n)
+	for {
+		if v := s.uint64(); v < limit {
+			return v % n
+		}
+	}
+}
+
+// shuffleDeck deterministically shuffles the canonical deck with seed.
+func shuffleDeck(seed []byte) []Card {
+	deck := newOrderedDeck()
+	stream := &seedStream{seed: seed}
+	for i := len(deck) - 1; i > 0; i-- {
+		j := stream.intn(uint64(i + 1))
+		deck[i], deck[j] = deck[j], deck[i]
+	}
+	return deck
+}
+
+// prepareNextHandUnsafe draws and commits to the server seed for the next hand.
+func (h *Hub) prepareNextHandUnsafe() {
+	seed := make([]byte, serverSeedByteLen)
+	if _, err := rand.Read(seed); err != nil {
+		// crypto/rand never fails on supported platforms
+		panic(fmt.Sprintf("crypto/rand: %v", err))
+	}
+	h.serverSeed = seed
+	h.nextHandID = uuid.New().String()
+	h.clientSeeds = make(map[string]string)
+	h.gameState.Fairness.HandID = h.nextHandID
+	h.gameState.Fairness.Commitment = seedCommitment(seed)
+	h.gameState.Fairness.ClientSeed = ""
+}
+
+// nextDeckUnsafe shuffles the deck for the hand being started and
+// publishes the client seed that went into it. Unless the deck source is
+// "random" the commitment says nothing about the deal.
+func (h *Hub) nextDeckUnsafe() []Card {
+	clientSeed := combineClientSeeds(h.clientSeeds)
+	h.gameState.Fairness.ClientSeed = clientSeed
+	return h.deckSource.NextDeck(fairDeckSeed(h.serverSeed, clientSeed))
+}
+
+// revealHandSeedUnsafe publishes the server seed of the finished hand and
+// commits to a new one.
+func (h *Hub) revealHandSeedUnsafe() {
+	reveal := FairnessReveal{
+		HandID:     h.gameState.Fairness.HandID,
+		Commitment: h.gameState.Fairness.Commitment,
+		ServerSeed: hex.EncodeToString(h.serverSeed),
+		ClientSeed: h.gameState.Fairness.ClientSeed,
+	}
+	h.revealedHands[reveal.HandID] = reveal
+	h.revealedOrder = append(h.revealedOrder, reveal.HandID)
+	if len(h.revealedOrder) > maxRevealedHands {
+		delete(h.revealedHands, h.revealedOrder[0])
+		h.revealedOrder = h.revealedOrder[1:]
+	}
+	h.gameState.Fairness.LastHand = &reveal
+	h.attachFairnessRevealUnsafe(reveal)
+	h.prepareNextHandUnsafe()
+}
+
+func (h *Hub) handleClientSeed(playerID string, payloadBytes json.RawMessage) error {
+	var payload ClientSeedPayload
+	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+		return protocolErrorf(CodeInvalidMessage, "invalid client_seed payload")
+	}
+	if payload.Seed == "" || len(payload.Seed) > maxClientSeedLen {
+		return protocolErrorf(CodeInvalidMessage, "seed must be 1 to %d bytes", maxClientSeedLen)
+	}
+
+	h.gameStateMutex.Lock()
+	defer h.gameStateMutex.Unlock()
+
+	if h.gameState.GameStarted {
+		// Entropy only counts for the hand that has not been dealt yet
+		return protocolErrorf(CodeHandInProgress, "client seeds are only accepted between hands")
+	}
+	if _, exists := h.gameState.Players[playerID]; !exists {
+		return protocolErrorf(CodeNotSeated, "not seated at this table")
+	}
+	h.clientSeeds[playerID] = payload.Seed
+	return nil
+}
+
+// fairnessReveal finds the revealed seed of a hand, falling back to the
+// stored hand histories for hands from before a restart.
+func (l *Lobby) fairnessReveal(handID string) (FairnessReveal, bool) {
+	for _, hub := range l.tableList() {
+		hub.gameStateMutex.RLock()
+		reveal, ok := hub.revealedHands[handID]
+		hub.gameStateMutex.RUnlock()
+		if ok {
+			return reveal, true
+		}
+	}
+	if hist := l.handHistory(handID); hist != nil && hist.Fairness != nil {
+		return *hist.Fairness, true
+	}
+	return FairnessReveal{}, false
+}
+
+type FairnessVerification struct {
+	HandID          string `json:"handId,omitempty"`
+	Commitment      string `json:"commitment"`
+	ServerSeed      string `json:"serverSeed"`
+	ClientSeed      string `json:"clientSeed"`
+	CommitmentValid bool   `json:"commitmentValid"`
+	Deck            []Card `json:"deck"`
+}
+
+// serveFairnessVerify re-derives the deck of a revealed hand. It accepts
+// either ?hand=<id> for a hand played on this server, or
+// ?serverSeed=<hex>&clientSeed=<string>[&commitment=<hex>] for any input.
+func serveFairnessVerify(lobby *Lobby, w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var result FairnessVerification
+	if handID := q.Get("hand"); handID != "" {
+		reveal, ok := lobby.fairnessReveal(handID)
+		if !ok {
+			http.Error(w, "hand not found or not yet revealed", http.StatusNotFound)
+			return
+		}
+		result = FairnessVerification{
+			HandID:     reveal.HandID,
+			Commitment: reveal.Commitment,
+			ServerSeed: reveal.ServerSeed,
+			ClientSeed: reveal.ClientSeed,
+		}
+	} else {
+		result = FairnessVerification{
+			Commitment: q.Get("commitment"),
+			ServerSeed: q.Get("serverSeed"),
+			ClientSeed: q.Get("clientSeed"),
+		}
+	}
+
+	serverSeed, err := hex.DecodeString(result.ServerSeed)
+	if err != nil || len(serverSeed) == 0 {
+		http.Error(w, "serverSeed must be a hex string", http.StatusBadRequest)
+		return
+	}
+	computed := seedCommitment(serverSeed)
+	if result.Commitment == "" {
+		result.Commitment = computed
+	}
+	result.CommitmentValid = strings.EqualFold(result.Commitment, computed)
+	result.Deck = shuffleDeck(fairDeckSeed(serverSeed, result.ClientSeed))
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		slog.Warn("writing fairness verification failed", "err", err)
+	}
+}
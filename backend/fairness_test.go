@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// playFairHand plays a checked-down heads-up hand with client seeds from
+// both players and returns the players, the hand ID and the commitment of
+// its hand_started event.
+func playFairHand(t *testing.T, h *Hub) (alice, bob, handID, commitment string) {
+	t.Helper()
+	alice = sitDown(t, h, "Alice", 1000)
+	bob = sitDown(t, h, "Bob", 1000)
+	for id, seed := range map[string]string{alice: "lucky", bob: "seven"} {
+		payload, _ := json.Marshal(ClientSeedPayload{Seed: seed})
+		if err := h.handleClientSeed(id, payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dealHand(t, h, alice, bob)
+
+	h.gameStateMutex.RLock()
+	handID = h.gameState.HandID
+	for _, e := range h.handEvents {
+		if e.Type == EventHandStarted {
+			commitment = e.Commitment
+		}
+	}
+	h.gameStateMutex.RUnlock()
+	if commitment == "" {
+		t.Fatal("the hand_started event has no commitment")
+	}
+
+	act(t, h, alice, "call", 0)
+	act(t, h, bob, "check", 0)
+	for range 3 {
+		act(t, h, bob, "check", 0)
+		act(t, h, alice, "check", 0)
+	}
+	return alice, bob, handID, commitment
+}
+
+// dealtHoleCards returns the hole cards of the hand in the order they were
+// dealt from the top of the deck.
+func dealtHoleCards(s GameState) []Card {
+	var dealt []Card
+	for _, id := range s.PlayerOrder {
+		dealt = append(dealt, s.Players[id].Hand...)
+	}
+	return dealt
+}
+
+func TestCommitmentMatchesRevealedSeed(t *testing.T) {
+	h := newTestTable(t, "random")
+	alice, bob, handID, commitment := playFairHand(t, h)
+	dealt := dealtHoleCards(snapshot(h))
+	endShowdown(t, h)
+
+	reveal := snapshot(h).Fairness.LastHand
+	if reveal == nil || reveal.HandID != handID {
+		t.Fatalf("revealed %+v, want hand %s", reveal, handID)
+	}
+	if reveal.Commitment != commitment {
+		t.Errorf("revealed commitment %s, want %s", reveal.Commitment, commitment)
+	}
+	seed, err := hex.DecodeString(reveal.ServerSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := seedCommitment(seed); got != commitment {
+		t.Errorf("SHA-256 of the server seed is %s, want the commitment %s", got, commitment)
+	}
+	if want := combineClientSeeds(map[string]string{alice: "lucky", bob: "seven"}); reveal.ClientSeed != want {
+		t.Errorf("client seed %q, want %q", reveal.ClientSeed, want)
+	}
+	deck := shuffleDeck(fairDeckSeed(seed, reveal.ClientSeed))
+	if !reflect.DeepEqual(deck[:len(dealt)], dealt) {
+		t.Errorf("the seeds give %v, but %v was dealt", deck[:len(dealt)], dealt)
+	}
+
+	// The next hand commits to a new seed
+	if next := snapshot(h).Fairness; next.Commitment == commitment || next.HandID == handID {
+		t.Errorf("the next hand reuses the commitment of %s", handID)
+	}
+}
+
+func TestShuffleDeckIsDeterministic(t *testing.T) {
+	seed := []byte("server seed")
+	deck := shuffleDeck(fairDeckSeed(seed, "a:1|b:2"))
+	tests := []struct {
+		name       string
+		serverSeed []byte
+		clientSeed string
+		same       bool
+	}{
+		{"same seeds", seed, "a:1|b:2", true},
+		{"other client seed", seed, "a:1|b:3", false},
+		{"other server seed", []byte("server seeD"), "a:1|b:2", false},
+	}
+	for _, tt := range tests {
+		got := shuffleDeck(fairDeckSeed(tt.serverSeed, tt.clientSeed))
+		if reflect.DeepEqual(got, deck) != tt.same {
+			t.Errorf("%s: same deck %v, want %v", tt.name, !tt.same, tt.same)
+		}
+	}
+
+	seen := make(map[Card]bool)
+	for _, c := range deck {
+		seen[c] = true
+	}
+	if len(deck) != 52 || len(seen) != 52 {
+		t.Errorf("the shuffled deck has %d cards, %d distinct", len(deck), len(seen))
+	}
+	if reflect.DeepEqual(deck, newOrderedDeck()) {
+		t.Error("the deck was not shuffled")
+	}
+}
+
+func TestClientSeedOnlyBetweenHands(t *testing.T) {
+	h := newTestTable(t, "random")
+	alice := sitDown(t, h, "Alice", 1000)
+	bob := sitDown(t, h, "Bob", 1000)
+	payload, _ := json.Marshal(ClientSeedPayload{Seed: "lucky"})
+
+	tests := []struct {
+		name   string
+		player string
+		seed   json.RawMessage
+		code   string
+	}{
+		{"seated player", alice, payload, ""},
+		{"not seated", "stranger", payload, CodeNotSeated},
+		{"empty seed", alice, json.RawMessage(`{"seed":""}`), CodeInvalidMessage},
+		{"seed too long", alice, json.RawMessage(`{"seed":"` + strings.Repeat("x", maxClientSeedLen+1) + `"}`), CodeInvalidMessage},
+	}
+	for _, tt := range tests {
+		checkProtocolError(t, tt.name, h.handleClientSeed(tt.player, tt.seed), tt.code)
+	}
+
+	dealHand(t, h, alice, bob)
+	checkProtocolError(t, "during a hand", h.handleClientSeed(bob, payload), CodeHandInProgress)
+	if want := combineClientSeeds(map[string]string{alice: "lucky"}); snapshot(h).Fairness.ClientSeed != want {
+		t.Errorf("the hand was shuffled with %q, want %q", snapshot(h).Fairness.ClientSeed, want)
+	}
+}
+
+// checkProtocolError fails the test unless err carries code, or is nil
+// when code is empty.
+func checkProtocolError(t *testing.T, name string, err error, code string) {
+	t.Helper()
+	if code == "" {
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		return
+	}
+	var perr *ProtocolError
+	if !errors.As(err, &perr) || perr.Code != code {
+		t.Errorf("%s: got %v, want %s", name, err, code)
+	}
+}
+
+func TestFairnessVerify(t *testing.T) {
+	lobby := newTestLobby(t)
+	h := lobby.table(defaultTableID)
+	_, _, handID, commitment := playFairHand(t, h)
+	dealt := dealtHoleCards(snapshot(h))
+	endShowdown(t, h)
+	reveal := snapshot(h).Fairness.LastHand
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveFairnessVerify(lobby, w, r)
+	}))
+	defer srv.Close()
+	verify := func(query url.Values) (FairnessVerification, int) {
+		t.Helper()
+		resp, err := http.Get(srv.URL + "?" + query.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var v FairnessVerification
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return v, resp.StatusCode
+	}
+
+	v, status := verify(url.Values{"hand": {handID}})
+	if status != http.StatusOK || !v.CommitmentValid || v.Commitment != commitment {
+		t.Fatalf("verifying the hand: status %d, %+v", status, v)
+	}
+	if !reflect.DeepEqual(v.Deck[:len(dealt)], dealt) {
+		t.Errorf("rebuilt deck starts %v, but %v was dealt", v.Deck[:len(dealt)], dealt)
+	}
+
+	seed, _ := hex.DecodeString(reveal.ServerSeed)
+	seed[0] ^= 1
+	tampered := hex.EncodeToString(seed)
+	tests := []struct {
+		name   string
+		query  url.Values
+		status int
+		valid  bool
+	}{
+		{"revealed seeds", url.Values{"serverSeed": {reveal.ServerSeed}, "clientSeed": {reveal.ClientSeed}, "commitment": {commitment}}, http.StatusOK, true},
+		{"tampered server seed", url.Values{"serverSeed": {tampered}, "clientSeed": {reveal.ClientSeed}, "commitment": {commitment}}, http.StatusOK, false},
+		{"unknown hand", url.Values{"hand": {"no-such-hand"}}, http.StatusNotFound, false},
+		{"seed not hex", url.Values{"serverSeed": {"zz"}}, http.StatusBadRequest, false},
+	}
+	for _, tt := range tests {
+		v, status := verify(tt.query)
+		if status != tt.status || v.CommitmentValid != tt.valid {
+			t.Errorf("%s: status %d, valid %v, want %d, %v", tt.name, status, v.CommitmentValid, tt.status, tt.valid)
+		}
+	}
+}
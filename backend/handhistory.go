@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"time"
+)
+
+const maxHandHistories = 200
+
+// HandHistory is the record of one finished hand. Frames holds the public
+// game_state payloads broadcast during the hand, in order, so the hand can be
+// replayed by the regular frontend. Hole cards appear in frames only for the
+// players who showed them down.
+type HandHistory struct {
+	ID        string            `json:"id"`
+	TableID   string            `json:"tableId"`
+	BigBlind  int               `json:"bigBlind,omitempty"`
+	StartedAt time.Time         `json:"startedAt"`
+	EndedAt   time.Time         `json:"endedAt"`
+	Players   []HandPlayer      `json:"players"`
+	DealerID  string            `json:"dealerId"`
+	Actions   []HandAction      `json:"actions"`
+	Board     []Card            `json:"board"`
+	Winners   []string          `json:"winners"`
+	Result    string            `json:"result"`
+	Rake      int               `json:"rake,omitempty"`
+	Fairness  *FairnessReveal   `json:"fairness,omitempty"`
+	Events    []GameEvent       `json:"events"`
+	Frames    []json.RawMessage `json:"frames"`
+}
+
+type HandPlayer struct {
+	ID            string `json:"id"`
+	AccountID     string `json:"accountId,omitempty"`
+	Name          string `json:"name"`
+	Seat          int    `json:"seat,omitempty"`
+	StartingChips int    `json:"startingChips"`
+	EndingChips   int    `json:"endingChips"`
+	Hand          []Card `json:"hand"`
+}
+
+type HandAction struct {
+	Phase    string    `json:"phase"`
+	PlayerID string    `json:"playerId"`
+	Action   string    `json:"action"`
+	Amount   int       `json:"amount"`
+	At       time.Time `json:"at"`
+}
+
+// beginHandHistoryUnsafe starts recording the hand that was just dealt.
+func (h *Hub) beginHandHistoryUnsafe() {
+	hist := &HandHistory{
+		ID:        h.gameState.HandID,
+		TableID:   h.config.ID,
+		BigBlind:  h.config.BigBlind,
+		StartedAt: time.Now(),
+		DealerID:  h.gameState.PlayerOrder[h.gameState.DealerIndex],
+	}
+	for _, id := range h.gameState.PlayerOrder {
+		p := h.gameState.Players[id]
+		hist.Players = append(hist.Players, HandPlayer{
+			ID:            id,
+			AccountID:     p.AccountID,
+			Name:          p.Name,
+			Seat:          p.Seat,
+			StartingChips: p.Chips,
+			Hand:          append([]Card(nil), p.Hand...),
+		})
+	}
+	h.currentHand = hist
+}
+
+func (h *Hub) recordActionUnsafe(playerID, action string, amount int) {
+	if h.currentHand == nil {
+		return
+	}
+	h.currentHand.Actions = append(h.currentHand.Actions, HandAction{
+		Phase:    h.gameState.GamePhase,
+		PlayerID: playerID,
+		Action:   action,
+		Amount:   amount,
+		At:       time.Now(),
+	})
+}
+
+func (h *Hub) recordWinnersUnsafe(winnerIDs []string) {
+	if h.currentHand == nil {
+		return
+	}
+	h.currentHand.Winners = append(h.currentHand.Winners, winnerIDs...)
+}
+
+// recordFrameUnsafe appends the public state to the hand being recorded.
+// Chat is left out of replays.
+func (h *Hub) recordFrameUnsafe() {
+	if h.currentHand == nil {
+		return
+	}
+	frameState := h.publicStateUnsafe()
+	frameState.ChatMessages = nil
+	frame, err := json.Marshal(frameState)
+	if err != nil {
+		h.logUnsafe().Error("marshaling replay frame failed", "err", err)
+		return
+	}
+	frames := h.currentHand.Frames
+	if len(frames) > 0 && bytes.Equal(frames[len(frames)-1], frame) {
+		return
+	}
+	h.currentHand.Frames = append(frames, frame)
+}
+
+// publicFrames strips hole cards and account IDs the public state would not
+// show from frames recorded before they were taken from it. A frame that
+// cannot be read is left out.
+func publicFrames(frames []json.RawMessage) []json.RawMessage {
+	public := make([]json.RawMessage, 0, len(frames))
+	for _, frame := range frames {
+		var state GameState
+		if err := json.Unmarshal(frame, &state); err != nil {
+			continue
+		}
+		for id, p := range state.Players {
+			state.Players[id] = publicPlayer(p, state.GamePhase)
+		}
+		data, err := json.Marshal(state)
+		if err != nil {
+			continue
+		}
+		public = append(public, data)
+	}
+	return public
+}
+
+// public returns the hand as anyone may see it: hole cards only for the
+// players who showed them down, and no account IDs.
+func (hist *HandHistory) public() *HandHistory {
+	shown := showdownPlayers(hist.Events)
+	pub := *hist
+	pub.Players = publicHandPlayers(hist.Players, shown)
+	pub.Events = make([]GameEvent, len(hist.Events))
+	for i, e := range hist.Events {
+		if e.Type == EventHoleCardsDealt {
+			e.Cards = nil
+		}
+		pub.Events[i] = e
+	}
+	pub.Frames = publicFrames(hist.Frames)
+	return &pub
+}
+
+// publicHandPlayers is publicPlayer for the players of a recorded hand.
+func publicHandPlayers(players []HandPlayer, shown map[string]bool) []HandPlayer {
+	public := make([]HandPlayer, len(players))
+	for i, p := range players {
+		if !shown[p.ID] {
+			p.Hand = nil
+		}
+		p.AccountID = ""
+		public[i] = p
+	}
+	return public
+}
+
+// showdownPlayers replays a hand's events up to the showdown and returns
+// the players still in the hand there. It is empty for hands that ended
+// before a showdown.
+func showdownPlayers(events []GameEvent) map[string]bool {
+	shown := make(map[string]bool)
+	for i, e := range events {
+		if e.Type != EventShowdown {
+			continue
+		}
+		for id, p := range reduceEvents(events[:i+1]).Players {
+			if p.IsInHand {
+				shown[id] = true
+			}
+		}
+		break
+	}
+	return shown
+}
+
+// finishHandHistoryUnsafe closes the current record and keeps it for replay.
+// It runs before the table is reset so the final state is still visible.
+func (h *Hub) finishHandHistoryUnsafe(result string) {
+	hist := h.currentHand
+	if hist == nil {
+		return
+	}
+	h.currentHand = nil
+	hist.EndedAt = time.Now()
+	hist.Result = result
+	hist.Board = append([]Card(nil), h.gameState.CommunityCards...)
+	for i, hp := range hist.Players {
+		if p, ok := h.gameState.Players[hp.ID]; ok {
+			hist.Players[i].EndingChips = p.Chips
+		}
+	}
+
+	h.handHistories = append(h.handHistories, hist)
+	if len(h.handHistories) > maxHandHistories {
+		h.handHistories = h.handHistories[1:]
+	}
+}
+
+func (h *Hub) recentHandHistoryUnsafe(handID string) *HandHistory {
+	for i := len(h.handHistories) - 1; i >= 0; i-- {
+		if h.handHistories[i].ID == handID {
+			return h.handHistories[i]
+		}
+	}
+	return nil
+}
+
+// attachFairnessRevealUnsafe links the revealed seed to the hand's record.
+func (h *Hub) attachFairnessRevealUnsafe(reveal FairnessReveal) {
+	if hist := h.recentHandHistoryUnsafe(reveal.HandID); hist != nil {
+		hist.Fairness = &reveal
+	}
+}
+
+// attachHandEventsUnsafe stores the finished hand's event log with its record.
+func (h *Hub) attachHandEventsUnsafe() {
+	if hist := h.recentHandHistoryUnsafe(h.gameState.HandID); hist != nil {
+		hist.Events = slices.Clone(h.handEvents)
+	}
+}
+
+// persistHandHistoryUnsafe writes the hand that just ended to the store.
+func (h *Hub) persistHandHistoryUnsafe() {
+	if hist := h.recentHandHistoryUnsafe(h.gameState.HandID); hist != nil {
+		if err := h.store.SaveHandHistory(hist); err != nil {
+			h.log.Error("saving hand failed", "hand", hist.ID, "err", err)
+		}
+		h.stats.Record(hist)
+		h.leaders.Record(hist)
+		h.refreshHUDUnsafe()
+	}
+}
+
+func (h *Hub) handHistory(handID string) *HandHistory {
+	h.gameStateMutex.RLock()
+	defer h.gameStateMutex.RUnlock()
+	return h.recentHandHistoryUnsafe(handID)
+}
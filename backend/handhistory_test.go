@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+// playFoldAndShowdown deals three players; Alice folds before the flop and
+// Bob and Carol check it down to a showdown.
+func playFoldAndShowdown(t *testing.T, h *Hub) (alice, bob, carol string) {
+	t.Helper()
+	alice = sitDown(t, h, "Alice", 1000)
+	bob = sitDown(t, h, "Bob", 1000)
+	carol = sitDown(t, h, "Carol", 1000)
+	dealHand(t, h, alice, bob, carol)
+	act(t, h, alice, "fold", 0)
+	act(t, h, bob, "call", 0)
+	act(t, h, carol, "check", 0)
+	for range 3 {
+		act(t, h, bob, "check", 0)
+		act(t, h, carol, "check", 0)
+	}
+	return alice, bob, carol
+}
+
+func TestReplayFramesHideMuckedCards(t *testing.T) {
+	h := newTestTable(t, "random")
+	alice, bob, carol := playFoldAndShowdown(t, h)
+	handID := snapshot(h).HandID
+	endShowdown(t, h)
+
+	hist := h.handHistory(handID)
+	if hist == nil || len(hist.Frames) == 0 {
+		t.Fatal("the hand was not recorded")
+	}
+	shown := make(map[string]bool)
+	for i, frame := range hist.Frames {
+		if bytes.Contains(frame, []byte(`"accountId"`)) {
+			t.Errorf("frame %d has account IDs", i)
+		}
+		var state GameState
+		if err := json.Unmarshal(frame, &state); err != nil {
+			t.Fatal(err)
+		}
+		for id, p := range state.Players {
+			if len(p.Hand) == 0 {
+				continue
+			}
+			if state.GamePhase != "showdown" || id == alice {
+				t.Errorf("frame %d in %s shows the hand of %s", i, state.GamePhase, p.Name)
+			}
+			shown[id] = true
+		}
+	}
+	if !shown[bob] || !shown[carol] {
+		t.Error("the hands shown down are missing from the replay")
+	}
+}
+
+func TestPublicFramesRedactOldFrames(t *testing.T) {
+	hand := cards(t, "As Ad")
+	frame := func(phase string, inHand bool) json.RawMessage {
+		data, _ := json.Marshal(GameState{GamePhase: phase, Players: map[string]Player{
+			"p1": {ID: "p1", AccountID: "acct", Name: "Alice", Hand: hand, IsInHand: inHand},
+		}})
+		return data
+	}
+	tests := []struct {
+		name     string
+		frame    json.RawMessage
+		showHand bool
+	}{
+		{"before showdown", frame("flop", true), false},
+		{"folded at showdown", frame("showdown", false), false},
+		{"shown down", frame("showdown", true), true},
+	}
+	for _, tt := range tests {
+		public := publicFrames([]json.RawMessage{tt.frame})
+		if len(public) != 1 {
+			t.Fatalf("%s: %d frames", tt.name, len(public))
+		}
+		var state GameState
+		if err := json.Unmarshal(public[0], &state); err != nil {
+			t.Fatal(err)
+		}
+		p := state.Players["p1"]
+		if p.AccountID != "" {
+			t.Errorf("%s: account ID kept", tt.name)
+		}
+		if shows := len(p.Hand) > 0; shows != tt.showHand {
+			t.Errorf("%s: hand shown %v, want %v", tt.name, shows, tt.showHand)
+		}
+	}
+	if public := publicFrames([]json.RawMessage{json.RawMessage(`{"players":`)}); len(public) != 0 {
+		t.Error("an unreadable frame was kept")
+	}
+}
+
+func TestPublicHandHistory(t *testing.T) {
+	h := newTestTable(t, "random")
+	alice, bob, carol := playFoldAndShowdown(t, h)
+	handID := snapshot(h).HandID
+	endShowdown(t, h)
+
+	hist := h.handHistory(handID)
+	public := hist.public()
+	for _, p := range public.Players {
+		if p.AccountID != "" {
+			t.Errorf("%s: account ID kept", p.Name)
+		}
+		if shows := len(p.Hand) > 0; shows != (p.ID != alice) {
+			t.Errorf("%s: hand shown %v", p.Name, shows)
+		}
+	}
+	for _, e := range public.Events {
+		if e.Type == EventHoleCardsDealt && len(e.Cards) > 0 {
+			t.Errorf("hole_cards_dealt event for %s keeps the cards", e.PlayerID)
+		}
+	}
+	if len(public.Frames) != len(hist.Frames) {
+		t.Errorf("%d public frames of %d", len(public.Frames), len(hist.Frames))
+	}
+	for _, p := range hist.Players {
+		if p.AccountID == "" || len(p.Hand) == 0 {
+			t.Errorf("public changed the stored record of %s", p.Name)
+		}
+	}
+
+	summaries := h.recentHands(1)
+	if len(summaries) != 1 {
+		t.Fatalf("%d summaries", len(summaries))
+	}
+	shown := make(map[string]bool)
+	for _, p := range summaries[0].Players {
+		if p.AccountID != "" {
+			t.Errorf("summary of %s keeps the account ID", p.Name)
+		}
+		shown[p.ID] = len(p.Hand) > 0
+	}
+	if shown[alice] || !shown[bob] || !shown[carol] {
+		t.Errorf("summary shows hands %v, want only Bob's and Carol's", shown)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// newTestLobby starts a lobby with the default table on an empty data
+// directory.
+func newTestLobby(t *testing.T) *Lobby {
+	t.Helper()
+	store, err := openFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	lobby, err := newLobby(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lobby
+}
+
+// newTestTable returns the default table of a new lobby, dealing from the
+// deck source spec.
+func newTestTable(t *testing.T, deckSpec string) *Hub {
+	t.Helper()
+	lobby := newTestLobby(t)
+	if err := lobby.useDeckSource(deckSpec); err != nil {
+		t.Fatal(err)
+	}
+	return lobby.table(defaultTableID)
+}
+
+// sitDown registers name, seats a new connection as it with a buy-in of
+// buyIn chips and returns its player ID. Players sit in the lowest free seat,
+// so the order of the calls is the seat order.
+func sitDown(t *testing.T, h *Hub, name string, buyIn int) string {
+	t.Helper()
+	if _, err := h.accounts.Register(name, "test"); err != nil {
+		t.Fatalf("registering %s: %v", name, err)
+	}
+	id := uuid.NewString()
+	h.gameStateMutex.Lock()
+	defer h.gameStateMutex.Unlock()
+	h.addSpectatorUnsafe(id, name)
+	if err := h.seatSpectatorUnsafe(id, name, 0, buyIn); err != nil {
+		t.Fatalf("seating %s: %v", name, err)
+	}
+	return id
+}
+
+// dealHand readies the players and checks that a hand started.
+func dealHand(t *testing.T, h *Hub, ids ...string) {
+	t.Helper()
+	for _, id := range ids {
+		if err := h.handlePlayerReady(id, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := snapshot(h); !s.GameStarted {
+		t.Fatal("no hand was dealt")
+	}
+}
+
+// act plays a betting action and fails the test if it is refused.
+func act(t *testing.T, h *Hub, id, action string, amount int) {
+	t.Helper()
+	payload, _ := json.Marshal(PlayerActionPayload{Action: action, Amount: amount})
+	if err := h.handlePlayerAction(id, payload); err != nil {
+		t.Fatalf("%s %s %d: %v", snapshot(h).Players[id].Name, action, amount, err)
+	}
+}
+
+// snapshot copies the players and the fields of the game state the tests
+// look at, under the table lock.
+func snapshot(h *Hub) GameState {
+	h.gameStateMutex.RLock()
+	defer h.gameStateMutex.RUnlock()
+	s := h.gameState
+	s.Players = make(map[string]Player, len(h.gameState.Players))
+	for id, p := range h.gameState.Players {
+		s.Players[id] = p
+	}
+	s.PlayerOrder = append([]string(nil), h.gameState.PlayerOrder...)
+	s.CommunityCards = append([]Card(nil), h.gameState.CommunityCards...)
+	s.SidePots = append([]SidePot(nil), h.gameState.SidePots...)
+	return s
+}
+
+// waitFor polls the table until cond holds, for the all-in run-outs and
+// showdowns that play out on timers.
+func waitFor(t *testing.T, h *Hub, what string, cond func(GameState) bool) GameState {
+	t.Helper()
+	deadline := time.Now().Add(15 * time.Second)
+	for {
+		s := snapshot(h)
+		if cond(s) {
+			return s
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s; phase %s", what, s.GamePhase)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func cards(t *testing.T, list string) []Card {
+	t.Helper()
+	c, err := parseCards(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// endShowdown ends a hand waiting at showdown without the pause the table
+// gives players to look at the cards.
+func endShowdown(t *testing.T, h *Hub) {
+	t.Helper()
+	h.gameStateMutex.Lock()
+	defer h.gameStateMutex.Unlock()
+	if h.gameState.GamePhase != "showdown" {
+		t.Fatalf("phase %s, want showdown", h.gameState.GamePhase)
+	}
+	h.endGameUnsafe("Showdown finished.")
+}
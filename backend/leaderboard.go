@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Leaderboards ---
+//
+// LeaderBook ranks accounts by net chips won, biggest pot won and hands
+// played, over the current day, the current week and all time (UTC, weeks
+// starting on Monday). Like the statistics it is built from the stored hands
+// at startup and updated as each hand is saved; a day's or week's totals are
+// dropped once a hand from the next one comes in. The server has no
+// tournaments, so there is no tournaments-won board.
+
+const (
+	defaultLeaderboardSize = 10
+	maxLeaderboardSize     = 100
+)
+
+var (
+	leaderboardPeriods = []string{"daily", "weekly", "all_time"}
+	leaderboardKinds   = []string{"net", "biggest_pot", "hands"}
+)
+
+// Leaderboard is one ranking, from GET /api/leaderboards and the
+// leaderboard message.
+type Leaderboard struct {
+	Period  string             `json:"period"`
+	Board   string             `json:"board"`
+	Since   time.Time          `json:"since,omitzero"` // start of the period; zero for all time
+	Entries []LeaderboardEntry `json:"entries"`
+}
+
+type LeaderboardEntry struct {
+	Rank      int    `json:"rank"`
+	AccountID string `json:"accountId"`
+	Name      string `json:"name"`
+	Value     int    `json:"value"`
+}
+
+type leaderTotals struct {
+	name       string
+	net        int
+	biggestPot int
+	hands      int
+}
+
+func (t *leaderTotals) value(board string) int {
+	switch board {
+	case "net":
+		return t.net
+	case "biggest_pot":
+		return t.biggestPot
+	}
+	return t.hands
+}
+
+// leaderPeriod holds the totals of the day or week named by key.
+type leaderPeriod struct {
+	key    string
+	totals map[string]*leaderTotals // account ID -> totals
+}
+
+type LeaderBook struct {
+	mu      sync.Mutex
+	periods map[string]*leaderPeriod
+}
+
+func newLeaderBook() *LeaderBook {
+	b := &LeaderBook{periods: make(map[string]*leaderPeriod, len(leaderboardPeriods))}
+	for _, period := range leaderboardPeriods {
+		b.periods[period] = &leaderPeriod{totals: make(map[string]*leaderTotals)}
+	}
+	return b
+}
+
+// periodStart returns the start of the period holding t and a key that
+// sorts in time order.
+func periodStart(period string, t time.Time) (time.Time, string) {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch period {
+	case "daily":
+		return day, day.Format(time.DateOnly)
+	case "weekly":
+		year, week := t.ISOWeek()
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7), fmt.Sprintf("%04d-W%02d", year, week)
+	}
+	return time.Time{}, ""
+}
+
+// Record adds a finished hand to the boards. Cancelled hands are left out.
+func (b *LeaderBook) Record(hist *HandHistory) {
+	if slices.ContainsFunc(hist.Events, func(e GameEvent) bool { return e.Type == EventHandCancelled }) {
+		return
+	}
+	won := make(map[string]int)
+	for _, e := range hist.Events {
+		if e.Type == EventPotAwarded {
+			for _, payout := range e.Payouts {
+				won[payout.PlayerID] += payout.Amount
+			}
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, period := range leaderboardPeriods {
+		p := b.periods[period]
+		_, key := periodStart(period, hist.EndedAt)
+		if key < p.key {
+			continue // from a day or week already over
+		}
+		if key > p.key {
+			p.key, p.totals = key, make(map[string]*leaderTotals)
+		}
+		for _, hp := range hist.Players {
+			if hp.AccountID == "" {
+				continue
+			}
+			t := p.totals[hp.AccountID]
+			if t == nil {
+				t = &leaderTotals{}
+				p.totals[hp.AccountID] = t
+			}
+			t.name = hp.Name
+			t.net += hp.EndingChips - hp.StartingChips
+			t.biggestPot = max(t.biggestPot, won[hp.ID])
+			t.hands++
+		}
+	}
+}
+
+// Board returns the top limit accounts of a board, or an error naming the
+// valid choices.
+func (b *LeaderBook) Board(period, board string, limit int) (Leaderboard, error) {
+	if !slices.Contains(leaderboardPeriods, period) {
+		return Leaderboard{}, fmt.Errorf("period must be one of %s", strings.Join(leaderboardPeriods, ", "))
+	}
+	if !slices.Contains(leaderboardKinds, board) {
+		return Leaderboard{}, fmt.Errorf("board must be one of %s", strings.Join(leaderboardKinds, ", "))
+	}
+	if limit < 1 || limit > maxLeaderboardSize {
+		return Leaderboard{}, fmt.Errorf("limit must be between 1 and %d", maxLeaderboardSize)
+	}
+	since, key := periodStart(period, time.Now())
+	lb := Leaderboard{Period: period, Board: board, Since: since, Entries: []LeaderboardEntry{}}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p := b.periods[period]
+	if p.key != key {
+		return lb, nil // nothing played yet this period
+	}
+	for id, t := range p.totals {
+		lb.Entries = append(lb.Entries, LeaderboardEntry{AccountID: id, Name: t.name, Value: t.value(board)})
+	}
+	slices.SortFunc(lb.Entries, func(x, y LeaderboardEntry) int {
+		if x.Value != y.Value {
+			return y.Value - x.Value
+		}
+		return strings.Compare(x.Name, y.Name)
+	})
+	lb.Entries = lb.Entries[:min(limit, len(lb.Entries))]
+	for i := range lb.Entries {
+		lb.Entries[i].Rank = i + 1
+	}
+	return lb, nil
+}
+
+// handleGetLeaderboard answers get_leaderboard with a leaderboard message.
+// It needs no seat, so lobby screens can ask from any table's connection.
+func (c *Client) handleGetLeaderboard(msg Message) error {
+	req := struct {
+		Period string `json:"period"`
+		Board  string `json:"board"`
+		Limit  int    `json:"limit"`
+	}{Period: "all_time", Board: "net", Limit: defaultLeaderboardSize}
+	if err := json.Unmarshal(msg.Payload, &req); err != nil {
+		return protocolErrorf(CodeInvalidMessage, "invalid get_leaderboard payload")
+	}
+	lb, err := c.hub.leaders.Board(req.Period, req.Board, req.Limit)
+	if err != nil {
+		return protocolErrorf(CodeInvalidMessage, "%v", err)
+	}
+	c.reply(msg, "leaderboard", lb)
+	return nil
+}
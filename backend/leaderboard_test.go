@@ -0,0 +1,144 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPeriodStart(t *testing.T) {
+	utc := func(s string) time.Time {
+		at, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return at
+	}
+	tests := []struct {
+		period string
+		at     string
+		start  string
+		key    string
+	}{
+		{"daily", "2026-10-18T00:00:00Z", "2026-10-18T00:00:00Z", "2026-10-18"},
+		{"daily", "2026-10-18T23:59:59Z", "2026-10-18T00:00:00Z", "2026-10-18"},
+		{"daily", "2026-10-19T01:00:00+02:00", "2026-10-18T00:00:00Z", "2026-10-18"},
+		{"weekly", "2026-10-18T12:00:00Z", "2026-10-12T00:00:00Z", "2026-W42"}, // a Sunday ends the week
+		{"weekly", "2026-10-19T00:00:00Z", "2026-10-19T00:00:00Z", "2026-W43"}, // and Monday starts the next
+		{"weekly", "2026-10-21T08:30:00Z", "2026-10-19T00:00:00Z", "2026-W43"},
+		{"weekly", "2027-01-01T12:00:00Z", "2026-12-28T00:00:00Z", "2026-W53"}, // ISO year of the Monday
+		{"weekly", "2027-01-04T00:00:00Z", "2027-01-04T00:00:00Z", "2027-W01"},
+		{"all_time", "2026-10-18T12:00:00Z", "0001-01-01T00:00:00Z", ""},
+	}
+	for _, tt := range tests {
+		start, key := periodStart(tt.period, utc(tt.at))
+		if !start.Equal(utc(tt.start)) || key != tt.key {
+			t.Errorf("%s %s: got %s %q, want %s %q", tt.period, tt.at, start.Format(time.RFC3339), key, tt.start, tt.key)
+		}
+	}
+}
+
+// leaderHand is a finished hand in which each player's net and pot
+// winnings are given by name.
+func leaderHand(at time.Time, net map[string]int, won map[string]int, events ...GameEvent) *HandHistory {
+	hist := &HandHistory{EndedAt: at, Events: events}
+	awarded := GameEvent{Type: EventPotAwarded}
+	for name, n := range net {
+		hist.Players = append(hist.Players, HandPlayer{ID: "p" + name, AccountID: "a" + name, Name: name, StartingChips: 1000, EndingChips: 1000 + n})
+		if won[name] > 0 {
+			awarded.Payouts = append(awarded.Payouts, Payout{PlayerID: "p" + name, Amount: won[name]})
+		}
+	}
+	hist.Events = append(hist.Events, awarded)
+	return hist
+}
+
+func TestLeaderBookPeriods(t *testing.T) {
+	sunday := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	monday := sunday.Add(21 * time.Hour)
+	book := newLeaderBook()
+	book.Record(leaderHand(sunday, map[string]int{"Alice": 100, "Bob": -100}, map[string]int{"Alice": 200}))
+	book.Record(leaderHand(monday, map[string]int{"Alice": -50, "Bob": 50}, map[string]int{"Bob": 100}))
+	// A hand from the day and week that are over, and a cancelled one
+	book.Record(leaderHand(sunday.Add(time.Hour), map[string]int{"Alice": 500, "Bob": -500}, map[string]int{"Alice": 1000}))
+	book.Record(leaderHand(monday, map[string]int{"Alice": 70, "Bob": -70}, map[string]int{"Alice": 140}, GameEvent{Type: EventHandCancelled}))
+
+	tests := []struct {
+		period     string
+		key        string
+		net, pot   map[string]int
+		handsAlice int
+	}{
+		{"daily", "2026-10-19", map[string]int{"aAlice": -50, "aBob": 50}, map[string]int{"aAlice": 0, "aBob": 100}, 1},
+		{"weekly", "2026-W43", map[string]int{"aAlice": -50, "aBob": 50}, map[string]int{"aAlice": 0, "aBob": 100}, 1},
+		{"all_time", "", map[string]int{"aAlice": 550, "aBob": -550}, map[string]int{"aAlice": 1000, "aBob": 100}, 3},
+	}
+	book.mu.Lock()
+	defer book.mu.Unlock()
+	for _, tt := range tests {
+		p := book.periods[tt.period]
+		if p.key != tt.key {
+			t.Errorf("%s: period %q, want %q", tt.period, p.key, tt.key)
+		}
+		for id, net := range tt.net {
+			got := p.totals[id]
+			if got == nil || got.net != net || got.biggestPot != tt.pot[id] {
+				t.Errorf("%s %s: %+v, want net %d and biggest pot %d", tt.period, id, got, net, tt.pot[id])
+			}
+		}
+		if got := p.totals["aAlice"].hands; got != tt.handsAlice {
+			t.Errorf("%s: Alice played %d hands, want %d", tt.period, got, tt.handsAlice)
+		}
+	}
+}
+
+func TestLeaderBookBoard(t *testing.T) {
+	now := time.Now()
+	book := newLeaderBook()
+	book.Record(leaderHand(now.AddDate(0, 0, -8), map[string]int{"Alice": 900, "Bob": -900}, map[string]int{"Alice": 1800}))
+	book.Record(leaderHand(now, map[string]int{"Alice": -40, "Bob": 10, "Carol": 30}, map[string]int{"Carol": 80}))
+	book.Record(leaderHand(now, map[string]int{"Alice": -40, "Bob": 40}, map[string]int{"Bob": 80}))
+
+	tests := []struct {
+		period, board string
+		limit         int
+		want          []LeaderboardEntry
+	}{
+		{"daily", "net", 10, []LeaderboardEntry{{1, "aBob", "Bob", 50}, {2, "aCarol", "Carol", 30}, {3, "aAlice", "Alice", -80}}},
+		{"weekly", "biggest_pot", 2, []LeaderboardEntry{{1, "aBob", "Bob", 80}, {2, "aCarol", "Carol", 80}}},
+		{"all_time", "net", 1, []LeaderboardEntry{{1, "aAlice", "Alice", 820}}},
+		{"all_time", "hands", 10, []LeaderboardEntry{{1, "aAlice", "Alice", 3}, {2, "aBob", "Bob", 3}, {3, "aCarol", "Carol", 1}}},
+	}
+	for _, tt := range tests {
+		lb, err := book.Board(tt.period, tt.board, tt.limit)
+		if err != nil {
+			t.Errorf("%s %s: %v", tt.period, tt.board, err)
+			continue
+		}
+		if len(lb.Entries) != len(tt.want) {
+			t.Errorf("%s %s: %+v, want %+v", tt.period, tt.board, lb.Entries, tt.want)
+			continue
+		}
+		for i := range tt.want {
+			if lb.Entries[i] != tt.want[i] {
+				t.Errorf("%s %s: %+v, want %+v", tt.period, tt.board, lb.Entries, tt.want)
+				break
+			}
+		}
+	}
+
+	// Nothing played yet today
+	empty := newLeaderBook()
+	empty.Record(leaderHand(now.AddDate(0, 0, -1), map[string]int{"Alice": 10}, nil))
+	if lb, err := empty.Board("daily", "net", 10); err != nil || len(lb.Entries) != 0 {
+		t.Errorf("yesterday's hands are on today's board: %+v, %v", lb.Entries, err)
+	}
+
+	for _, bad := range []struct {
+		period, board string
+		limit         int
+	}{{"monthly", "net", 10}, {"daily", "tournaments", 10}, {"daily", "net", 0}, {"daily", "net", maxLeaderboardSize + 1}} {
+		if _, err := book.Board(bad.period, bad.board, bad.limit); err == nil {
+			t.Errorf("%+v was accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+// --- Chip ledger ---
+//
+// Every chip movement that crosses an account's bankroll is recorded twice:
+// taken from one ledger account and given to another. Chips enter through
+// the house, which grants new accounts their bankroll; buying in moves chips
+// from a bankroll to the table, and cashing out moves them back. Chips won
+// and lost between players stay on the table and are not recorded here, as
+// the hand histories cover them. The balances therefore always sum to zero,
+// the house's balance is minus the chips it has put into play, and each
+// table's balance is the chips on that table.
+
+const houseLedger = "house"
+
+const maxLedgerEntries = 1000
+
+// Ledger entry kinds.
+const (
+	LedgerGrant      = "grant"      // a registered or bot account's bankroll
+	LedgerRestake    = "restake"    // a busted bankroll topped up by older versions
+	LedgerBuyIn      = "buy_in"     // bankroll to table
+	LedgerRebuy      = "rebuy"      // bankroll to table, topping up a stack
+	LedgerAddOn      = "add_on"     // bankroll to table, see rebuy.go
+	LedgerCashOut    = "cash_out"   // table to bankroll
+	LedgerAdjustment = "adjustment" // operator change, see admin.go
+	LedgerOpening    = "opening"    // a bankroll that predates the ledger
+	LedgerWriteOff   = "write_off"  // chips stranded on a table by a crash
+	LedgerRake       = "rake"       // table to house, see rake.go
+)
+
+// LedgerEntry moves Amount chips from one ledger account to another.
+type LedgerEntry struct {
+	Seq       int64     `json:"seq"`
+	At        time.Time `json:"at"`
+	Kind      string    `json:"kind"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Amount    int       `json:"amount"` // always positive
+	AccountID string    `json:"accountId,omitempty"`
+	TableID   string    `json:"tableId,omitempty"`
+	Memo      string    `json:"memo,omitempty"`
+}
+
+func bankrollLedger(accountID string) string { return "bankroll:" + accountID }
+func tableLedger(tableID string) string      { return "table:" + tableID }
+
+// postLocked records a movement. A negative amount moves chips the other
+// way.
+func (b *AccountBook) postLocked(e LedgerEntry) {
+	if e.Amount < 0 {
+		e.From, e.To, e.Amount = e.To, e.From, -e.Amount
+	}
+	if e.Amount == 0 {
+		return
+	}
+	b.ledgerSeq++
+	e.Seq = b.ledgerSeq
+	e.At = time.Now()
+	b.balances[e.From] -= e.Amount
+	b.balances[e.To] += e.Amount
+	if err := b.store.AppendLedger(e); err != nil {
+		slog.Error("writing ledger entry failed", "seq", e.Seq, "kind", e.Kind, "err", err)
+	}
+}
+
+// reconcileLocked runs at startup, before any table is open, and brings the
+// ledger in line with the accounts. Chips a crash left at a table go back to
+// their bankrolls, while the stacks in the shutdown checkpoint stay on their
+// tables. Bankrolls from before the ledger get an opening entry, and whatever
+// else is still left on a table was in a pot when the server died and is
+// written off to the house.
+func (b *AccountBook) reconcileLocked(entries []LedgerEntry, checkpoints []TableCheckpoint) {
+	for _, e := range entries {
+		b.balances[e.From] -= e.Amount
+		b.balances[e.To] += e.Amount
+		b.ledgerSeq = max(b.ledgerSeq, e.Seq)
+		if e.Kind == LedgerRake {
+			b.countRakeLocked(e.TableID, e.Amount)
+		}
+	}
+	// Stacks left at a table by a clean shutdown stay there until the player
+	// sits down again, see shutdown.go
+	held := make(map[string]string)
+	heldChips := make(map[string]int)
+	for _, cp := range checkpoints {
+		for _, s := range cp.Seats {
+			held[s.AccountID] = cp.TableID
+		}
+	}
+	for _, a := range b.accounts {
+		if a.Table == "" && a.InPlay == 0 {
+			continue
+		}
+		if a.Table != "" && held[a.ID] == a.Table {
+			heldChips[a.Table] += a.InPlay
+			continue
+		}
+		slog.Warn("returning chips left at a table", "account", a.ID, "table", a.Table, "chips", a.InPlay)
+		b.postLocked(LedgerEntry{Kind: LedgerCashOut, From: tableLedger(a.Table), To: bankrollLedger(a.ID),
+			Amount: a.InPlay, AccountID: a.ID, TableID: a.Table, Memo: "recovered after an unclean shutdown"})
+		a.Bankroll += a.InPlay
+		a.InPlay, a.Table = 0, ""
+		b.saveLocked(a)
+	}
+	for _, a := range b.accounts {
+		if diff := a.Bankroll - b.balances[bankrollLedger(a.ID)]; diff != 0 {
+			slog.Info("ledger opening balance", "account", a.ID, "chips", diff)
+			b.postLocked(LedgerEntry{Kind: LedgerOpening, From: houseLedger, To: bankrollLedger(a.ID),
+				Amount: diff, AccountID: a.ID})
+		}
+	}
+	for name, balance := range b.balances {
+		tableID, ok := strings.CutPrefix(name, "table:")
+		if stranded := balance - heldChips[tableID]; ok && stranded != 0 {
+			slog.Warn("writing off chips stranded on a table", "table", tableID, "chips", stranded)
+			b.postLocked(LedgerEntry{Kind: LedgerWriteOff, From: name, To: houseLedger, Amount: stranded,
+				TableID: tableID, Memo: "in a pot when the server stopped"})
+		}
+	}
+}
+
+// TableBalance is the ledger balance of a table: the chips it should hold.
+func (b *AccountBook) TableBalance(tableID string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.balances[tableLedger(tableID)]
+}
+
+// LedgerReport is the result of checking the ledger against the chips it
+// stands for, returned by GET /api/admin/ledger/check.
+type LedgerReport struct {
+	Balanced   bool     `json:"balanced"`
+	Issued     int      `json:"issued"`    // chips the house has put into play
+	Bankrolls  int      `json:"bankrolls"` // chips off the tables
+	OnTables   int      `json:"onTables"`  // stacks, bets and pots
+	Mismatches []string `json:"mismatches"`
+}
+
+// checkLocked compares every bankroll with its ledger balance.
+func (b *AccountBook) checkLocked(r *LedgerReport) {
+	total := 0
+	for _, balance := range b.balances {
+		total += balance
+	}
+	if total != 0 {
+		r.Mismatches = append(r.Mismatches, fmt.Sprintf("ledger balances sum to %d", total))
+	}
+	r.Issued = -b.balances[houseLedger]
+	for _, a := range b.accounts {
+		r.Bankrolls += a.Bankroll
+		if got := b.balances[bankrollLedger(a.ID)]; got != a.Bankroll {
+			r.Mismatches = append(r.Mismatches, fmt.Sprintf("account %s has a bankroll of %d but a ledger balance of %d", a.ID, a.Bankroll, got))
+		}
+	}
+}
+
+func (l *Lobby) ledgerReport() LedgerReport {
+	r := LedgerReport{Mismatches: []string{}}
+	tables := make(map[string]bool)
+	for _, hub := range l.tableList() {
+		chips, balance := hub.chipsOnTable()
+		tables[hub.config.ID] = true
+		r.OnTables += chips
+		if chips != balance {
+			r.Mismatches = append(r.Mismatches, fmt.Sprintf("table %s holds %d chips but has a ledger balance of %d", hub.config.ID, chips, balance))
+		}
+	}
+	l.accounts.mu.Lock()
+	l.accounts.checkLocked(&r)
+	for name, balance := range l.accounts.balances {
+		if tableID, ok := strings.CutPrefix(name, "table:"); ok && !tables[tableID] && balance != 0 {
+			r.Mismatches = append(r.Mismatches, fmt.Sprintf("closed table %s has a ledger balance of %d", tableID, balance))
+		}
+	}
+	l.accounts.mu.Unlock()
+	sort.Strings(r.Mismatches)
+	r.Balanced = len(r.Mismatches) == 0
+	return r
+}
+
+// chipsOnTable counts the chips at the table, in stacks, bets, the pot and
+// the stacks held since a restart, together with the table's ledger balance at the same moment.
+func (h *Hub) chipsOnTable() (chips, balance int) {
+	h.gameStateMutex.RLock()
+	defer h.gameStateMutex.RUnlock()
+	for _, p := range h.gameState.Players {
+		chips += p.Chips + p.Bet
+	}
+	chips += h.gameState.Pot
+	for _, s := range h.restoredSeats {
+		chips += s.Chips
+	}
+	return chips, h.accounts.TableBalance(h.config.ID)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultTableID = "main"
+
+var errTableExists = errors.New("a table with that id already exists")
+
+// TableConfig describes one table. It is persisted so tables survive a
+// restart with the same settings. StartingChips is the default buy-in, used
+// when a player does not ask for an amount.
+type TableConfig struct {
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	SmallBlind    int       `json:"smallBlind"`
+	BigBlind      int       `json:"bigBlind"`
+	StartingChips int       `json:"startingChips"`
+	MinBuyIn      int       `json:"minBuyIn"`
+	MaxBuyIn      int       `json:"maxBuyIn"`
+	RebuyTo       int       `json:"rebuyTo"`               // stack a rebuy tops up to
+	AddOnChips    int       `json:"addOnChips,omitempty"`  // 0 for no add-on
+	AddOnHands    int       `json:"addOnHands,omitempty"`  // hands after sitting down the add-on is offered for
+	RakePercent   float64   `json:"rakePercent,omitempty"` // of each pot, see rake.go
+	RakeCap       int       `json:"rakeCap,omitempty"`     // most chips raked from a pot, 0 for no cap
+	NoFlopNoDrop  bool      `json:"noFlopNoDrop,omitempty"`
+	HUD           bool      `json:"hud,omitempty"` // show each seat's statistics in game_state
+	MaxSeats      int       `json:"maxSeats"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+const (
+	defaultMaxSeats = 6
+	minSeats        = 2
+	maxSeats        = 10
+
+	// Default buy-in limits, in big blinds
+	defaultMinBuyInBB = 20
+	defaultMaxBuyInBB = 100
+	defaultAddOnHands = 10
+)
+
+func defaultTableConfig() TableConfig {
+	return TableConfig{
+		ID:            defaultTableID,
+		Name:          "Main Table",
+		SmallBlind:    SmallBlindAmt,
+		BigBlind:      BigBlindAmt,
+		StartingChips: StartingChips,
+		MinBuyIn:      BigBlindAmt * defaultMinBuyInBB,
+		MaxBuyIn:      BigBlindAmt * defaultMaxBuyInBB,
+		RebuyTo:       StartingChips,
+		MaxSeats:      defaultMaxSeats,
+		CreatedAt:     time.Now(),
+	}
+}
+
+// setBuyInDefaults fills in buy-in limits that were left out, so that they
+// take in the default buy-in, and the rebuy and add-on settings.
+func (cfg *TableConfig) setBuyInDefaults() {
+	if cfg.MinBuyIn == 0 {
+		cfg.MinBuyIn = min(cfg.BigBlind*defaultMinBuyInBB, cfg.StartingChips)
+	}
+	if cfg.MaxBuyIn == 0 {
+		cfg.MaxBuyIn = max(cfg.BigBlind*defaultMaxBuyInBB, cfg.StartingChips)
+	}
+	if cfg.RebuyTo == 0 {
+		cfg.RebuyTo = cfg.StartingChips
+	}
+	if cfg.AddOnChips > 0 && cfg.AddOnHands == 0 {
+		cfg.AddOnHands = defaultAddOnHands
+	}
+}
+
+// Lobby owns every table on the server and the state they share.
+type Lobby struct {
+	mu       sync.RWMutex
+	tables   map[string]*Hub
+	store    Store
+	accounts *AccountBook
+	stats    *StatsBook
+	leaders  *LeaderBook
+	sessions sessionSigner
+	deckSpec string // dev mode deck source of every table, see deck.go
+}
+
+// newLobby loads accounts and tables from the store and starts a Hub for
+// every table. A fresh store gets the default table.
+func newLobby(store Store) (*Lobby, error) {
+	accounts, err := newAccountBook(store)
+	if err != nil {
+		return nil, err
+	}
+	stats, leaders := newStatsBook(), newLeaderBook()
+	if err := replayHandHistories(store, accounts, stats.Record, leaders.Record); err != nil {
+		return nil, err
+	}
+	key, err := store.SessionKey()
+	if err != nil {
+		return nil, err
+	}
+	l := &Lobby{
+		tables:   make(map[string]*Hub),
+		store:    store,
+		accounts: accounts,
+		stats:    stats,
+		leaders:  leaders,
+		sessions: sessionSigner{key: key},
+	}
+	configs, err := store.LoadTables()
+	if err != nil {
+		return nil, err
+	}
+	if len(configs) == 0 {
+		cfg := defaultTableConfig()
+		if err := store.SaveTable(cfg); err != nil {
+			return nil, err
+		}
+		configs = append(configs, cfg)
+	}
+	for _, cfg := range configs {
+		if _, err := l.startTable(cfg); err != nil {
+			return nil, err
+		}
+	}
+	if err := l.restoreCheckpoint(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Lobby) startTable(cfg TableConfig) (*Hub, error) {
+	if cfg.MaxSeats == 0 {
+		cfg.MaxSeats = defaultMaxSeats // saved before tables had a seat limit
+	}
+	cfg.setBuyInDefaults() // saved before tables had buy-in limits
+	hub := newHub(cfg, l.store, l.accounts)
+	hands, err := l.store.LoadHandHistories(cfg.ID, maxHandHistories)
+	if err != nil {
+		return nil, err
+	}
+	hub.handHistories = hands
+	hub.stats, hub.leaders = l.stats, l.leaders
+
+	l.mu.Lock()
+	if _, exists := l.tables[cfg.ID]; exists {
+		l.mu.Unlock()
+		return nil, errTableExists
+	}
+	l.tables[cfg.ID] = hub
+	deckSpec := l.deckSpec
+	l.mu.Unlock()
+	if deckSpec != "" {
+		src, _ := parseDeckSource(deckSpec) // checked by useDeckSource
+		hub.setDeckSource(src)
+	}
+
+	go hub.run()
+	hub.log.Info("table started", "name", cfg.Name, "hands", len(hands))
+	return hub, nil
+}
+
+// createTable starts a new table and saves its configuration.
+func (l *Lobby) createTable(cfg TableConfig) (*Hub, error) {
+	hub, err := l.startTable(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := l.store.SaveTable(cfg); err != nil {
+		return nil, err
+	}
+	return hub, nil
+}
+
+func (l *Lobby) table(id string) *Hub {
+	if id == "" {
+		id = defaultTableID
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.tables[id]
+}
+
+// tableList returns all tables ordered by ID.
+func (l *Lobby) tableList() []*Hub {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	hubs := make([]*Hub, 0, len(l.tables))
+	for _, hub := range l.tables {
+		hubs = append(hubs, hub)
+	}
+	sort.Slice(hubs, func(i, j int) bool { return hubs[i].config.ID < hubs[j].config.ID })
+	return hubs
+}
+
+// handHistory looks a hand up in the tables' recent histories first and
+// then in the store.
+func (l *Lobby) handHistory(handID string) *HandHistory {
+	for _, hub := range l.tableList() {
+		if hist := hub.handHistory(handID); hist != nil {
+			return hist
+		}
+	}
+	hist, err := l.store.HandHistory(handID)
+	if err != nil {
+		if err != errNotFound {
+			slog.Error("loading hand failed", "hand", handID, "err", err)
+		}
+		return nil
+	}
+	return hist
+}
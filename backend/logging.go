@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// --- Logging ---
+//
+// The server logs structured records with log/slog. Records about a table
+// carry a "table" attribute, and those written while a hand is running also
+// carry "hand", so one hand can be followed with a single filter. Turn by
+// turn detail is logged at debug level, and so are hole cards: they never
+// appear at the default info level.
+
+// setupLogging installs the default logger. level is debug, info, warn or
+// error; format is text or json.
+func setupLogging(w io.Writer, level, format string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("invalid log format %q, want text or json", format)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// logUnsafe returns the table's logger, with the hand ID while a hand is
+// running.
+func (h *Hub) logUnsafe() *slog.Logger {
+	if h.gameState.GameStarted {
+		return h.log.With("hand", h.gameState.HandID)
+	}
+	return h.log
+}
+
+// fatal logs an error and exits, for failures during startup.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
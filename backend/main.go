@@ -1,14 +1,19 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
-	"math/rand"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
 	"slices"
 	"sort"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/google/uuid" // <-- THÊM DÒNG NÀY
@@ -16,12 +21,16 @@ import (
 )
 
 // --- Constants ---
+// Defaults for new tables, see TableConfig
 const (
 	StartingChips = 1000
 	SmallBlindAmt = 10
 	BigBlindAmt   = 20
 )
 
+// StartingBankroll is what the house grants a registered or bot account, see ledger.go
+const StartingBankroll = 10000
+
 // Global deck pool for reusing card objects
 var (
 	deckPool = sync.Pool{
@@ -41,19 +50,83 @@ var (
 
 // --- Structs cho WebSocket ---
 type Client struct {
-	ID   string
-	hub  *Hub
-	conn *websocket.Conn
-	send chan []byte
+	ID    string
+	hub   *Hub
+	conn  *websocket.Conn
+	codec Codec
+	send  chan []byte
+	// synced is set once the client holds the latest state snapshot and can
+	// follow deltas. Guarded by the hub's gameStateMutex.
+	synced bool
+	view   string // state view key the client is streamed
+	// closeMsg is the close frame payload, set before send is closed.
+	closeMsg []byte
+	// Set by the hello/welcome handshake, see protocol.go. Only touched by
+	// readPump.
+	protocolVersion int
+	registered      bool
+	// The logged-in account, checked in serveWs; empty for guests.
+	accountID   string
+	accountName string
 }
 
 type Hub struct {
+	config         TableConfig
+	log            *slog.Logger // see logging.go
+	store          Store
+	accounts       *AccountBook
 	clients        map[string]*Client
-	register       chan *Client
 	unregister     chan *Client
 	playerReady    map[string]bool
+	pendingJoins   map[string]pendingJoin // player ID -> join waiting for the hand to end
 	gameState      GameState
 	gameStateMutex sync.RWMutex
+
+	// Provably fair shuffle state, see fairness.go
+	serverSeed    []byte
+	nextHandID    string
+	clientSeeds   map[string]string
+	revealedHands map[string]FairnessReveal
+	revealedOrder []string
+	deckSource    DeckSource
+
+	// Hand histories, see handhistory.go
+	currentHand   *HandHistory
+	handHistories []*HandHistory
+	stats         *StatsBook  // shared by every table, see stats.go
+	leaders       *LeaderBook // see leaderboard.go
+
+	// Event log, see events.go
+	eventSeq   int
+	eventLog   []GameEvent
+	handEvents []GameEvent
+
+	// State stream per view, see statestream.go
+	views map[string]*stateView
+
+	// Read-only Server-Sent Events streams, see sse.go
+	watchers map[*sseWatcher]struct{}
+
+	// Open seats at the last broadcast, see spectators.go
+	lastOpenSeats int
+
+	// Spectators queueing for a seat, see waitlist.go
+	waitlist        []*waitEntry
+	waitlistChanged bool
+
+	// Rebuys and add-ons, see rebuy.go
+	busted     map[string]time.Time // player ID -> when they ran out of chips
+	handsDealt map[string]int       // player ID -> hands dealt since sitting down
+	addOnTaken map[string]bool
+
+	// Bots, see bots.go
+	bots         map[string]*bot // player ID -> bot
+	botsReadying bool
+
+	// Shutdown and restart, see shutdown.go
+	draining      bool           // no new hands
+	closed        bool           // checkpointed, everyone disconnected
+	restoredSeats map[string]SeatCheckpoint // account ID -> seat and stack held since the restart
 }
 
 // --- Structs cho Game ---
@@ -62,34 +135,46 @@ type Card struct {
 	Rank string `json:"rank"`
 }
 
+func (c Card) String() string { return c.Rank + c.Suit }
+
 type Player struct {
-	ID          string `json:"id"`
-	Name        string `json:"name"`
-	IsConnected bool   `json:"isConnected"`
-	Hand        []Card `json:"hand"`
-	Chips       int    `json:"chips"`
-	Bet         int    `json:"bet"`
-	IsInHand    bool   `json:"isInHand"`
-	IsAllIn     bool   `json:"isAllIn"`
-	HasActed    bool   `json:"hasActed"`
+	ID          string    `json:"id"`
+	AccountID   string    `json:"accountId,omitempty"`
+	Name        string    `json:"name"`
+	Seat        int       `json:"seat"`
+	IsConnected bool      `json:"isConnected"`
+	Hand        []Card    `json:"hand"`
+	Chips       int       `json:"chips"`
+	Bet         int       `json:"bet"`
+	IsInHand    bool      `json:"isInHand"`
+	IsAllIn     bool      `json:"isAllIn"`
+	HasActed    bool      `json:"hasActed"`
+	HUD         *HUDStats `json:"hud,omitempty"` // see stats.go
 }
 
 type GameState struct {
-	Players          map[string]Player `json:"players"`
-	PlayerReady      map[string]bool   `json:"playerReady"`
-	GameStarted      bool              `json:"gameStarted"`
-	Deck             []Card            `json:"-"`
-	Pot              int               `json:"pot"`
-	SidePots         []SidePot         `json:"sidePots"`
-	PlayerOrder      []string          `json:"playerOrder"`
-	DealerIndex      int               `json:"dealerIndex"`
-	CurrentTurnIndex int               `json:"currentTurnIndex"`
-	GamePhase        string            `json:"gamePhase"`
-	LastBet          int               `json:"lastBet"`
-	MinRaise         int               `json:"minRaise"`
-	CommunityCards   []Card            `json:"communityCards"`
-	WinningHandDesc  string            `json:"winningHandDesc,omitempty"`
-	ChatMessages     []ChatMessage     `json:"chatMessages"`
+	Players          map[string]Player    `json:"players"`
+	PlayerReady      map[string]bool      `json:"playerReady"`
+	GameStarted      bool                 `json:"gameStarted"`
+	Deck             []Card               `json:"-"`
+	Pot              int                  `json:"pot"`
+	SidePots         []SidePot            `json:"sidePots"`
+	PlayerOrder      []string             `json:"playerOrder"`
+	DealerIndex      int                  `json:"dealerIndex"`
+	ButtonSeat       int                  `json:"buttonSeat"`
+	MaxSeats         int                  `json:"maxSeats"`
+	WaitingList      int                  `json:"waitingList"`
+	Paused           bool                 `json:"paused"`
+	CurrentTurnIndex int                  `json:"currentTurnIndex"`
+	GamePhase        string               `json:"gamePhase"`
+	LastBet          int                  `json:"lastBet"`
+	MinRaise         int                  `json:"minRaise"`
+	CommunityCards   []Card               `json:"communityCards"`
+	WinningHandDesc  string               `json:"winningHandDesc,omitempty"`
+	ChatMessages     []ChatMessage        `json:"chatMessages"`
+	Spectators       map[string]Spectator `json:"spectators"`
+	HandID           string               `json:"handId,omitempty"`
+	Fairness         FairnessInfo         `json:"fairness"`
 	actionToPlayerID string
 }
 
@@ -105,89 +190,92 @@ type ChatMessage struct {
 }
 
 type Message struct {
-	Type    string          `json:"type"`
-	Payload json.RawMessage `json:"payload"`
+	Type      string          `json:"type"`
+	RequestID string          `json:"requestId,omitempty"` // see protocol.go
+	Seq       uint64          `json:"seq,omitempty"`       // state stream sequence, see statestream.go
+	Payload   json.RawMessage `json:"payload"`
 }
 
-func newHub() *Hub {
-	return &Hub{
-		clients:     make(map[string]*Client),
-		register:    make(chan *Client),
-		unregister:  make(chan *Client),
-		playerReady: make(map[string]bool),
+func newHub(cfg TableConfig, store Store, accounts *AccountBook) *Hub {
+	h := &Hub{
+		config:        cfg,
+		log:           slog.With("table", cfg.ID),
+		store:         store,
+		accounts:      accounts,
+		clients:       make(map[string]*Client),
+		unregister:    make(chan *Client),
+		playerReady:   make(map[string]bool),
+		pendingJoins:  make(map[string]pendingJoin),
+		busted:        make(map[string]time.Time),
+		handsDealt:    make(map[string]int),
+		addOnTaken:    make(map[string]bool),
+		bots:          make(map[string]*bot),
+		revealedHands: make(map[string]FairnessReveal),
+		deckSource:    randomDeckSource{},
+		watchers:      make(map[*sseWatcher]struct{}),
+		views:         make(map[string]*stateView),
+		lastOpenSeats: cfg.MaxSeats,
 		gameState: GameState{
 			Players:        make(map[string]Player),
 			PlayerReady:    make(map[string]bool),
 			DealerIndex:    -1,
+			MaxSeats:       cfg.MaxSeats,
 			GamePhase:      "waiting",
 			CommunityCards: []Card{},
 			SidePots:       []SidePot{},
 			ChatMessages:   []ChatMessage{},
-			MinRaise:       BigBlindAmt,
+			Spectators:     make(map[string]Spectator),
+			MinRaise:       cfg.BigBlind,
+			Fairness:       FairnessInfo{DeckSource: "random"},
 		},
 	}
+	h.prepareNextHandUnsafe()
+	return h
 }
 
 func (h *Hub) run() {
-	for {
-		select {
-		case client := <-h.register:
-			h.clients[client.ID] = client
-			
-			// Send player ID to the client
-			playerIDMsg := Message{
-				Type: "player_id",
-				Payload: json.RawMessage(fmt.Sprintf(`{"id":"%s"}`, client.ID)),
-			}
-			if msgBytes, err := json.Marshal(playerIDMsg); err == nil {
-				select {
-				case client.send <- msgBytes:
-				default:
-					close(client.send)
-					delete(h.clients, client.ID)
-				}
-			}
-			
-			h.addOrUpdatePlayer(client.ID, true)
-		case client := <-h.unregister:
-			if _, ok := h.clients[client.ID]; ok {
-				delete(h.clients, client.ID)
-				close(client.send)
-				h.addOrUpdatePlayer(client.ID, false)
-			}
+	for client := range h.unregister {
+		h.gameStateMutex.Lock()
+		if _, ok := h.clients[client.ID]; ok {
+			delete(h.clients, client.ID)
+			close(client.send)
+			h.handleDisconnectUnsafe(client.ID)
 		}
+		h.gameStateMutex.Unlock()
 	}
 }
 
-func (h *Hub) addOrUpdatePlayer(playerID string, isConnected bool) {
-	h.addOrUpdatePlayerWithName(playerID, isConnected, "")
-}
-
-func (h *Hub) addOrUpdatePlayerWithName(playerID string, isConnected bool, name string) {
+// registerClient adds a client that completed the handshake as a spectator.
+func (h *Hub) registerClient(client *Client) {
 	h.gameStateMutex.Lock()
 	defer h.gameStateMutex.Unlock()
+	h.clients[client.ID] = client
+	h.addSpectatorUnsafe(client.ID, client.accountName)
+	h.broadcastGameStateUnsafe()
+}
+
+func (h *Hub) handleDisconnectUnsafe(playerID string) {
+	if _, watching := h.gameState.Spectators[playerID]; watching {
+		delete(h.gameState.Spectators, playerID)
+		delete(h.pendingJoins, playerID)
+		h.leaveWaitlistUnsafe(playerID)
+		h.broadcastGameStateUnsafe()
+		return
+	}
 	player, exists := h.gameState.Players[playerID]
 	if !exists {
-		playerName := name
-		if playerName == "" {
-			playerName = "Player-" + playerID[:8] // Default name
-		}
-		player = Player{
-			ID:          playerID, 
-			Name:        playerName,
-			Hand:        []Card{}, 
-			Chips:       StartingChips,
-			IsAllIn:     false,
-			HasActed:    false,
-		}
-		h.playerReady[playerID] = false
+		return
 	}
-	if name != "" && name != player.Name {
-		player.Name = name
+	if player.IsConnected {
+		h.emitEventUnsafe(GameEvent{Type: EventPlayerConnection, PlayerID: playerID, Connected: false})
 	}
-	player.IsConnected = isConnected
+	player.IsConnected = false
 	h.gameState.Players[playerID] = player
-	if !isConnected && h.gameState.GameStarted && player.IsInHand {
+	if !(h.gameState.GameStarted && slices.Contains(h.gameState.PlayerOrder, playerID)) {
+		// The account keeps the chips; the player sits again by joining with the same name
+		h.removePlayerUnsafe(playerID)
+		h.broadcastGameStateUnsafe()
+	} else if h.gameState.GameStarted && player.IsInHand {
 		player.IsInHand = false
 		h.gameState.Players[playerID] = player
 		h.advanceTurnUnsafe()
@@ -196,14 +284,26 @@ func (h *Hub) addOrUpdatePlayerWithName(playerID string, isConnected bool, name
 	}
 }
 
-func (h *Hub) handlePlayerReady(playerID string, isReady bool) {
+func (h *Hub) handlePlayerReady(playerID string, isReady bool) error {
 	h.gameStateMutex.Lock()
 	defer h.gameStateMutex.Unlock()
-	if _, ok := h.playerReady[playerID]; ok {
-		h.playerReady[playerID] = isReady
+	if _, ok := h.playerReady[playerID]; !ok {
+		return protocolErrorf(CodeNotSeated, "not seated at this table")
 	}
-	if h.gameState.GameStarted {
-		h.broadcastGameStateUnsafe()
+	if isReady && h.draining {
+		return protocolErrorf(CodeShuttingDown, "the server is restarting; no new hands are dealt")
+	}
+	h.playerReady[playerID] = isReady
+	h.startIfReadyUnsafe()
+	h.broadcastGameStateUnsafe()
+	return nil
+}
+
+// startIfReadyUnsafe deals a hand once at least two players with chips are
+// connected and all of them are ready, unless the table is paused or
+// shutting down.
+func (h *Hub) startIfReadyUnsafe() {
+	if h.gameState.GameStarted || h.gameState.Paused || h.draining || h.closed {
 		return
 	}
 	eligiblePlayers := make(map[string]Player)
@@ -213,64 +313,87 @@ func (h *Hub) handlePlayerReady(playerID string, isReady bool) {
 		}
 	}
 	if len(eligiblePlayers) < 2 {
-		h.broadcastGameStateUnsafe()
 		return
 	}
-	allEligiblePlayersReady := true
 	for id := range eligiblePlayers {
 		if !h.playerReady[id] {
-			allEligiblePlayersReady = false
-			break
+			return
 		}
 	}
-	if allEligiblePlayersReady {
-		h.startGameUnsafe(eligiblePlayers)
-	}
-	h.broadcastGameStateUnsafe()
+	h.startGameUnsafe(eligiblePlayers)
 }
 
-func (h *Hub) handleChatMessage(playerID string, payloadBytes json.RawMessage) {
+func (h *Hub) handleChatMessage(playerID string, payloadBytes json.RawMessage) error {
 	var payload ChatPayload
 	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
-		log.Printf("Error unmarshaling chat payload: %v", err)
-		return
+		return protocolErrorf(CodeInvalidMessage, "invalid chat_message payload")
 	}
 	
 	h.gameStateMutex.Lock()
 	defer h.gameStateMutex.Unlock()
 	
-	if player, exists := h.gameState.Players[playerID]; exists {
-		chatMessage := ChatMessage{
-			PlayerID:  playerID,
-			Message:   payload.Message,
-			Timestamp: time.Now(),
-		}
-		h.gameState.ChatMessages = append(h.gameState.ChatMessages, chatMessage)
-		
-		// Keep only last 50 messages
-		if len(h.gameState.ChatMessages) > 50 {
-			h.gameState.ChatMessages = h.gameState.ChatMessages[len(h.gameState.ChatMessages)-50:]
-		}
-		
-		log.Printf("Chat from %s: %s", player.Name, payload.Message)
-		h.broadcastGameStateUnsafe()
+	name := h.gameState.Spectators[playerID].Name
+	if player, seated := h.gameState.Players[playerID]; seated {
+		name = player.Name
+	} else if name == "" {
+		return protocolErrorf(CodeNotSeated, "not at this table")
 	}
+	chatMessage := ChatMessage{
+		PlayerID:  playerID,
+		Message:   payload.Message,
+		Timestamp: time.Now(),
+	}
+	h.gameState.ChatMessages = append(h.gameState.ChatMessages, chatMessage)
+	
+	// Keep only last 50 messages
+	if len(h.gameState.ChatMessages) > 50 {
+		h.gameState.ChatMessages = h.gameState.ChatMessages[len(h.gameState.ChatMessages)-50:]
+	}
+	
+	h.logUnsafe().Debug("chat message", "player", name, "message", payload.Message)
+	h.broadcastGameStateUnsafe()
+	return nil
 }
 
-func (h *Hub) handlePlayerJoin(playerID string, payloadBytes json.RawMessage) {
+// handlePlayerJoin seats a spectator as the account called name, or switches
+// a seated player to that account.
+func (h *Hub) handlePlayerJoin(playerID string, payloadBytes json.RawMessage) error {
 	var payload PlayerJoinPayload
 	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
-		log.Printf("Error unmarshaling player join payload: %v", err)
-		return
+		return protocolErrorf(CodeInvalidMessage, "invalid player_join payload")
 	}
 	
-	h.addOrUpdatePlayerWithName(playerID, true, payload.Name)
+	h.gameStateMutex.Lock()
+	defer h.gameStateMutex.Unlock()
+	name, err := h.seatNameUnsafe(playerID, payload.Name)
+	if err != nil {
+		return err
+	}
+	player, seated := h.gameState.Players[playerID]
+	if !seated {
+		if err := h.seatSpectatorUnsafe(playerID, name, 0, payload.BuyIn); err != nil {
+			h.logUnsafe().Info("spectator cannot sit", "client", playerID, "name", name, "err", err)
+			return err
+		}
+		h.broadcastGameStateUnsafe()
+		return nil
+	}
+	if err := h.bindAccountUnsafe(&player, name, payload.BuyIn); err != nil {
+		h.logUnsafe().Info("player cannot join", "player", player.Name, "name", name, "err", err)
+		return err
+	}
+	if h.gameState.Players[playerID].Name != player.Name {
+		h.emitEventUnsafe(GameEvent{Type: EventPlayerRenamed, PlayerID: playerID, Name: player.Name})
+	}
+	h.gameState.Players[playerID] = player
+	h.broadcastGameStateUnsafe()
+	return nil
 }
 
 func (h *Hub) startGameUnsafe(activePlayers map[string]Player) {
-	log.Println("--- GAME STARTING ---")
 	h.gameState.GameStarted = true
 	h.gameState.GamePhase = "pre-flop"
+	h.gameState.HandID = h.nextHandID
 	h.gameState.Pot = 0
 	h.gameState.CommunityCards = []Card{}
 	h.gameState.WinningHandDesc = ""
@@ -282,48 +405,62 @@ func (h *Hub) startGameUnsafe(activePlayers map[string]Player) {
 		p.HasActed = false
 		h.gameState.Players[id] = p
 		h.gameState.PlayerOrder = append(h.gameState.PlayerOrder, id)
+		h.handsDealt[id]++
 	}
+	slices.SortFunc(h.gameState.PlayerOrder, func(a, b string) int {
+		return h.gameState.Players[a].Seat - h.gameState.Players[b].Seat
+	})
 	for id := range h.playerReady {
 		h.playerReady[id] = false
 	}
 	
 	h.addSystemChatMessage(fmt.Sprintf("Game started with %d players!", len(activePlayers)))
 	
-	h.gameState.DealerIndex = (h.gameState.DealerIndex + 1) % len(h.gameState.PlayerOrder)
+	h.gameState.DealerIndex = h.nextButtonIndexUnsafe()
+	h.gameState.ButtonSeat = h.gameState.Players[h.gameState.PlayerOrder[h.gameState.DealerIndex]].Seat
+	h.logUnsafe().Info("hand started", "players", len(h.gameState.PlayerOrder), "button", h.gameState.ButtonSeat)
 	
-	// Use deck pool for better memory management
-	deck := deckPool.Get().([]Card)
-	// Create a copy to avoid modifying the pooled deck
-	gameDeck := make([]Card, len(deck))
-	copy(gameDeck, deck)
-	deckPool.Put(deck) // Return to pool immediately
-	
-	rand.Shuffle(len(gameDeck), func(i, j int) { gameDeck[i], gameDeck[j] = gameDeck[j], gameDeck[i] })
-	h.gameState.Deck = gameDeck
+	h.emitHandStartedUnsafe()
+	h.gameState.Deck = h.nextDeckUnsafe()
 	for _, id := range h.gameState.PlayerOrder {
 		if len(h.gameState.Deck) > 1 {
 			p := h.gameState.Players[id]
 			p.Hand = append(p.Hand, h.gameState.Deck[0], h.gameState.Deck[1])
 			h.gameState.Deck = h.gameState.Deck[2:]
 			h.gameState.Players[id] = p
+			h.emitEventUnsafe(GameEvent{Type: EventHoleCardsDealt, PlayerID: id, Cards: slices.Clone(p.Hand)})
+			h.logUnsafe().Debug("hole cards dealt", "player", p.Name, "cards", p.Hand)
 		}
 	}
+	h.beginHandHistoryUnsafe()
 	numPlayers := len(h.gameState.PlayerOrder)
 	sbIndex := (h.gameState.DealerIndex + 1) % numPlayers
 	bbIndex := (h.gameState.DealerIndex + 2) % numPlayers
+	if numPlayers == 2 {
+		// Heads-up the button posts the small blind and acts first before the flop
+		sbIndex, bbIndex = h.gameState.DealerIndex, (h.gameState.DealerIndex+1)%numPlayers
+	}
 
-	h.handleBetUnsafe(h.gameState.PlayerOrder[sbIndex], SmallBlindAmt)
-	h.handleBetUnsafe(h.gameState.PlayerOrder[bbIndex], BigBlindAmt)
+	sbPosted := h.handleBetUnsafe(h.gameState.PlayerOrder[sbIndex], h.config.SmallBlind)
+	h.recordActionUnsafe(h.gameState.PlayerOrder[sbIndex], "small_blind", sbPosted)
+	h.emitEventUnsafe(GameEvent{Type: EventBlindPosted, PlayerID: h.gameState.PlayerOrder[sbIndex], Amount: sbPosted, LastBet: h.config.SmallBlind})
+	bbPosted := h.handleBetUnsafe(h.gameState.PlayerOrder[bbIndex], h.config.BigBlind)
+	h.recordActionUnsafe(h.gameState.PlayerOrder[bbIndex], "big_blind", bbPosted)
+	h.emitEventUnsafe(GameEvent{Type: EventBlindPosted, PlayerID: h.gameState.PlayerOrder[bbIndex], Amount: bbPosted, LastBet: h.config.BigBlind})
 
-	h.gameState.LastBet = BigBlindAmt
+	h.gameState.LastBet = h.config.BigBlind
 	h.gameState.CurrentTurnIndex = (bbIndex + 1) % len(h.gameState.PlayerOrder)
 	h.gameState.actionToPlayerID = h.gameState.PlayerOrder[bbIndex]
 }
 
 func (h *Hub) endGameUnsafe(reason string) {
-	log.Printf("--- GAME ENDED: %s ---", reason)
+	h.logUnsafe().Info("hand ended", "result", reason)
+	metrics.handsTotal.inc(h.config.ID)
+	h.verifyEventLogUnsafe("before reset")
+	h.finishHandHistoryUnsafe(reason)
 	h.gameState.GameStarted = false
 	h.gameState.GamePhase = "waiting"
+	h.revealHandSeedUnsafe()
 	
 	// Check for player elimination and reset game state
 	eliminatedPlayers := []string{}
@@ -333,25 +470,65 @@ func (h *Hub) endGameUnsafe(reason string) {
 		p.IsAllIn = false
 		p.HasActed = false
 		
-		// Eliminate players with 0 chips
-		if p.Chips <= 0 {
+		// Players with 0 chips may rebuy if they are still here; the rest are eliminated
+		if p.Chips <= 0 && p.IsConnected {
+			h.bustUnsafe(id, p.Name)
+		} else if p.Chips <= 0 {
 			eliminatedPlayers = append(eliminatedPlayers, id)
-			log.Printf("Player %s eliminated (no chips remaining)", p.Name)
+			h.logUnsafe().Info("player eliminated", "player", p.Name)
 		}
 		
 		h.gameState.Players[id] = p
 	}
 	
-	// Remove eliminated players
+	// Remove eliminated players; those still connected keep watching
 	for _, id := range eliminatedPlayers {
+		p := h.gameState.Players[id]
+		if p.AccountID != "" {
+			h.accounts.Release(p.AccountID, p.Chips)
+		}
 		delete(h.gameState.Players, id)
 		delete(h.playerReady, id)
+		h.dropBotUnsafe(id)
+		if _, connected := h.clients[id]; connected {
+			h.addSpectatorUnsafe(id, p.Name)
+		}
+	}
+
+	// Save stacks, and let go of account holders who left during the hand
+	leftPlayers := []string{}
+	for id, p := range h.gameState.Players {
+		if p.AccountID == "" {
+			continue
+		}
+		if !p.IsConnected {
+			leftPlayers = append(leftPlayers, id)
+			h.accounts.Release(p.AccountID, p.Chips)
+			delete(h.gameState.Players, id)
+			delete(h.playerReady, id)
+			h.dropBotUnsafe(id)
+		} else {
+			h.accounts.UpdateStack(p.AccountID, p.Chips)
+		}
 	}
 	
 	h.gameState.CommunityCards = []Card{}
 	h.gameState.SidePots = []SidePot{}
 	h.gameState.Pot = 0
-	h.gameState.MinRaise = BigBlindAmt
+	h.gameState.MinRaise = h.config.BigBlind
+
+	h.emitEventUnsafe(GameEvent{
+		Type:       EventHandEnded,
+		HandID:     h.gameState.HandID,
+		Reason:     reason,
+		Eliminated: eliminatedPlayers,
+		Left:       leftPlayers,
+		MinRaise:   h.config.BigBlind,
+	})
+	h.verifyEventLogUnsafe("after reset")
+	h.attachHandEventsUnsafe()
+	h.persistHandHistoryUnsafe()
+	h.seatPendingJoinsUnsafe()
 	
 	if len(eliminatedPlayers) > 0 {
 		h.addSystemChatMessage(fmt.Sprintf("%d player(s) eliminated", len(eliminatedPlayers)))
@@ -373,40 +550,33 @@ func (h *Hub) addSystemChatMessage(message string) {
 }
 
 func (h *Hub) broadcastGameStateUnsafe() {
+	defer metrics.broadcastTime.observeSince(h.config.ID, time.Now())
 	h.gameState.PlayerReady = h.playerReady
-	payload, err := json.Marshal(h.gameState)
-	if err != nil {
-		log.Printf("Error marshaling game state: %v", err)
-		return
-	}
-	msg, err := json.Marshal(Message{Type: "game_state", Payload: json.RawMessage(payload)})
-	if err != nil {
-		log.Printf("Error marshaling message: %v", err)
-		return
-	}
-	// Pre-marshal once and reuse for all clients
-	for _, client := range h.clients {
-		select {
-		case client.send <- msg:
-		default:
-			// Client channel is full, close connection
-			log.Printf("Client %s channel full, closing connection", client.ID)
-			close(client.send)
-			delete(h.clients, client.ID)
-		}
-	}
+	h.updateWaitlistUnsafe()
+	h.recordFrameUnsafe()
+	h.streamStateUnsafe()
+	h.broadcastPublicStateUnsafe()
+	h.notifySeatOpenUnsafe()
+	h.driveBotsUnsafe()
 }
 
-func (h *Hub) handleBetUnsafe(playerID string, amount int) {
-	if player, ok := h.gameState.Players[playerID]; ok {
-		actualAmount := amount
-		if player.Chips < amount {
-			actualAmount = player.Chips
-		}
-		player.Chips -= actualAmount
-		player.Bet += actualAmount
-		h.gameState.Players[playerID] = player
+// handleBetUnsafe moves up to amount chips from the player's stack into
+// their bet and returns how much was actually bet.
+func (h *Hub) handleBetUnsafe(playerID string, amount int) int {
+	player, ok := h.gameState.Players[playerID]
+	if !ok {
+		return 0
 	}
+	actualAmount := betFromStack(&player, amount)
+	h.gameState.Players[playerID] = player
+	return actualAmount
+}
+
+func betFromStack(player *Player, amount int) int {
+	actualAmount := min(amount, player.Chips)
+	player.Chips -= actualAmount
+	player.Bet += actualAmount
+	return actualAmount
 }
 
 type PlayerActionPayload struct {
@@ -419,43 +589,63 @@ type ChatPayload struct {
 }
 
 type PlayerJoinPayload struct {
-	Name string `json:"name"`
+	Name  string `json:"name"`
+	BuyIn int    `json:"buyIn"` // 0 for the table default
 }
 
-func (h *Hub) handlePlayerAction(playerID string, payloadBytes json.RawMessage) {
+// handlePlayerAction applies a betting action. Illegal actions are refused
+// with a *ProtocolError and leave the state untouched.
+func (h *Hub) handlePlayerAction(playerID string, payloadBytes json.RawMessage) error {
+	var payload PlayerActionPayload
+	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+		return protocolErrorf(CodeInvalidMessage, "invalid player_action payload")
+	}
+
 	h.gameStateMutex.Lock()
 	defer h.gameStateMutex.Unlock()
+	return h.playerActionUnsafe(playerID, payload)
+}
 
-	if h.gameState.GamePhase == "showdown" {
-		return
+func (h *Hub) playerActionUnsafe(playerID string, payload PlayerActionPayload) error {
+	if _, seated := h.gameState.Players[playerID]; !seated {
+		return protocolErrorf(CodeNotSeated, "spectators cannot act")
 	}
-
-	if !h.gameState.GameStarted || len(h.gameState.PlayerOrder) == 0 || h.gameState.CurrentTurnIndex < 0 || h.gameState.PlayerOrder[h.gameState.CurrentTurnIndex] != playerID {
-		return
+	if h.gameState.Paused {
+		return protocolErrorf(CodeTablePaused, "the table is paused")
 	}
-	var payload PlayerActionPayload
-	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
-		return
+	if !h.gameState.GameStarted || h.gameState.GamePhase == "showdown" || len(h.gameState.PlayerOrder) == 0 || h.gameState.CurrentTurnIndex < 0 {
+		return protocolErrorf(CodeHandNotRunning, "no betting round is in progress")
+	}
+	if h.gameState.PlayerOrder[h.gameState.CurrentTurnIndex] != playerID {
+		return protocolErrorf(CodeNotYourTurn, "it is not your turn")
 	}
 
 	player := h.gameState.Players[playerID]
 	if player.IsAllIn || !player.IsInHand {
-		return // Player can't act if all-in or folded
+		return protocolErrorf(CodeCannotAct, "you are all-in or have folded") // Player can't act if all-in or folded
+	}
+	switch payload.Action {
+	case "fold", "check", "call", "raise":
+	default:
+		return protocolErrorf(CodeInvalidMessage, "unknown action %q", payload.Action)
 	}
 	
 	player.HasActed = true
 	roundIsOver := false
+	action, moved := payload.Action, 0
 
 	switch payload.Action {
 	case "fold":
 		player.IsInHand = false
-		log.Printf("Player %s folded", player.Name)
+		h.logUnsafe().Debug("player acted", "player", player.Name, "action", "fold")
+		h.recordActionUnsafe(playerID, "fold", 0)
 		
 	case "check":
 		if player.Bet < h.gameState.LastBet {
-			return // Can't check if there's a bet to call
+			return protocolErrorf(CodeCannotCheck, "cannot check facing a bet of %d", h.gameState.LastBet) // Can't check if there's a bet to call
 		}
-		log.Printf("Player %s checked", player.Name)
+		h.logUnsafe().Debug("player acted", "player", player.Name, "action", "check")
+		h.recordActionUnsafe(playerID, "check", 0)
 		if playerID == h.gameState.actionToPlayerID {
 			roundIsOver = true
 		}
@@ -464,17 +654,19 @@ func (h *Hub) handlePlayerAction(playerID string, payloadBytes json.RawMessage)
 		amountToCall := h.gameState.LastBet - player.Bet
 		if amountToCall <= 0 {
 			// No amount to call, treat as check
+			action = "check"
+			h.recordActionUnsafe(playerID, "check", 0)
 			if playerID == h.gameState.actionToPlayerID {
 				roundIsOver = true
 			}
 		} else {
-			actualCall := amountToCall
-			if player.Chips < amountToCall {
-				actualCall = player.Chips // All-in call
-				player.IsAllIn = true
+			if player.Chips <= amountToCall {
+				player.IsAllIn = true // All-in call
 			}
-			h.handleBetUnsafe(playerID, actualCall)
-			log.Printf("Player %s called %d (all-in: %v)", player.Name, actualCall, player.IsAllIn)
+			actualCall := betFromStack(&player, amountToCall)
+			moved = actualCall
+			h.logUnsafe().Debug("player acted", "player", player.Name, "action", "call", "amount", actualCall, "allIn", player.IsAllIn)
+			h.recordActionUnsafe(playerID, "call", actualCall)
 			if playerID == h.gameState.actionToPlayerID {
 				roundIsOver = true
 			}
@@ -487,20 +679,27 @@ func (h *Hub) handlePlayerAction(playerID string, payloadBytes json.RawMessage)
 		// Validate minimum raise
 		minRaise := h.gameState.LastBet + h.gameState.MinRaise
 		if totalBet < minRaise && player.Chips > amountToBet {
-			return // Invalid raise amount
+			return protocolErrorf(CodeInvalidRaise, "raise must be to at least %d", minRaise) // Invalid raise amount
 		}
 		
 		if player.Chips <= amountToBet {
 			// All-in raise
-			actualBet := player.Chips
-			h.handleBetUnsafe(playerID, actualBet)
+			moved = betFromStack(&player, player.Chips)
 			player.IsAllIn = true
-			log.Printf("Player %s raised all-in with %d", player.Name, player.Bet)
+			if player.Bet > h.gameState.LastBet {
+				if raisedBy := player.Bet - h.gameState.LastBet; raisedBy >= h.gameState.MinRaise {
+					h.gameState.MinRaise = raisedBy
+				}
+				h.gameState.LastBet = player.Bet
+			}
+			h.logUnsafe().Debug("player acted", "player", player.Name, "action", "raise", "to", player.Bet, "allIn", true)
+			h.recordActionUnsafe(playerID, "raise", player.Bet)
 		} else {
-			h.handleBetUnsafe(playerID, amountToBet)
+			moved = betFromStack(&player, amountToBet)
 			h.gameState.LastBet = totalBet
 			h.gameState.MinRaise = amountToBet // The raise amount, not the difference
-			log.Printf("Player %s raised to %d", player.Name, totalBet)
+			h.logUnsafe().Debug("player acted", "player", player.Name, "action", "raise", "to", totalBet)
+			h.recordActionUnsafe(playerID, "raise", totalBet)
 		}
 		h.gameState.actionToPlayerID = playerID
 		
@@ -514,6 +713,15 @@ func (h *Hub) handlePlayerAction(playerID string, payloadBytes json.RawMessage)
 	}
 
 	h.gameState.Players[playerID] = player
+	h.emitEventUnsafe(GameEvent{
+		Type:     EventPlayerActed,
+		PlayerID: playerID,
+		Action:   action,
+		Amount:   moved,
+		AllIn:    player.IsAllIn,
+		LastBet:  h.gameState.LastBet,
+		MinRaise: h.gameState.MinRaise,
+	})
 
 	// Check if round should end
 	if roundIsOver || h.shouldEndRound() {
@@ -521,6 +729,7 @@ func (h *Hub) handlePlayerAction(playerID string, payloadBytes json.RawMessage)
 	} else {
 		h.advanceTurnUnsafe()
 	}
+	return nil
 }
 
 // Check if the betting round should end
@@ -574,17 +783,16 @@ func (h *Hub) advanceTurnUnsafe() {
 	}
 
 	startTurnIndex := h.gameState.CurrentTurnIndex
-	log.Printf("Advancing turn from index %d", startTurnIndex)
+	h.logUnsafe().Debug("advancing turn", "from", startTurnIndex)
 	
 	for i := 1; i <= len(h.gameState.PlayerOrder); i++ {
 		h.gameState.CurrentTurnIndex = (startTurnIndex + i) % len(h.gameState.PlayerOrder)
 		nextPlayerID := h.gameState.PlayerOrder[h.gameState.CurrentTurnIndex]
 		if player, ok := h.gameState.Players[nextPlayerID]; ok {
-			log.Printf("Checking player %s: InHand=%v, AllIn=%v, Connected=%v", 
-				player.Name, player.IsInHand, player.IsAllIn, player.IsConnected)
+			h.logUnsafe().Debug("checking player", "player", player.Name, "inHand", player.IsInHand, "allIn", player.IsAllIn, "connected", player.IsConnected)
 			// Skip all-in players and players not in hand
 			if player.IsInHand && !player.IsAllIn && player.IsConnected {
-				log.Printf("Turn advanced to player %s (index %d)", player.Name, h.gameState.CurrentTurnIndex)
+				h.logUnsafe().Debug("turn advanced", "player", player.Name, "index", h.gameState.CurrentTurnIndex)
 				h.broadcastGameStateUnsafe()
 				return
 			}
@@ -592,16 +800,17 @@ func (h *Hub) advanceTurnUnsafe() {
 	}
 
 	// All remaining players are all-in or can't act, go to next phase
-	log.Printf("No valid players found for next turn, advancing to next phase")
+	h.logUnsafe().Debug("no player can act, advancing phase")
 	h.startNextPhaseUnsafe()
 }
 
 func (h *Hub) startNextPhaseUnsafe() {
-	log.Printf("--- Advancing phase from %s ---", h.gameState.GamePhase)
+	h.logUnsafe().Debug("advancing phase", "from", h.gameState.GamePhase)
 	h.gameState.Pot += h.collectBetsUnsafe()
 
 	if h.gameState.GamePhase == "river" {
 		h.gameState.GamePhase = "showdown"
+		h.emitEventUnsafe(GameEvent{Type: EventShowdown})
 		h.handleShowdownUnsafe()
 		return
 	}
@@ -611,7 +820,7 @@ func (h *Hub) startNextPhaseUnsafe() {
 		if p.IsInHand && !p.IsAllIn {
 			p.HasActed = false
 			h.gameState.Players[id] = p
-			log.Printf("Reset HasActed for player %s", p.Name)
+			h.logUnsafe().Debug("reset HasActed", "player", p.Name)
 		}
 	}
 
@@ -631,6 +840,7 @@ func (h *Hub) startNextPhaseUnsafe() {
 	h.gameState.CurrentTurnIndex = firstPlayerIndex
 	h.gameState.actionToPlayerID = "" // Reset action tracking
 
+	boardBefore := len(h.gameState.CommunityCards)
 	switch h.gameState.GamePhase {
 	case "pre-flop":
 		h.gameState.GamePhase = "flop"
@@ -642,9 +852,15 @@ func (h *Hub) startNextPhaseUnsafe() {
 		h.gameState.GamePhase = "river"
 		h.dealCommunityCardsUnsafe(1)
 	}
+	h.emitEventUnsafe(GameEvent{
+		Type:     EventStreetDealt,
+		Phase:    h.gameState.GamePhase,
+		Cards:    slices.Clone(h.gameState.CommunityCards[boardBefore:]),
+		MinRaise: h.config.BigBlind,
+	})
 
 	h.gameState.LastBet = 0       // Reset betting for new round
-	h.gameState.MinRaise = BigBlindAmt // Reset minimum raise
+	h.gameState.MinRaise = h.config.BigBlind // Reset minimum raise
 
 	if h.gameState.CurrentTurnIndex == -1 {
 		// No players can act (all all-in), go to next phase
@@ -680,44 +896,196 @@ func (h *Hub) collectBetsUnsafe() int {
 	return collected
 }
 
+// awardPotUnsafe gives the whole pot to winnerIDs.
 func (h *Hub) awardPotUnsafe(winnerIDs []string) {
 	if len(winnerIDs) == 0 {
 		return
 	}
-
 	h.gameState.Pot += h.collectBetsUnsafe()
-	share := h.gameState.Pot / len(winnerIDs)
-	remainder := h.gameState.Pot % len(winnerIDs)
+	h.awardPotsUnsafe([]SidePot{{Amount: h.gameState.Pot, EligibleIDs: winnerIDs}}, [][]string{winnerIDs})
+}
 
-	for _, winnerID := range winnerIDs {
-		if winner, ok := h.gameState.Players[winnerID]; ok {
-			winner.Chips += share
-			h.gameState.Players[winnerID] = winner
+// sidePotsUnsafe splits the pot at showdown by what each player put in
+// during the hand. A player all-in for less than the others can only win as
+// much from each of them as they put in themselves, so every all-in amount
+// closes a pot and the chips above it go to a side pot for the players who
+// put in more. Chips of players who folded go to the pots they reached. The
+// main pot comes first.
+func (h *Hub) sidePotsUnsafe(contenders []string) []SidePot {
+	whole := []SidePot{{Amount: h.gameState.Pot, EligibleIDs: contenders}}
+	if h.currentHand == nil {
+		return whole
+	}
+	put := make(map[string]int, len(h.currentHand.Players))
+	levels := make([]int, 0, len(contenders))
+	for _, hp := range h.currentHand.Players {
+		if p, ok := h.gameState.Players[hp.ID]; ok {
+			put[hp.ID] = hp.StartingChips - p.Chips
 		}
 	}
+	for _, id := range contenders {
+		levels = append(levels, put[id])
+	}
+	slices.Sort(levels)
+	levels = slices.Compact(levels)
 
-	if remainder > 0 {
-		for i := 1; i <= len(h.gameState.PlayerOrder); i++ {
+	var pots []SidePot
+	total, below := 0, 0
+	for i, level := range levels {
+		if i == len(levels)-1 {
+			// Folded chips above the last all-in go to the last pot
+			for _, chips := range put {
+				level = max(level, chips)
+			}
+		}
+		pot := SidePot{}
+		for _, chips := range put {
+			pot.Amount += max(0, min(chips, level)-below)
+		}
+		for _, id := range contenders {
+			if put[id] >= levels[i] {
+				pot.EligibleIDs = append(pot.EligibleIDs, id)
+			}
+		}
+		if pot.Amount > 0 {
+			pots = append(pots, pot)
+			total += pot.Amount
+		}
+		below = level
+	}
+	if total != h.gameState.Pot || len(pots) == 0 {
+		h.logUnsafe().Error("side pots do not add up to the pot, paying it as one", "pot", h.gameState.Pot, "pots", total)
+		return whole
+	}
+	return pots
+}
+
+// bestHandsOf returns the players among ids holding the best hand.
+func bestHandsOf(ids []string, hands map[string]EvaluatedHand) []string {
+	var best []string
+	for _, id := range ids {
+		if len(best) == 0 {
+			best = []string{id}
+			continue
+		}
+		switch compareHands(hands[id], hands[best[0]]) {
+		case 1:
+			best = []string{id}
+		case 0:
+			best = append(best, id)
+		}
+	}
+	return best
+}
+
+// uncalledBetUnsafe returns the player who put the most into the hand and
+// how much more that was than anyone else put in: the part of their last bet
+// nobody called.
+func (h *Hub) uncalledBetUnsafe() (string, int) {
+	if h.currentHand == nil {
+		return "", 0
+	}
+	top, first, second := "", 0, 0
+	for _, hp := range h.currentHand.Players {
+		p, ok := h.gameState.Players[hp.ID]
+		if !ok {
+			continue
+		}
+		switch put := hp.StartingChips - p.Chips; {
+		case put > first:
+			top, first, second = hp.ID, put, first
+		case put > second:
+			second = put
+		}
+	}
+	return top, first - second
+}
+
+// awardPotsUnsafe pays each pot to its winners. A bet nobody called goes
+// back to the bettor first, and only the chips left are raked. A pot that
+// does not divide evenly gives the odd chips to the first winners after the
+// button. The rake comes out of the main pot first.
+func (h *Hub) awardPotsUnsafe(pots []SidePot, winners [][]string) {
+	h.gameState.Pot += h.collectBetsUnsafe()
+	payouts := make(map[string]int, len(h.gameState.Players))
+	var payees []string
+
+	pots = slices.Clone(pots)
+	if id, uncalled := h.uncalledBetUnsafe(); uncalled > 0 && len(pots) > 0 && slices.Contains(pots[len(pots)-1].EligibleIDs, id) {
+		// The bettor put in the most, so the last pot holds the uncalled chips
+		last := &pots[len(pots)-1]
+		uncalled = min(uncalled, last.Amount)
+		last.Amount -= uncalled
+		h.gameState.Pot -= uncalled
+		payouts[id] += uncalled
+		payees = append(payees, id)
+	}
+	var winnerIDs []string
+	for i, ids := range winners {
+		if pots[i].Amount == 0 {
+			continue
+		}
+		for _, id := range ids {
+			if !slices.Contains(winnerIDs, id) {
+				winnerIDs = append(winnerIDs, id)
+			}
+			if !slices.Contains(payees, id) {
+				payees = append(payees, id)
+			}
+		}
+	}
+	h.recordWinnersUnsafe(winnerIDs)
+	if h.gameState.Pot > 0 {
+		metrics.potSize.observe(h.config.ID, float64(h.gameState.Pot))
+	}
+	rake := h.takeRakeUnsafe()
+
+	unpaidRake := rake
+	for i, pot := range pots {
+		ids := winners[i]
+		if len(ids) == 0 || pot.Amount == 0 {
+			continue
+		}
+		raked := min(unpaidRake, pot.Amount)
+		unpaidRake -= raked
+		share := (pot.Amount - raked) / len(ids)
+		remainder := (pot.Amount - raked) % len(ids)
+		for _, winnerID := range ids {
+			payouts[winnerID] += share
+		}
+		for i := 1; i <= len(h.gameState.PlayerOrder) && remainder > 0; i++ {
 			playerID := h.gameState.PlayerOrder[(h.gameState.DealerIndex+i)%len(h.gameState.PlayerOrder)]
-			if slices.Contains(winnerIDs, playerID) {
-				if winner, ok := h.gameState.Players[playerID]; ok {
-					winner.Chips++
-					h.gameState.Players[playerID] = winner
-					remainder--
-					if remainder == 0 {
-						break
-					}
-				}
+			if slices.Contains(ids, playerID) {
+				payouts[playerID]++
+				remainder--
 			}
 		}
 	}
 	h.gameState.Pot = 0
+
+	e := GameEvent{Type: EventPotAwarded, Amount: rake}
+	for _, id := range payees {
+		if p, ok := h.gameState.Players[id]; ok {
+			p.Chips += payouts[id]
+			h.gameState.Players[id] = p
+			e.Payouts = append(e.Payouts, Payout{PlayerID: id, Amount: payouts[id]})
+		}
+	}
+	h.emitEventUnsafe(e)
 }
 
-var upgrader = websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+// CheckOrigin is set from -allowed-origins in main, see auth.go.
+var upgrader = websocket.Upgrader{
+	Subprotocols: codecSubprotocols(), // see codec.go
+}
 
 func (c *Client) readPump() {
 	defer func() {
+		if !c.registered {
+			// writePump flushes any error reply and closes the connection
+			close(c.send)
+			return
+		}
 		c.hub.unregister <- c
 		c.conn.Close()
 	}()
@@ -730,10 +1098,10 @@ func (c *Client) readPump() {
 	})
 	
 	for {
-		_, msgBytes, err := c.conn.ReadMessage()
+		_, frame, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket error for client %s: %v", c.ID, err)
+				c.hub.log.Warn("websocket error", "client", c.ID, "err", err)
 			}
 			break
 		}
@@ -742,29 +1110,34 @@ func (c *Client) readPump() {
 		c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 		
 		var msg Message
+		msgBytes, err := c.codec.Decode(frame)
+		if err != nil {
+			c.replyResult(msg, protocolErrorf(CodeInvalidMessage, "%v", err))
+			continue
+		}
 		if err := json.Unmarshal(msgBytes, &msg); err != nil {
-			log.Printf("Invalid message from client %s: %v", c.ID, err)
+			c.replyResult(msg, protocolErrorf(CodeInvalidMessage, "message is not valid JSON"))
+			continue
+		}
+		if _, known, err := validateMessage(msgBytes, "client"); !known {
+			c.replyResult(msg, protocolErrorf(CodeUnknownType, "unknown message type %q", msg.Type))
+			continue
+		} else if err != nil {
+			c.replyResult(msg, protocolErrorf(CodeInvalidMessage, "%v", err))
 			continue
 		}
-		switch msg.Type {
-		case "player_ready":
-			var payload struct {
-				IsReady bool `json:"isReady"`
+		if !c.registered {
+			if msg.Type != "hello" {
+				c.replyResult(msg, protocolErrorf(CodeHandshakeRequired, "send hello first"))
+				continue
 			}
-			if json.Unmarshal(msg.Payload, &payload) == nil {
-				c.hub.handlePlayerReady(c.ID, payload.IsReady)
-			} else {
-				log.Printf("Invalid player_ready payload from client %s", c.ID)
+			if err := c.handleHello(msg); err != nil {
+				c.replyResult(msg, err)
+				return
 			}
-		case "player_action":
-			c.hub.handlePlayerAction(c.ID, msg.Payload)
-		case "chat_message":
-			c.hub.handleChatMessage(c.ID, msg.Payload)
-		case "player_join":
-			c.hub.handlePlayerJoin(c.ID, msg.Payload)
-		default:
-			log.Printf("Unknown message type '%s' from client %s", msg.Type, c.ID)
+			continue
 		}
+		c.replyResult(msg, c.dispatch(msg))
 	}
 }
 
@@ -780,53 +1153,139 @@ func (c *Client) writePump() {
 		case msg, ok := <-c.send:
 			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
 			if !ok {
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				c.conn.WriteMessage(websocket.CloseMessage, c.closeMsg)
 				return
 			}
-			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
-				log.Printf("Error writing message to client %s: %v", c.ID, err)
+			if checkOutgoingMessages {
+				checkOutgoing(msg)
+			}
+			frame, err := c.codec.Encode(msg)
+			if err != nil {
+				c.hub.log.Error("encoding message failed", "client", c.ID, "err", err)
+				continue
+			}
+			if err := c.conn.WriteMessage(c.codec.FrameType(), frame); err != nil {
+				c.hub.log.Debug("writing message failed", "client", c.ID, "err", err)
 				return
 			}
 		case <-ticker.C:
 			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-				log.Printf("Error sending ping to client %s: %v", c.ID, err)
+				c.hub.log.Debug("sending ping failed", "client", c.ID, "err", err)
 				return
 			}
 		}
 	}
 }
 
-func serveWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
+func serveWs(lobby *Lobby, w http.ResponseWriter, r *http.Request) {
+	hub := lobby.table(r.URL.Query().Get("table"))
+	if hub == nil {
+		http.Error(w, "table not found", http.StatusNotFound)
+		return
+	}
+	if hub.isClosed() {
+		http.Error(w, "server is restarting", http.StatusServiceUnavailable)
+		return
+	}
+	// Guests connect without a token and may only watch unless -allow-guests
+	account, err := lobby.authenticate(r)
+	if errors.Is(err, errAccountBanned) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil && !errors.Is(err, errNoSession) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		return
 	}
 	// *** SỬA ĐỔI CHÍNH Ở ĐÂY ***
 	// Thay vì dùng địa chỉ IP, chúng ta tạo một ID duy nhất
-	client := &Client{ID: uuid.New().String(), hub: hub, conn: conn, send: make(chan []byte, 256)}
-	hub.register <- client
-	idPayload, _ := json.Marshal(map[string]string{"id": client.ID})
-	msg, _ := json.Marshal(Message{Type: "your_id", Payload: idPayload})
-	client.send <- msg
+	// The client joins the hub after the hello/welcome handshake, see protocol.go
+	client := &Client{ID: uuid.New().String(), hub: hub, conn: conn, codec: codecFor(conn.Subprotocol()), send: make(chan []byte, 256),
+		accountID: account.ID, accountName: account.Name}
 	go client.writePump()
 	go client.readPump()
 }
 
 func main() {
-	rand.Seed(time.Now().UnixNano())
-	hub := newHub()
-	go hub.run()
-	
-	// Start performance monitoring
-	hub.startMetricsLogger()
+	devMode := flag.Bool("dev", false, "enable development features such as deterministic decks")
+	deckSpec := flag.String("deck", "random", "deck source in dev mode: random, seeded:<seed> or stacked:<cards>[;<cards>...]")
+	dataDir := flag.String("data", "data", "directory for accounts, tables and hand histories")
+	shutdownTimeout := flag.Duration("shutdown-timeout", defaultShutdownTimeout, "how long running hands may take to finish on shutdown before they are cancelled")
+	logLevel := flag.String("log-level", "info", "minimum log level: debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "log output format: text or json")
+	adminToken := flag.String("admin-token", os.Getenv("DPOKER_ADMIN_TOKEN"), "bearer token for the /api/admin endpoints; the admin API is off without one")
+	allowedOrigins := flag.String("allowed-origins", "", "comma-separated origins allowed to open WebSockets, or * for any; defaults to the server's own host")
+	flag.BoolVar(&allowGuests, "allow-guests", false, "let connections without a session sit down as accounts that have no password")
+	flag.Parse()
+
+	if err := setupLogging(os.Stderr, *logLevel, *logFormat); err != nil {
+		fatal("invalid logging flags", "err", err)
+	}
+
+	store, err := openFileStore(*dataDir)
+	if err != nil {
+		fatal("could not open data directory", "err", err)
+	}
+	lobby, err := newLobby(store)
+	if err != nil {
+		fatal("could not load tables", "err", err)
+	}
+	if *devMode {
+		if err := lobby.useDeckSource(*deckSpec); err != nil {
+			fatal("invalid -deck", "err", err)
+		}
+		checkOutgoingMessages = true
+		slog.Info("dev mode enabled", "deck", *deckSpec)
+	} else if *deckSpec != "random" {
+		fatal("-deck requires -dev")
+	}
 	
+
 	http.Handle("/", http.FileServer(http.Dir("../frontend")))
-	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) { serveWs(hub, w, r) })
-	log.Println("Server is running on http://localhost:8080")
-	if err := http.ListenAndServe(":8080", nil); err != nil {
-		log.Fatalf("could not start server: %v\n", err)
+	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) { serveWs(lobby, w, r) })
+	http.HandleFunc("/fairness/verify", func(w http.ResponseWriter, r *http.Request) { serveFairnessVerify(lobby, w, r) })
+	http.HandleFunc("/replay", func(w http.ResponseWriter, r *http.Request) { serveReplay(lobby, w, r) })
+	http.HandleFunc("/bot", func(w http.ResponseWriter, r *http.Request) { serveBot(lobby, w, r) })
+	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) { serveMetrics(lobby, w, r) })
+	registerAPI(http.DefaultServeMux, lobby)
+	registerAuthAPI(http.DefaultServeMux, lobby)
+	upgrader.CheckOrigin = checkOrigin(*allowedOrigins)
+	if *adminToken != "" {
+		registerAdminAPI(http.DefaultServeMux, lobby, *adminToken)
+	} else {
+		slog.Info("admin API disabled, set -admin-token or DPOKER_ADMIN_TOKEN to enable it")
+	}
+	if *devMode {
+		http.HandleFunc("/dev/deck", func(w http.ResponseWriter, r *http.Request) { serveDevDeck(lobby, w, r) })
+	}
+	srv := &http.Server{Addr: ":8080"}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		slog.Info("server is running", "url", "http://localhost:8080")
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("could not start server", "err", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop() // a second signal kills the process
+	slog.Info("shutting down, waiting for running hands", "timeout", *shutdownTimeout)
+	lobby.shutdown(*shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("shutting down HTTP server failed", "err", err)
 	}
+	if err := store.Close(); err != nil {
+		slog.Error("closing store failed", "err", err)
+	}
+	slog.Info("server stopped")
 }
 
 // --- SHOWDOWN LOGIC ---
@@ -918,40 +1377,40 @@ func evaluateHand(cards []Card) EvaluatedHand {
 		}
 		return EvaluatedHand{Rank: Flush, Values: flushRanks[:5]}
 	}
-	var quads, trips, pair1, pair2 int
-	var kickers []int
+	var quads int
+	var trips, pairs, kickers []int
 	for rank, count := range rankCounts {
-		if count == 4 {
+		switch count {
+		case 4:
 			quads = rank
-		} else if count == 3 {
-			if rank > trips {
-				trips = rank
-			}
-		} else if count == 2 {
-			if rank > pair1 {
-				pair2 = pair1
-				pair1 = rank
-			} else if rank > pair2 {
-				pair2 = rank
-			}
-		} else {
+		case 3:
+			trips = append(trips, rank)
+		case 2:
+			pairs = append(pairs, rank)
+		default:
 			kickers = append(kickers, rank)
 		}
 	}
+	sort.Sort(sort.Reverse(sort.IntSlice(trips)))
+	sort.Sort(sort.Reverse(sort.IntSlice(pairs)))
 	sort.Sort(sort.Reverse(sort.IntSlice(kickers)))
 	if quads > 0 {
+		// The kicker is the best of the other cards, paired or not
 		kicker := 0
-		if trips > 0 {
-			kicker = trips
-		} else if pair1 > 0 {
-			kicker = pair1
-		} else if len(kickers) > 0 {
-			kicker = kickers[0]
+		for rank := range rankCounts {
+			if rank != quads {
+				kicker = max(kicker, rank)
+			}
 		}
 		return EvaluatedHand{Rank: FourOfAKind, Values: []int{quads, kicker}}
 	}
-	if trips > 0 && pair1 > 0 {
-		return EvaluatedHand{Rank: FullHouse, Values: []int{trips, pair1}}
+	if len(trips) > 1 {
+		// With two sets of trips the lower one is the pair of a full house
+		pairs = append([]int{trips[1]}, pairs...)
+		sort.Sort(sort.Reverse(sort.IntSlice(pairs)))
+	}
+	if len(trips) > 0 && len(pairs) > 0 {
+		return EvaluatedHand{Rank: FullHouse, Values: []int{trips[0], pairs[0]}}
 	}
 	var allRanks []int
 	for r := range rankCounts {
@@ -962,16 +1421,19 @@ func evaluateHand(cards []Card) EvaluatedHand {
 	if straight {
 		return EvaluatedHand{Rank: Straight, Values: []int{highCard}}
 	}
-	if trips > 0 {
-		values := append([]int{trips}, kickers...)
+	if len(trips) > 0 {
+		values := append([]int{trips[0]}, kickers...)
 		return EvaluatedHand{Rank: ThreeOfAKind, Values: values[:3]}
 	}
-	if pair1 > 0 && pair2 > 0 {
-		values := append([]int{pair1, pair2}, kickers...)
+	if len(pairs) > 1 {
+		// A third pair can play as the kicker
+		rest := append(slices.Clone(pairs[2:]), kickers...)
+		sort.Sort(sort.Reverse(sort.IntSlice(rest)))
+		values := append([]int{pairs[0], pairs[1]}, rest...)
 		return EvaluatedHand{Rank: TwoPair, Values: values[:3]}
 	}
-	if pair1 > 0 {
-		values := append([]int{pair1}, kickers...)
+	if len(pairs) > 0 {
+		values := append([]int{pairs[0]}, kickers...)
 		return EvaluatedHand{Rank: OnePair, Values: values[:4]}
 	}
 	return EvaluatedHand{Rank: HighCard, Values: kickers[:5]}
@@ -1014,41 +1476,41 @@ func compareHands(h1, h2 EvaluatedHand) int {
 func (h *Hub) handleShowdownUnsafe() {
 	h.gameState.CurrentTurnIndex = -1 // Explicitly end turn-based action
 
-	var winners []string
-	var bestHand EvaluatedHand
-	firstPlayer := true
+	var contenders []string
+	hands := make(map[string]EvaluatedHand)
 	for _, id := range h.gameState.PlayerOrder {
 		if p, ok := h.gameState.Players[id]; ok && p.IsInHand && p.IsConnected {
-			currentHand := evaluateHand(append(p.Hand, h.gameState.CommunityCards...))
-			currentHand.PlayerID = id
-			if firstPlayer {
-				bestHand = currentHand
-				winners = []string{id}
-				firstPlayer = false
-			} else {
-				switch compareHands(currentHand, bestHand) {
-				case 1:
-					bestHand = currentHand
-					winners = []string{id}
-				case 0:
-					winners = append(winners, id)
-				}
-			}
+			contenders = append(contenders, id)
+			hands[id] = evaluateHand(append(p.Hand, h.gameState.CommunityCards...))
 		}
 	}
-	if len(winners) > 0 {
-		if len(winners) == 1 {
-			winnerID := winners[0]
-			h.gameState.WinningHandDesc = "Winner is " + winnerID[:5] + " with " + handRankStrings[bestHand.Rank]
+	if len(contenders) > 0 {
+		h.gameState.Pot += h.collectBetsUnsafe()
+		pots := h.sidePotsUnsafe(contenders)
+		winners := make([][]string, len(pots))
+		for i, pot := range pots {
+			winners[i] = bestHandsOf(pot.EligibleIDs, hands)
+		}
+		// Everyone still in the hand can win the main pot
+		if len(winners[0]) == 1 {
+			winnerID := winners[0][0]
+			h.gameState.WinningHandDesc = "Winner is " + winnerID[:5] + " with " + handRankStrings[hands[winnerID].Rank]
 		} else {
 			h.gameState.WinningHandDesc = "Split pot!"
 		}
-		h.awardPotUnsafe(winners)
+		if len(pots) > 1 {
+			h.gameState.SidePots = pots
+		}
+		h.awardPotsUnsafe(pots, winners)
 	}
 	h.broadcastGameStateUnsafe()
+	handID := h.gameState.HandID
 	time.AfterFunc(5*time.Second, func() {
 		h.gameStateMutex.Lock()
 		defer h.gameStateMutex.Unlock()
+		if !h.gameState.GameStarted || h.gameState.HandID != handID {
+			return // ended early by a shutdown
+		}
 		h.endGameUnsafe("Showdown finished.")
 		h.broadcastGameStateUnsafe()
 	})
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// playSidePotHand deals three stacks of 400, 1000 and 2000 chips. The short
+// stack holds aces and goes all-in before the flop, the middle stack holds
+// kings and goes all-in on the flop, and the big stack calls both with
+// nothing and checks the hand down.
+func playSidePotHand(t *testing.T) (h *Hub, alice, bob, carol string) {
+	t.Helper()
+	h = newTestTable(t, "stacked:As Ad Ks Kd 7c 2h 3s Qc 8d 4s 3d 9h 3h 5c")
+	alice = sitDown(t, h, "Alice", 400)
+	bob = sitDown(t, h, "Bob", 1000)
+	carol = sitDown(t, h, "Carol", 2000)
+	dealHand(t, h, alice, bob, carol)
+
+	// Alice has the button, Bob and Carol post the blinds
+	act(t, h, alice, "raise", 400)
+	act(t, h, bob, "call", 0)
+	act(t, h, carol, "call", 0)
+	act(t, h, bob, "raise", 600)
+	act(t, h, carol, "call", 0)
+	act(t, h, carol, "check", 0)
+	act(t, h, carol, "check", 0)
+	return h, alice, bob, carol
+}
+
+func TestShowdownSidePots(t *testing.T) {
+	h, alice, bob, carol := playSidePotHand(t)
+
+	s := snapshot(h)
+	if s.GamePhase != "showdown" {
+		t.Fatalf("phase %s, want showdown", s.GamePhase)
+	}
+	want := map[string]int{alice: 1200, bob: 1200, carol: 1000}
+	for id, chips := range want {
+		if got := s.Players[id].Chips; got != chips {
+			t.Errorf("%s has %d chips, want %d", s.Players[id].Name, got, chips)
+		}
+	}
+	pots := []SidePot{
+		{Amount: 1200, EligibleIDs: []string{alice, bob, carol}},
+		{Amount: 1200, EligibleIDs: []string{bob, carol}},
+	}
+	if !slices.EqualFunc(s.SidePots, pots, func(a, b SidePot) bool {
+		return a.Amount == b.Amount && slices.Equal(a.EligibleIDs, b.EligibleIDs)
+	}) {
+		t.Errorf("pots %+v, want %+v", s.SidePots, pots)
+	}
+}
+
+func TestHeadsUpBlinds(t *testing.T) {
+	h := newTestTable(t, "random")
+	alice := sitDown(t, h, "Alice", 1000)
+	bob := sitDown(t, h, "Bob", 1000)
+
+	// The button posts the small blind and acts first before the flop, and
+	// last after it
+	for hand, button := range []string{alice, bob} {
+		other := bob
+		if button == bob {
+			other = alice
+		}
+		dealHand(t, h, alice, bob)
+		s := snapshot(h)
+		if got := s.PlayerOrder[s.DealerIndex]; got != button {
+			t.Fatalf("hand %d: button is %s", hand, s.Players[got].Name)
+		}
+		if sb, bb := s.Players[button].Bet, s.Players[other].Bet; sb != 10 || bb != 20 {
+			t.Errorf("hand %d: button posted %d and the other player %d, want 10 and 20", hand, sb, bb)
+		}
+		if got := s.PlayerOrder[s.CurrentTurnIndex]; got != button {
+			t.Errorf("hand %d: %s acts first before the flop", hand, s.Players[got].Name)
+		}
+		act(t, h, button, "call", 0)
+		act(t, h, other, "check", 0)
+		for range 3 {
+			s = snapshot(h)
+			if got := s.PlayerOrder[s.CurrentTurnIndex]; got != other {
+				t.Errorf("hand %d: %s acts first on the %s", hand, s.Players[got].Name, s.GamePhase)
+			}
+			act(t, h, other, "check", 0)
+			act(t, h, button, "check", 0)
+		}
+		endShowdown(t, h)
+	}
+}
+
+func TestEvaluateHand(t *testing.T) {
+	tests := []struct {
+		name   string
+		cards  string
+		rank   HandRank
+		values []int
+	}{
+		{"high card", "As Jd 9c 7h 5s 3d 2c", HighCard, []int{14, 11, 9, 7, 5}},
+		{"one pair", "Ks Kd 9c 7h 5s 3d 2c", OnePair, []int{13, 9, 7, 5}},
+		{"two pair", "Ks Kd 9c 9h 5s 3d 2c", TwoPair, []int{13, 9, 5}},
+		{"three pairs play the best kicker", "Ks Kd Qc Qh 9s 9d 2c", TwoPair, []int{13, 12, 9}},
+		{"third pair below the single", "Ks Kd Qc Qh 3s 3d Ac", TwoPair, []int{13, 12, 14}},
+		{"trips", "7s 7d 7c Kh 9s 3d 2c", ThreeOfAKind, []int{7, 13, 9}},
+		{"wheel", "As 2d 3c 4h 5s Kd Kc", Straight, []int{5}},
+		{"broadway", "As Kd Qc Jh Ts 3d 2c", Straight, []int{14}},
+		{"flush", "As Js 9s 7s 5s 3s Kd", Flush, []int{14, 11, 9, 7, 5}},
+		{"flush over a straight", "9s 8s 7d 6s 5s 2s Kd", Flush, []int{9, 8, 6, 5, 2}},
+		{"full house", "Ks Kd Kc 9h 9s 3d 2c", FullHouse, []int{13, 9}},
+		{"full house with two pairs", "3s 3d 3c 9h 9s Kd Kc", FullHouse, []int{3, 13}},
+		{"two sets of trips", "7s 7d 7c Kh Ks Kd 2c", FullHouse, []int{13, 7}},
+		{"quads", "7s 7d 7c 7h 9s 3d 2c", FourOfAKind, []int{7, 9}},
+		{"quads kicker over a pair", "7s 7d 7c 7h As 2d 2c", FourOfAKind, []int{7, 14}},
+		{"quads kicker from trips", "7s 7d 7c 7h 3s 3d 3c", FourOfAKind, []int{7, 3}},
+		{"quads kicker over trips", "2s 2d 2c 2h 3s 3d Ac", FourOfAKind, []int{2, 14}},
+		{"straight flush", "9s 8s 7s 6s 5s As Ad", StraightFlush, []int{9}},
+		{"steel wheel", "As 2s 3s 4s 5s Kd Kc", StraightFlush, []int{5}},
+	}
+	for _, tt := range tests {
+		got := evaluateHand(cards(t, tt.cards))
+		if got.Rank != tt.rank || !slices.Equal(got.Values, tt.values) {
+			t.Errorf("%s: %s %v, want %s %v", tt.name, handRankStrings[got.Rank], got.Values, handRankStrings[tt.rank], tt.values)
+		}
+	}
+}
+
+func TestCompareHandsKickers(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want int
+	}{
+		{"the third pair outkicks a lower single", "Ks Kd Qc Qh 9s 9d 2c", "Kc Kh Qs Qd 8s 4d 2h", 1},
+		{"two trips beat a smaller full house", "7s 7d 7c Kh Ks Kd 2c", "Kc Qs Qd Qc 2d 2h 2s", 1},
+		{"the quads kicker decides", "7s 7d 7c 7h As 2d 2c", "7s 7d 7c 7h Ks Qd Qc", 1},
+		{"the board plays", "2s 3d As Ks Qs Jd 9c", "4s 5d As Ks Qs Jd 9c", 0},
+	}
+	for _, tt := range tests {
+		a, b := evaluateHand(cards(t, tt.a)), evaluateHand(cards(t, tt.b))
+		if got := compareHands(a, b); got != tt.want {
+			t.Errorf("%s: compareHands = %d, want %d", tt.name, got, tt.want)
+		}
+		if got := compareHands(b, a); got != -tt.want {
+			t.Errorf("%s: reversed compareHands = %d, want %d", tt.name, got, -tt.want)
+		}
+	}
+}
@@ -1,43 +1,263 @@
 package main
 
 import (
-	"log"
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
 	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
-// Performance metrics helper
-type Metrics struct {
-	ActiveConnections int
-	GamesSinceStart   int
-	LastGameDuration  time.Duration
-	MemoryUsage       runtime.MemStats
-}
-
-func (h *Hub) logMetrics() {
-	var m runtime.MemStats
-	runtime.ReadMemStats(&m)
-	
-	log.Printf("=== PERFORMANCE METRICS ===")
-	log.Printf("Active connections: %d", len(h.clients))
-	log.Printf("Active players: %d", len(h.gameState.Players))
-	log.Printf("Memory Alloc: %d KB", bToKb(m.Alloc))
-	log.Printf("Memory TotalAlloc: %d KB", bToKb(m.TotalAlloc))
-	log.Printf("Memory Sys: %d KB", bToKb(m.Sys))
-	log.Printf("NumGC: %d", m.NumGC)
-	log.Printf("===========================")
-}
-
-func bToKb(b uint64) uint64 {
-	return b / 1024
-}
-
-// Call this periodically to monitor performance
-func (h *Hub) startMetricsLogger() {
-	ticker := time.NewTicker(30 * time.Second)
-	go func() {
-		for range ticker.C {
-			h.logMetrics()
+// --- Metrics ---
+//
+// GET /metrics serves the server's metrics in the Prometheus text format
+// (version 0.0.4), so any Prometheus-compatible scraper can read them
+// without a client library. Counters and histograms are recorded as things
+// happen, labelled by table; gauges are read from the tables when scraped.
+
+var metrics = struct {
+	handsTotal      *counterVec
+	droppedClients  *counterVec
+	droppedWatchers *counterVec
+	actionLatency   *histogramVec
+	broadcastTime   *histogramVec
+	potSize         *histogramVec
+	rake            *counterVec
+}{
+	handsTotal: newCounterVec("dpoker_hands_total",
+		"Hands finished, including cancelled ones."),
+	droppedClients: newCounterVec("dpoker_dropped_clients_total",
+		"WebSocket clients disconnected because their send buffer was full."),
+	droppedWatchers: newCounterVec("dpoker_dropped_watchers_total",
+		"SSE streams closed because the watcher fell behind."),
+	actionLatency: newHistogramVec("dpoker_action_latency_seconds",
+		"Time to handle a player_action, including waiting for the table lock.",
+		[]float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}),
+	broadcastTime: newHistogramVec("dpoker_broadcast_seconds",
+		"Time to fan a state change out to every client and SSE watcher of a table.",
+		[]float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1}),
+	potSize: newHistogramVec("dpoker_pot_size_chips",
+		"Chips in the pot when it is awarded.",
+		[]float64{20, 50, 100, 200, 500, 1000, 2000, 5000, 10000}),
+	rake: newCounterVec("dpoker_rake_chips_total",
+		"Chips taken from pots as rake."),
+}
+
+// counterVec is a counter with a table label.
+type counterVec struct {
+	name, help string
+	mu         sync.Mutex
+	values     map[string]float64
+}
+
+func newCounterVec(name, help string) *counterVec {
+	return &counterVec{name: name, help: help, values: make(map[string]float64)}
+}
+
+func (c *counterVec) inc(table string) {
+	c.add(table, 1)
+}
+
+func (c *counterVec) add(table string, v float64) {
+	c.mu.Lock()
+	c.values[table] += v
+	c.mu.Unlock()
+}
+
+func (c *counterVec) value(table string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[table]
+}
+
+func (c *counterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeHeader(w, c.name, c.help, "counter")
+	for _, table := range sortedKeys(c.values) {
+		writeSample(w, c.name, tableLabel(table), c.values[table])
+	}
+}
+
+// histogramVec is a histogram with a table label.
+type histogramVec struct {
+	name, help string
+	buckets    []float64 // upper bounds, ascending; +Inf is implied
+	mu         sync.Mutex
+	series     map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative; the last one is +Inf
+	sum    float64
+	count  uint64
+}
+
+func newHistogramVec(name, help string, buckets []float64) *histogramVec {
+	return &histogramVec{name: name, help: help, buckets: buckets, series: make(map[string]*histogram)}
+}
+
+func (hv *histogramVec) observe(table string, v float64) {
+	hv.mu.Lock()
+	defer hv.mu.Unlock()
+	s, ok := hv.series[table]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(hv.buckets)+1)}
+		hv.series[table] = s
+	}
+	i, _ := slices.BinarySearch(hv.buckets, v)
+	s.counts[i]++
+	s.sum += v
+	s.count++
+}
+
+func (hv *histogramVec) observeSince(table string, start time.Time) {
+	hv.observe(table, time.Since(start).Seconds())
+}
+
+func (hv *histogramVec) write(w *bufio.Writer) {
+	hv.mu.Lock()
+	defer hv.mu.Unlock()
+	writeHeader(w, hv.name, hv.help, "histogram")
+	for _, table := range sortedKeys(hv.series) {
+		s := hv.series[table]
+		label := tableLabel(table)
+		var cumulative uint64
+		for i, bound := range hv.buckets {
+			cumulative += s.counts[i]
+			writeSample(w, hv.name+"_bucket", label+`,le="`+formatFloat(bound)+`"`, float64(cumulative))
 		}
-	}()
-}
\ No newline at end of file
+		writeSample(w, hv.name+"_bucket", label+`,le="+Inf"`, float64(s.count))
+		writeSample(w, hv.name+"_sum", label, s.sum)
+		writeSample(w, hv.name+"_count", label, float64(s.count))
+	}
+}
+
+// tableMetrics is a consistent reading of one table's gauges.
+type tableMetrics struct {
+	id          string
+	connections int
+	players     int
+	spectators  int
+	waiting     int
+	watchers    int
+	handRunning bool
+	handsPerMin int
+}
+
+func (h *Hub) readMetrics() tableMetrics {
+	h.gameStateMutex.RLock()
+	defer h.gameStateMutex.RUnlock()
+	m := tableMetrics{
+		id:          h.config.ID,
+		connections: len(h.clients),
+		players:     len(h.gameState.Players),
+		spectators:  len(h.gameState.Spectators),
+		waiting:     len(h.waitlist),
+		watchers:    len(h.watchers),
+		handRunning: h.gameState.GameStarted,
+	}
+	since := time.Now().Add(-time.Minute)
+	for i := len(h.handHistories) - 1; i >= 0 && h.handHistories[i].EndedAt.After(since); i-- {
+		m.handsPerMin++
+	}
+	return m
+}
+
+func serveMetrics(lobby *Lobby, w http.ResponseWriter, r *http.Request) {
+	hubs := lobby.tableList()
+	tables := make([]tableMetrics, 0, len(hubs))
+	active := 0
+	for _, hub := range hubs {
+		m := hub.readMetrics()
+		if m.handRunning {
+			active++
+		}
+		tables = append(tables, m)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	writeGauge(bw, "dpoker_tables", "Tables on the server.", float64(len(tables)))
+	writeGauge(bw, "dpoker_tables_active", "Tables with a hand in progress.", float64(active))
+	tableGauges := []struct {
+		name, help string
+		value      func(tableMetrics) int
+	}{
+		{"dpoker_connections", "Open WebSocket connections, players and spectators.", func(m tableMetrics) int { return m.connections }},
+		{"dpoker_players", "Seated players.", func(m tableMetrics) int { return m.players }},
+		{"dpoker_spectators", "Connections watching without a seat.", func(m tableMetrics) int { return m.spectators }},
+		{"dpoker_waiting_list", "Spectators on the waiting list.", func(m tableMetrics) int { return m.waiting }},
+		{"dpoker_sse_watchers", "Open Server-Sent Events streams.", func(m tableMetrics) int { return m.watchers }},
+		{"dpoker_hands_per_minute", "Hands finished in the last minute.", func(m tableMetrics) int { return m.handsPerMin }},
+	}
+	for _, g := range tableGauges {
+		writeHeader(bw, g.name, g.help, "gauge")
+		for _, m := range tables {
+			writeSample(bw, g.name, tableLabel(m.id), float64(g.value(m)))
+		}
+	}
+
+	metrics.handsTotal.write(bw)
+	metrics.droppedClients.write(bw)
+	metrics.droppedWatchers.write(bw)
+	metrics.actionLatency.write(bw)
+	metrics.broadcastTime.write(bw)
+	metrics.potSize.write(bw)
+	metrics.rake.write(bw)
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	writeGauge(bw, "go_goroutines", "Number of goroutines.", float64(runtime.NumGoroutine()))
+	writeGauge(bw, "go_memstats_alloc_bytes", "Bytes of allocated heap objects.", float64(mem.Alloc))
+	writeGauge(bw, "go_memstats_sys_bytes", "Bytes obtained from the OS.", float64(mem.Sys))
+	writeHeader(bw, "go_memstats_alloc_bytes_total", "Bytes allocated for heap objects since start.", "counter")
+	writeSample(bw, "go_memstats_alloc_bytes_total", "", float64(mem.TotalAlloc))
+	writeHeader(bw, "go_gc_cycles_total", "Completed GC cycles.", "counter")
+	writeSample(bw, "go_gc_cycles_total", "", float64(mem.NumGC))
+}
+
+func writeHeader(w *bufio.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeGauge(w *bufio.Writer, name, help string, v float64) {
+	writeHeader(w, name, help, "gauge")
+	writeSample(w, name, "", v)
+}
+
+func writeSample(w *bufio.Writer, name, labels string, v float64) {
+	if labels != "" {
+		name += "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s %s\n", name, formatFloat(v))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func tableLabel(table string) string {
+	return `table="` + labelEscaper.Replace(table) + `"`
+}
+
+func formatFloat(v float64) string {
+	if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+		return strconv.FormatFloat(v, 'f', -1, 64) // whole numbers without an exponent
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
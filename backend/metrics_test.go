@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// sampleLine matches a sample of the text format: a name, optional labels
+// with escaped values, and a value.
+var sampleLine = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(\{(?:[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\]|\\.)*",?)*\})? (\S+)$`)
+
+// parseMetrics checks a /metrics body against the text format and returns
+// the samples by name and labels.
+func parseMetrics(t *testing.T, body string) map[string]float64 {
+	t.Helper()
+	samples := make(map[string]float64)
+	types := make(map[string]string)
+	helped := make(map[string]bool)
+	for n, line := range strings.Split(strings.TrimSuffix(body, "\n"), "\n") {
+		if rest, ok := strings.CutPrefix(line, "# HELP "); ok {
+			name, _, _ := strings.Cut(rest, " ")
+			if helped[name] {
+				t.Errorf("line %d: second HELP for %s", n+1, name)
+			}
+			helped[name] = true
+			continue
+		}
+		if rest, ok := strings.CutPrefix(line, "# TYPE "); ok {
+			name, kind, _ := strings.Cut(rest, " ")
+			if _, seen := types[name]; seen {
+				t.Errorf("line %d: second TYPE for %s", n+1, name)
+			}
+			switch kind {
+			case "counter", "gauge", "histogram":
+			default:
+				t.Errorf("line %d: type %q", n+1, kind)
+			}
+			types[name] = kind
+			continue
+		}
+		m := sampleLine.FindStringSubmatch(line)
+		if m == nil {
+			t.Errorf("line %d is not a sample: %q", n+1, line)
+			continue
+		}
+		family := m[1]
+		for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+			if base := strings.TrimSuffix(family, suffix); types[base] == "histogram" {
+				family = base
+			}
+		}
+		if types[family] == "" || !helped[family] {
+			t.Errorf("line %d: %s has no TYPE and HELP before it", n+1, m[1])
+		}
+		v, err := strconv.ParseFloat(m[3], 64)
+		if err != nil {
+			t.Errorf("line %d: value %q", n+1, m[3])
+		}
+		samples[m[1]+m[2]] = v
+	}
+	return samples
+}
+
+func TestServeMetrics(t *testing.T) {
+	lobby := newTestLobby(t)
+	h := lobby.table(defaultTableID)
+	alice := sitDown(t, h, "Alice", 1000)
+	bob := sitDown(t, h, "Bob", 1000)
+	watch(t, h, "zoe", "Zoe")
+	dealHand(t, h, alice, bob)
+	act(t, h, alice, "call", 0)
+
+	w := httptest.NewRecorder()
+	serveMetrics(lobby, w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("content type %q", ct)
+	}
+	samples := parseMetrics(t, w.Body.String())
+
+	want := map[string]float64{
+		`dpoker_tables`:                         1,
+		`dpoker_tables_active`:                  1,
+		`dpoker_players{table="main"}`:          2,
+		`dpoker_spectators{table="main"}`:       1,
+		`dpoker_connections{table="main"}`:      1,
+		`dpoker_waiting_list{table="main"}`:     0,
+		`dpoker_hands_per_minute{table="main"}`: 0,
+	}
+	for name, v := range want {
+		if got, ok := samples[name]; !ok || got != v {
+			t.Errorf("%s = %v (present %v), want %v", name, got, ok, v)
+		}
+	}
+	if samples[`dpoker_broadcast_seconds_count{table="main"}`] < 1 {
+		t.Error("no broadcast was timed")
+	}
+	for _, name := range []string{"go_goroutines", "go_memstats_alloc_bytes", "go_gc_cycles_total"} {
+		if _, ok := samples[name]; !ok {
+			t.Errorf("%s is missing", name)
+		}
+	}
+}
+
+func TestHistogramText(t *testing.T) {
+	hv := newHistogramVec("test_seconds", "A test histogram.", []float64{0.5, 1, 2.5})
+	for _, v := range []float64{0.1, 0.5, 0.7, 3, 100} {
+		hv.observe(`a "b"`, v)
+	}
+	var buf strings.Builder
+	w := bufio.NewWriter(&buf)
+	hv.write(w)
+	w.Flush()
+
+	want := `# HELP test_seconds A test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{table="a \"b\"",le="0.5"} 2
+test_seconds_bucket{table="a \"b\"",le="1"} 3
+test_seconds_bucket{table="a \"b\"",le="2.5"} 3
+test_seconds_bucket{table="a \"b\"",le="+Inf"} 5
+test_seconds_sum{table="a \"b\""} 104.3
+test_seconds_count{table="a \"b\""} 5
+`
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+	parseMetrics(t, buf.String())
+}
+
+func TestFormatFloat(t *testing.T) {
+	tests := []struct {
+		v    float64
+		want string
+	}{
+		{0, "0"},
+		{42, "42"},
+		{1234567, "1234567"},
+		{0.25, "0.25"},
+		{1e20, "1e+20"},
+		{-3, "-3"},
+	}
+	for _, tt := range tests {
+		if got := formatFloat(tt.v); got != tt.want {
+			t.Errorf("formatFloat(%v) = %s, want %s", tt.v, got, tt.want)
+		}
+	}
+	if got := tableLabel("a\\b\nc"); got != `table="a\\b\nc"` {
+		t.Errorf("tableLabel escaped to %s", got)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// --- WebSocket protocol ---
+//
+// Every connection starts with a hello/welcome handshake that fixes the
+// protocol version. Client messages may carry a requestId, which the server
+// echoes on the ack, action_rejected or error reply to that message. The
+// messages are documented in PROTOCOL.md and described by the JSON Schemas
+// in schema/.
+
+const (
+	ProtocolVersion    = 1
+	minProtocolVersion = 1
+)
+
+// Machine-readable error codes sent in action_rejected and error replies.
+const (
+	CodeInvalidMessage       = "invalid_message"
+	CodeUnknownType          = "unknown_type"
+	CodeHandshakeRequired    = "handshake_required"
+	CodeUnsupportedVersion   = "unsupported_version"
+	CodeNotSeated            = "not_seated"
+	CodeHandNotRunning       = "hand_not_running"
+	CodeHandInProgress       = "hand_in_progress"
+	CodeNotYourTurn          = "not_your_turn"
+	CodeCannotAct            = "cannot_act"
+	CodeCannotCheck          = "cannot_check"
+	CodeInvalidRaise         = "invalid_raise"
+	CodeNameInUse            = "name_in_use"
+	CodeTableFull            = "table_full"
+	CodeSeatTaken            = "seat_taken"
+	CodeAlreadySeated        = "already_seated"
+	CodeNoSeatOffer          = "no_seat_offer"
+	CodeShuttingDown         = "shutting_down"
+	CodeTablePaused          = "table_paused"
+	CodeBanned               = "banned"
+	CodeLoginRequired        = "login_required"
+	CodeInvalidBuyIn         = "invalid_buy_in"
+	CodeInsufficientBankroll = "insufficient_bankroll"
+	CodeCannotRebuy          = "cannot_rebuy"
+	CodeJoinPending          = "join_pending"
+	CodeInternal             = "internal_error"
+)
+
+// ProtocolError is a client request the server refused, with the code the
+// client gets back.
+type ProtocolError struct {
+	Code    string
+	Message string
+}
+
+func (e *ProtocolError) Error() string { return e.Message }
+
+func protocolErrorf(code, format string, args ...any) *ProtocolError {
+	return &ProtocolError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+type HelloPayload struct {
+	ProtocolVersion int    `json:"protocolVersion"`
+	Client          string `json:"client,omitempty"`
+}
+
+type WelcomePayload struct {
+	ProtocolVersion int    `json:"protocolVersion"`
+	PlayerID        string `json:"playerId"`
+	TableID         string `json:"tableId"`
+}
+
+// ErrorPayload is the payload of action_rejected and error replies.
+type ErrorPayload struct {
+	Code        string `json:"code"`
+	Message     string `json:"message"`
+	RequestType string `json:"requestType,omitempty"`
+}
+
+// handleHello completes the handshake. The client is registered with its
+// hub only once the handshake succeeds.
+func (c *Client) handleHello(msg Message) error {
+	var payload HelloPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return protocolErrorf(CodeInvalidMessage, "invalid hello payload")
+	}
+	if payload.ProtocolVersion < minProtocolVersion || payload.ProtocolVersion > ProtocolVersion {
+		return protocolErrorf(CodeUnsupportedVersion, "protocol version %d is not supported (server speaks %d to %d)",
+			payload.ProtocolVersion, minProtocolVersion, ProtocolVersion)
+	}
+	c.protocolVersion = payload.ProtocolVersion
+	c.reply(msg, "welcome", WelcomePayload{
+		ProtocolVersion: c.protocolVersion,
+		PlayerID:        c.ID,
+		TableID:         c.hub.config.ID,
+	})
+	c.registered = true
+	c.hub.registerClient(c)
+	return nil
+}
+
+// dispatch hands a validated client message to the hub.
+func (c *Client) dispatch(msg Message) error {
+	switch msg.Type {
+	case "player_ready":
+		var payload struct {
+			IsReady bool `json:"isReady"`
+		}
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			return protocolErrorf(CodeInvalidMessage, "invalid player_ready payload")
+		}
+		return c.hub.handlePlayerReady(c.ID, payload.IsReady)
+	case "player_action":
+		defer metrics.actionLatency.observeSince(c.hub.config.ID, time.Now())
+		return c.hub.handlePlayerAction(c.ID, msg.Payload)
+	case "chat_message":
+		return c.hub.handleChatMessage(c.ID, msg.Payload)
+	case "player_join":
+		return c.hub.handlePlayerJoin(c.ID, msg.Payload)
+	case "client_seed":
+		return c.hub.handleClientSeed(c.ID, msg.Payload)
+	case "take_seat":
+		return c.hub.handleTakeSeat(c.ID, msg.Payload)
+	case "join_waitlist":
+		return c.hub.handleJoinWaitlist(c.ID, msg.Payload)
+	case "leave_waitlist":
+		return c.hub.handleLeaveWaitlist(c.ID)
+	case "accept_seat":
+		return c.hub.handleAcceptSeat(c.ID)
+	case "stand_up":
+		return c.hub.handleStandUp(c.ID)
+	case "rebuy":
+		return c.hub.handleRebuy(c.ID)
+	case "add_on":
+		return c.hub.handleAddOn(c.ID)
+	case "get_leaderboard":
+		return c.handleGetLeaderboard(msg)
+	case "resync":
+		c.hub.handleResync(c)
+		return nil
+	case "hello":
+		return protocolErrorf(CodeInvalidMessage, "handshake already completed")
+	}
+	return protocolErrorf(CodeUnknownType, "unknown message type %q", msg.Type)
+}
+
+// replyResult acknowledges msg, or tells the client why it was refused.
+// Successful messages without a requestId get no reply; the state update is
+// the answer.
+func (c *Client) replyResult(msg Message, err error) {
+	if err == nil {
+		if msg.RequestID != "" {
+			c.reply(msg, "ack", struct{}{})
+		}
+		return
+	}
+	var perr *ProtocolError
+	if !errors.As(err, &perr) {
+		if errors.Is(err, errAccountInUse) {
+			perr = &ProtocolError{Code: CodeNameInUse, Message: err.Error()}
+		} else {
+			c.hub.log.Error("handling message failed", "type", msg.Type, "client", c.ID, "err", err)
+			perr = &ProtocolError{Code: CodeInternal, Message: "internal error"}
+		}
+	}
+	replyType := "error"
+	if msg.Type == "player_action" {
+		replyType = "action_rejected"
+	}
+	c.reply(msg, replyType, ErrorPayload{Code: perr.Code, Message: perr.Message, RequestType: msg.Type})
+}
+
+// reply sends a message answering req, echoing its requestId.
+func (c *Client) reply(req Message, msgType string, payload any) {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("marshaling payload failed", "type", msgType, "err", err)
+		return
+	}
+	msg, err := json.Marshal(Message{Type: msgType, RequestID: req.RequestID, Payload: payloadBytes})
+	if err != nil {
+		slog.Error("marshaling message failed", "err", err)
+		return
+	}
+	if !c.registered {
+		// Not known to the hub yet, so nothing else writes to or closes send
+		select {
+		case c.send <- msg:
+		default:
+		}
+		return
+	}
+	c.hub.sendTo(c, msg)
+}
+
+// checkOutgoingMessages is set by -dev: every message sent to a client is
+// validated against its schema and violations are logged.
+var checkOutgoingMessages bool
+
+func checkOutgoing(msg []byte) {
+	msgType, known, err := validateMessage(msg, "server")
+	if !known {
+		slog.Warn("PROTOCOL: sent message type has no schema", "type", msgType)
+	} else if err != nil {
+		slog.Warn("PROTOCOL: sent message violates its schema", "type", msgType, "err", err)
+	}
+}
+
+// pushUnsafe sends a server-initiated message to one connection, if it is
+// still open.
+func (h *Hub) pushUnsafe(clientID, msgType string, payload any) {
+	client, ok := h.clients[clientID]
+	if !ok {
+		return
+	}
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("marshaling payload failed", "type", msgType, "err", err)
+		return
+	}
+	msg, err := json.Marshal(Message{Type: msgType, Payload: payloadBytes})
+	if err != nil {
+		slog.Error("marshaling message failed", "err", err)
+		return
+	}
+	h.sendUnsafe(client, msg)
+}
+
+// sendTo queues msg for a single client if it is still connected.
+func (h *Hub) sendTo(client *Client, msg []byte) {
+	h.gameStateMutex.Lock()
+	defer h.gameStateMutex.Unlock()
+	if _, ok := h.clients[client.ID]; ok {
+		h.sendUnsafe(client, msg)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// --- Rake ---
+//
+// Tables can take a rake for the house: rakePercent of every pot, rounded
+// down and limited to rakeCap chips. Only called chips are raked: a bet
+// nobody called goes back to the bettor before the rake is worked out. With
+// noFlopNoDrop, hands that end before the flop are not raked. The rake comes
+// out of the pot before it is split, is recorded with the hand and moves from
+// the table to the house in the ledger.
+
+const maxRakePercent = 10
+
+// TableRake is the rake a table has taken, from GET /api/admin/rake.
+type TableRake struct {
+	TableID string `json:"tableId"`
+	Rake    int    `json:"rake"`
+	Hands   int    `json:"hands"` // hands that were raked
+}
+
+// rakeUnsafe is the rake due on the pot about to be awarded, once the
+// uncalled chips are out of it.
+func (h *Hub) rakeUnsafe() int {
+	if h.config.RakePercent <= 0 || (h.config.NoFlopNoDrop && len(h.gameState.CommunityCards) < 3) {
+		return 0
+	}
+	// In basis points, so that 2.5% of 200 is exactly 5
+	rake := h.gameState.Pot * int(math.Round(h.config.RakePercent*100)) / 10000
+	if h.config.RakeCap > 0 {
+		rake = min(rake, h.config.RakeCap)
+	}
+	return rake
+}
+
+// takeRakeUnsafe takes the rake out of the pot and returns it.
+func (h *Hub) takeRakeUnsafe() int {
+	rake := h.rakeUnsafe()
+	if rake == 0 {
+		return 0
+	}
+	h.gameState.Pot -= rake
+	if h.currentHand != nil {
+		h.currentHand.Rake += rake
+	}
+	h.accounts.TakeRake(h.config.ID, h.gameState.HandID, rake)
+	metrics.rake.add(h.config.ID, float64(rake))
+	return rake
+}
+
+// TakeRake moves a hand's rake from the table to the house.
+func (b *AccountBook) TakeRake(tableID, handID string, chips int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.postLocked(LedgerEntry{Kind: LedgerRake, From: tableLedger(tableID), To: houseLedger,
+		Amount: chips, TableID: tableID, Memo: "hand " + handID})
+	b.countRakeLocked(tableID, chips)
+}
+
+func (b *AccountBook) countRakeLocked(tableID string, chips int) {
+	r := b.rake[tableID]
+	r.TableID = tableID
+	r.Rake += chips
+	r.Hands++
+	b.rake[tableID] = r
+}
+
+// RakeReport returns the rake every table has taken, including closed ones.
+func (b *AccountBook) RakeReport() []TableRake {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	report := make([]TableRake, 0, len(b.rake))
+	for _, r := range b.rake {
+		report = append(report, r)
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].TableID < report[j].TableID })
+	return report
+}
@@ -0,0 +1,95 @@
+package main
+
+import "testing"
+
+// setRake turns on the rake at a test table.
+func setRake(h *Hub, percent float64, cap int, noFlopNoDrop bool) {
+	h.gameStateMutex.Lock()
+	defer h.gameStateMutex.Unlock()
+	h.config.RakePercent, h.config.RakeCap, h.config.NoFlopNoDrop = percent, cap, noFlopNoDrop
+}
+
+func TestRake(t *testing.T) {
+	h := newTestTable(t, "random")
+	flop := cards(t, "As Kd 7c")
+	tests := []struct {
+		name         string
+		percent      float64
+		cap          int
+		noFlopNoDrop bool
+		pot          int
+		board        []Card
+		want         int
+	}{
+		{"no rake", 0, 0, false, 1000, flop, 0},
+		{"whole basis points", 2.5, 0, false, 200, flop, 5},
+		{"rounded down", 2.5, 0, false, 199, flop, 4},
+		{"a tenth of a percent", 0.1, 0, false, 1000, flop, 1},
+		{"capped", 5, 3, false, 200, flop, 3},
+		{"under the cap", 5, 30, false, 200, flop, 10},
+		{"no flop, no drop", 5, 0, true, 200, nil, 0},
+		{"no flop, no drop after the flop", 5, 0, true, 200, flop, 10},
+		{"raked before the flop", 5, 0, false, 200, nil, 10},
+	}
+	for _, tt := range tests {
+		setRake(h, tt.percent, tt.cap, tt.noFlopNoDrop)
+		h.gameStateMutex.Lock()
+		h.gameState.Pot, h.gameState.CommunityCards = tt.pot, tt.board
+		got := h.rakeUnsafe()
+		h.gameStateMutex.Unlock()
+		if got != tt.want {
+			t.Errorf("%s: rake %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUncalledAllInIsNotRaked(t *testing.T) {
+	h := newTestTable(t, "stacked:As Ad Ks Kd 7c 2h 3s Qc 8d 4s 3d 9h 3h 5c")
+	setRake(h, 5, 0, false)
+	alice := sitDown(t, h, "Alice", 400)
+	bob := sitDown(t, h, "Bob", 1000)
+	dealHand(t, h, alice, bob)
+
+	// Alice has the button and acts first; Bob's raise is 600 more than
+	// Alice can call
+	act(t, h, alice, "raise", 400)
+	act(t, h, bob, "raise", 1000)
+	s := waitFor(t, h, "the showdown", func(s GameState) bool { return s.GamePhase == "showdown" })
+	checkEventLog(t, h, "after the showdown")
+
+	const rake = 40 // 5% of the 800 called, not of 1400
+	if got := s.Players[alice].Chips + s.Players[bob].Chips; got != 1400-rake {
+		t.Errorf("players have %d chips, want %d", got, 1400-rake)
+	}
+	if s.Players[bob].Chips < 600 {
+		t.Errorf("Bob has %d chips, want his uncalled 600 back at least", s.Players[bob].Chips)
+	}
+	if report := h.accounts.RakeReport(); len(report) != 1 || report[0].Rake != rake {
+		t.Errorf("rake report %+v, want %d", report, rake)
+	}
+}
+
+func TestFoldWinReturnsTheUncalledBet(t *testing.T) {
+	h := newTestTable(t, "random")
+	setRake(h, 5, 0, false)
+	alice := sitDown(t, h, "Alice", 1000)
+	bob := sitDown(t, h, "Bob", 1000)
+	dealHand(t, h, alice, bob)
+	act(t, h, alice, "raise", 200)
+
+	h.gameStateMutex.Lock()
+	p := h.gameState.Players[bob]
+	p.IsInHand = false
+	h.gameState.Players[bob] = p
+	h.awardPotUnsafe([]string{alice})
+	h.gameStateMutex.Unlock()
+
+	// Bob called 20 of Alice's 200, so 180 go back and the 40 called are raked
+	s := snapshot(h)
+	if got := s.Players[alice].Chips; got != 1000+20-2 {
+		t.Errorf("Alice has %d chips, want %d", got, 1000+20-2)
+	}
+	if got := s.Players[bob].Chips; got != 980 {
+		t.Errorf("Bob has %d chips, want 980", got)
+	}
+}
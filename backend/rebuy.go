@@ -0,0 +1,119 @@
+package main
+
+import (
+	"slices"
+	"time"
+)
+
+// --- Rebuys and add-ons ---
+//
+// A player who runs out of chips keeps the seat for rebuyWindow so they can
+// rebuy; after that they go back to watching. Between hands any seated
+// player below the table's rebuyTo can top up to it, as far as their
+// bankroll allows. Tables with addOnChips also offer one add-on of that size
+// per seating, during the player's first addOnHands hands; unlike a rebuy it
+// may take the stack past the maximum buy-in.
+
+const rebuyWindow = 60 * time.Second
+
+// bustUnsafe keeps a player who ran out of chips seated, and sends them back
+// to watching if they have not rebought when the window closes.
+func (h *Hub) bustUnsafe(playerID, name string) {
+	at := time.Now()
+	h.busted[playerID] = at
+	h.playerReady[playerID] = false
+	h.logUnsafe().Info("player busted", "player", name)
+	h.addSystemChatMessage(name + " is out of chips and can rebuy within a minute.")
+	time.AfterFunc(rebuyWindow, func() { h.releaseBusted(playerID, at) })
+}
+
+func (h *Hub) releaseBusted(playerID string, at time.Time) {
+	h.gameStateMutex.Lock()
+	defer h.gameStateMutex.Unlock()
+	if !h.busted[playerID].Equal(at) {
+		return // rebought, or left and sat down again
+	}
+	p, ok := h.gameState.Players[playerID]
+	if !ok || (h.gameState.GameStarted && slices.Contains(h.gameState.PlayerOrder, playerID)) {
+		return
+	}
+	h.removePlayerUnsafe(playerID)
+	if _, connected := h.clients[playerID]; connected {
+		h.addSpectatorUnsafe(playerID, p.Name)
+	}
+	h.log.Info("busted player back to watching", "player", p.Name)
+	h.broadcastGameStateUnsafe()
+}
+
+// handleRebuy tops the player's stack up to the table's rebuyTo, or by as
+// much as the bankroll holds.
+func (h *Hub) handleRebuy(playerID string) error {
+	h.gameStateMutex.Lock()
+	defer h.gameStateMutex.Unlock()
+	return h.rebuyUnsafe(playerID)
+}
+
+func (h *Hub) rebuyUnsafe(playerID string) error {
+	p, err := h.betweenHandsPlayerUnsafe(playerID, "rebuy")
+	if err != nil {
+		return err
+	}
+	if p.Chips >= h.config.RebuyTo {
+		return protocolErrorf(CodeCannotRebuy, "rebuys top up to %d chips and you have %d", h.config.RebuyTo, p.Chips)
+	}
+	account, _ := h.accounts.Get(p.AccountID)
+	chips := min(h.config.RebuyTo-p.Chips, account.Bankroll)
+	if chips <= 0 {
+		return protocolErrorf(CodeInsufficientBankroll, "your bankroll is empty")
+	}
+	return h.addChipsUnsafe(p, chips, LedgerRebuy, EventRebuy)
+}
+
+// handleAddOn adds the table's add-on to the player's stack.
+func (h *Hub) handleAddOn(playerID string) error {
+	h.gameStateMutex.Lock()
+	defer h.gameStateMutex.Unlock()
+	p, err := h.betweenHandsPlayerUnsafe(playerID, "take the add-on")
+	if err != nil {
+		return err
+	}
+	switch {
+	case h.config.AddOnChips == 0:
+		return protocolErrorf(CodeCannotRebuy, "this table has no add-on")
+	case h.addOnTaken[playerID]:
+		return protocolErrorf(CodeCannotRebuy, "you already took the add-on")
+	case h.handsDealt[playerID] >= h.config.AddOnHands:
+		return protocolErrorf(CodeCannotRebuy, "the add-on is only offered for your first %d hands", h.config.AddOnHands)
+	}
+	if err := h.addChipsUnsafe(p, h.config.AddOnChips, LedgerAddOn, EventAddOn); err != nil {
+		return err
+	}
+	h.addOnTaken[playerID] = true
+	return nil
+}
+
+// betweenHandsPlayerUnsafe returns the seated player, refusing while they
+// are dealt into the running hand.
+func (h *Hub) betweenHandsPlayerUnsafe(playerID, what string) (Player, error) {
+	p, ok := h.gameState.Players[playerID]
+	if !ok {
+		return Player{}, protocolErrorf(CodeNotSeated, "not seated at this table")
+	}
+	if h.gameState.GameStarted && slices.Contains(h.gameState.PlayerOrder, playerID) {
+		return Player{}, protocolErrorf(CodeHandInProgress, "you can %s when the hand ends", what)
+	}
+	return p, nil
+}
+
+func (h *Hub) addChipsUnsafe(p Player, chips int, ledgerKind, eventType string) error {
+	if err := h.accounts.BuyMore(p.AccountID, chips, ledgerKind); err != nil {
+		return protocolErrorf(CodeInsufficientBankroll, "your bankroll does not cover %d chips", chips)
+	}
+	p.Chips += chips
+	h.gameState.Players[p.ID] = p
+	delete(h.busted, p.ID)
+	h.emitEventUnsafe(GameEvent{Type: eventType, PlayerID: p.ID, Amount: chips})
+	h.logUnsafe().Info("chips added", "player", p.Name, "kind", ledgerKind, "chips", chips, "stack", p.Chips)
+	h.broadcastGameStateUnsafe()
+	return nil
+}
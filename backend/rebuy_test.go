@@ -0,0 +1,167 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// setRebuys sets the rebuy and add-on terms of a test table.
+func setRebuys(h *Hub, rebuyTo, addOnChips, addOnHands int) {
+	h.gameStateMutex.Lock()
+	defer h.gameStateMutex.Unlock()
+	h.config.RebuyTo, h.config.AddOnChips, h.config.AddOnHands = rebuyTo, addOnChips, addOnHands
+}
+
+// postings returns the amounts of kind moved from the player's bankroll to
+// the table.
+func postings(t *testing.T, h *Hub, playerID, kind string) []int {
+	t.Helper()
+	accountID := snapshot(h).Players[playerID].AccountID
+	entries, err := h.accounts.store.LoadLedger(bankrollLedger(accountID), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var amounts []int
+	for _, e := range entries {
+		if e.Kind != kind {
+			continue
+		}
+		if e.From != bankrollLedger(accountID) || e.To != tableLedger(h.config.ID) {
+			t.Errorf("%s moved chips from %s to %s", kind, e.From, e.To)
+		}
+		amounts = append(amounts, e.Amount)
+	}
+	return amounts
+}
+
+func TestRebuy(t *testing.T) {
+	lobby := newTestLobby(t)
+	h := lobby.table(defaultTableID)
+	setRebuys(h, 1000, 0, 0)
+	alice := sitDown(t, h, "Alice", 400)
+	bob := sitDown(t, h, "Bob", 1000)
+
+	if err := h.handleRebuy(alice); err != nil {
+		t.Fatal(err)
+	}
+	if got := snapshot(h).Players[alice].Chips; got != 1000 {
+		t.Errorf("Alice rebought to %d chips, want 1000", got)
+	}
+	if got := postings(t, h, alice, LedgerRebuy); len(got) != 1 || got[0] != 600 {
+		t.Errorf("rebuy postings %v, want [600]", got)
+	}
+	if a, _, _ := h.accounts.Lookup("Alice"); a.Bankroll != StartingBankroll-1000 || a.InPlay != 1000 {
+		t.Errorf("Alice has %d in her bankroll and %d in play", a.Bankroll, a.InPlay)
+	}
+
+	// A short bankroll tops up as far as it goes
+	a, err := h.accounts.Register("Carol", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.accounts.AdjustBankroll(a.ID, 650-a.Bankroll, "test"); err != nil {
+		t.Fatal(err)
+	}
+	carol := "carol"
+	h.gameStateMutex.Lock()
+	h.addSpectatorUnsafe(carol, "Carol")
+	err = h.seatSpectatorUnsafe(carol, "Carol", 0, 400)
+	h.gameStateMutex.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.handleRebuy(carol); err != nil {
+		t.Fatal(err)
+	}
+	if got := snapshot(h).Players[carol].Chips; got != 650 {
+		t.Errorf("Carol rebought to %d chips, want 650", got)
+	}
+
+	checkProtocolError(t, "full stack", h.handleRebuy(bob), CodeCannotRebuy)
+	checkProtocolError(t, "at rebuyTo", h.handleRebuy(alice), CodeCannotRebuy)
+	checkProtocolError(t, "empty bankroll", h.handleRebuy(carol), CodeInsufficientBankroll)
+	checkProtocolError(t, "not seated", h.handleRebuy("stranger"), CodeNotSeated)
+	if got := postings(t, h, alice, LedgerRebuy); len(got) != 1 {
+		t.Errorf("refused rebuys posted %v", got)
+	}
+
+	dealHand(t, h, alice, bob, carol)
+	checkProtocolError(t, "during the hand", h.handleRebuy(carol), CodeHandInProgress)
+	if report := lobby.ledgerReport(); !report.Balanced {
+		t.Errorf("ledger does not balance: %v", report.Mismatches)
+	}
+}
+
+func TestBustedPlayerKeepsTheSeatUntilTheWindowCloses(t *testing.T) {
+	h := newTestTable(t, "random")
+	setRebuys(h, 1000, 0, 0)
+	alice := sitDown(t, h, "Alice", 1000)
+	bob := sitDown(t, h, "Bob", 1000)
+
+	bust := func(id string) time.Time {
+		h.gameStateMutex.Lock()
+		defer h.gameStateMutex.Unlock()
+		p := h.gameState.Players[id]
+		h.accounts.AdjustStack(p.AccountID, -p.Chips, "test")
+		p.Chips = 0
+		h.gameState.Players[id] = p
+		h.bustUnsafe(id, p.Name)
+		return h.busted[id]
+	}
+	aliceBust, bobBust := bust(alice), bust(bob)
+	if err := h.handleRebuy(alice); err != nil {
+		t.Fatal(err)
+	}
+
+	// The window closes: Alice rebought and stays, Bob goes back to watching
+	h.releaseBusted(alice, aliceBust)
+	h.releaseBusted(bob, bobBust)
+	s := snapshot(h)
+	if p, ok := s.Players[alice]; !ok || p.Chips != 1000 {
+		t.Errorf("Alice has %d chips, seated %v; want 1000 and still seated", p.Chips, ok)
+	}
+	if _, ok := s.Players[bob]; ok {
+		t.Error("Bob is still seated after the rebuy window")
+	}
+}
+
+func TestAddOn(t *testing.T) {
+	lobby := newTestLobby(t)
+	h := lobby.table(defaultTableID)
+	setRebuys(h, 1000, 500, 1)
+	alice := sitDown(t, h, "Alice", 1000)
+	bob := sitDown(t, h, "Bob", 1000)
+
+	// The add-on may go past the maximum buy-in, once
+	h.gameStateMutex.Lock()
+	h.config.MaxBuyIn = 1000
+	h.gameStateMutex.Unlock()
+	if err := h.handleAddOn(alice); err != nil {
+		t.Fatal(err)
+	}
+	if got := snapshot(h).Players[alice].Chips; got != 1500 {
+		t.Errorf("Alice has %d chips after the add-on, want 1500", got)
+	}
+	if got := postings(t, h, alice, LedgerAddOn); len(got) != 1 || got[0] != 500 {
+		t.Errorf("add-on postings %v, want [500]", got)
+	}
+	checkProtocolError(t, "second add-on", h.handleAddOn(alice), CodeCannotRebuy)
+
+	// Bob has played his one hand
+	dealHand(t, h, alice, bob)
+	checkProtocolError(t, "during the hand", h.handleAddOn(bob), CodeHandInProgress)
+	if _, err := h.forceEndHand("test"); err != nil {
+		t.Fatal(err)
+	}
+	checkProtocolError(t, "after the first hands", h.handleAddOn(bob), CodeCannotRebuy)
+	if got := postings(t, h, bob, LedgerAddOn); len(got) != 0 {
+		t.Errorf("refused add-ons posted %v", got)
+	}
+
+	setRebuys(h, 1000, 0, 1)
+	carol := sitDown(t, h, "Carol", 1000)
+	checkProtocolError(t, "no add-on at the table", h.handleAddOn(carol), CodeCannotRebuy)
+	if report := lobby.ledgerReport(); !report.Balanced {
+		t.Errorf("ledger does not balance: %v", report.Mismatches)
+	}
+}
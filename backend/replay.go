@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	replayFrameInterval = 1500 * time.Millisecond
+	replayMinSpeed      = 0.25
+	replayMaxSpeed      = 8
+)
+
+// ReplayControlPayload is sent by replay clients as a "replay_control"
+// message. Command is one of play, pause, step, seek or speed.
+type ReplayControlPayload struct {
+	Command string  `json:"command"`
+	Frame   int     `json:"frame"`
+	Speed   float64 `json:"speed"`
+}
+
+// ReplayStatePayload is pushed as "replay_state" after every change so
+// clients can render replay controls.
+type ReplayStatePayload struct {
+	HandID      string  `json:"handId"`
+	Frame       int     `json:"frame"`
+	TotalFrames int     `json:"totalFrames"`
+	Playing     bool    `json:"playing"`
+	Speed       float64 `json:"speed"`
+}
+
+type replaySession struct {
+	conn    *websocket.Conn
+	codec   Codec
+	hist    *HandHistory
+	frame   int // index of the next frame to send
+	playing bool
+	speed   float64
+}
+
+// serveReplay streams a recorded hand to a WebSocket client as ordinary
+// game_state messages, one frame per action. Anyone may watch a replay, so
+// it only shows what the table showed.
+func serveReplay(lobby *Lobby, w http.ResponseWriter, r *http.Request) {
+	recorded := lobby.handHistory(r.URL.Query().Get("hand"))
+	if recorded == nil {
+		http.Error(w, "hand not found", http.StatusNotFound)
+		return
+	}
+	hist := recorded.public()
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// The same keepalive as players' connections; run sends the pings
+	conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		return nil
+	})
+
+	codec := codecFor(conn.Subprotocol())
+	controls := make(chan ReplayControlPayload)
+	done := make(chan struct{}) // closed when the session ends
+	defer close(done)
+	go func() {
+		defer close(controls)
+		for {
+			_, frame, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+			data, err := codec.Decode(frame)
+			if err != nil {
+				slog.Warn("invalid replay frame", "err", err)
+				continue
+			}
+			msgType, known, err := validateMessage(data, "client")
+			if msgType != "replay_control" || !known || err != nil {
+				slog.Debug("ignoring replay message", "type", msgType, "err", err)
+				continue
+			}
+			var msg Message
+			if err := json.Unmarshal(data, &msg); err != nil {
+				continue
+			}
+			var payload ReplayControlPayload
+			if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+				slog.Debug("invalid replay_control payload", "err", err)
+				continue
+			}
+			select {
+			case controls <- payload:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	s := &replaySession{conn: conn, codec: codec, hist: hist, playing: true, speed: 1}
+	s.run(controls)
+}
+
+func (s *replaySession) run(controls <-chan ReplayControlPayload) {
+	if !s.sendState() {
+		return
+	}
+	var tick <-chan time.Time
+	if s.playing {
+		tick = time.After(0)
+	}
+	ping := time.NewTicker(54 * time.Second)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ping.C:
+			s.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := s.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				slog.Debug("sending replay ping failed", "err", err)
+				return
+			}
+			continue // keep the frame timer running
+		case <-tick:
+			if !s.sendFrame() {
+				return
+			}
+			if s.frame >= len(s.hist.Frames) {
+				s.playing = false
+				if !s.sendState() {
+					return
+				}
+			}
+		case ctl, ok := <-controls:
+			if !ok {
+				return
+			}
+			switch ctl.Command {
+			case "play":
+				if s.frame >= len(s.hist.Frames) {
+					s.frame = 0
+				}
+				s.playing = true
+			case "pause":
+				s.playing = false
+			case "step":
+				s.playing = false
+				if !s.sendFrame() {
+					return
+				}
+			case "seek":
+				s.playing = false
+				s.frame = max(0, min(ctl.Frame, len(s.hist.Frames)-1))
+				if !s.sendFrame() {
+					return
+				}
+			case "speed":
+				s.speed = max(replayMinSpeed, min(ctl.Speed, replayMaxSpeed))
+			default:
+				slog.Debug("unknown replay command", "command", ctl.Command)
+				continue
+			}
+			if !s.sendState() {
+				return
+			}
+		}
+		tick = nil
+		if s.playing {
+			tick = time.After(time.Duration(float64(replayFrameInterval) / s.speed))
+		}
+	}
+}
+
+// sendFrame writes the next frame, if any, and advances the cursor.
+func (s *replaySession) sendFrame() bool {
+	if s.frame >= len(s.hist.Frames) {
+		return true
+	}
+	frame := s.hist.Frames[s.frame]
+	s.frame++
+	return s.write(Message{Type: "game_state", Payload: frame})
+}
+
+func (s *replaySession) sendState() bool {
+	payload, err := json.Marshal(ReplayStatePayload{
+		HandID:      s.hist.ID,
+		Frame:       s.frame,
+		TotalFrames: len(s.hist.Frames),
+		Playing:     s.playing,
+		Speed:       s.speed,
+	})
+	if err != nil {
+		slog.Error("marshaling replay state failed", "err", err)
+		return false
+	}
+	return s.write(Message{Type: "replay_state", Payload: payload})
+}
+
+func (s *replaySession) write(msg Message) bool {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		slog.Error("marshaling message failed", "err", err)
+		return false
+	}
+	if checkOutgoingMessages {
+		checkOutgoing(data)
+	}
+	if data, err = s.codec.Encode(data); err != nil {
+		slog.Error("encoding replay message failed", "err", err)
+		return false
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if err := s.conn.WriteMessage(s.codec.FrameType(), data); err != nil {
+		slog.Warn("writing replay failed", "err", err)
+		return false
+	}
+	return true
+}
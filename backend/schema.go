@@ -0,0 +1,258 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// The JSON Schemas in schema/ describe every WebSocket message, one file per
+// message type. They are the reference for client authors and are enforced at
+// runtime: incoming messages are always validated, outgoing ones in dev mode.
+//
+// Only the subset of JSON Schema the files use is implemented: type, const,
+// enum, properties, required, additionalProperties, items, minimum, maximum,
+// minLength, maxLength and local $ref to #/$defs/<name>.
+
+//go:embed schema/*.json
+var schemaFiles embed.FS
+
+// Schema is a parsed JSON Schema document or subschema.
+type Schema struct {
+	Ref                  string             `json:"$ref"`
+	Defs                 map[string]*Schema `json:"$defs"`
+	Description          string             `json:"description"`
+	Direction            string             `json:"x-direction"` // client, server or both
+	Type                 schemaTypes        `json:"type"`
+	Const                any                `json:"const"`
+	Enum                 []any              `json:"enum"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *schemaOrBool      `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+
+	root *Schema
+}
+
+// schemaTypes accepts both "type": "string" and "type": ["string", "null"].
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var one string
+	if json.Unmarshal(data, &one) == nil {
+		*t = schemaTypes{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*t = many
+	return nil
+}
+
+// schemaOrBool is additionalProperties: false forbids unknown properties,
+// a schema constrains them.
+type schemaOrBool struct {
+	Allowed bool
+	Schema  *Schema
+}
+
+func (s *schemaOrBool) UnmarshalJSON(data []byte) error {
+	if json.Unmarshal(data, &s.Allowed) == nil {
+		return nil
+	}
+	s.Allowed = true
+	return json.Unmarshal(data, &s.Schema)
+}
+
+// messageSchemas maps a message type to its schema.
+var messageSchemas = mustLoadSchemas()
+
+func mustLoadSchemas() map[string]*Schema {
+	entries, err := schemaFiles.ReadDir("schema")
+	if err != nil {
+		panic(err)
+	}
+	schemas := make(map[string]*Schema, len(entries))
+	for _, e := range entries {
+		data, err := schemaFiles.ReadFile(path.Join("schema", e.Name()))
+		if err != nil {
+			panic(err)
+		}
+		var s Schema
+		if err := json.Unmarshal(data, &s); err != nil {
+			panic(fmt.Sprintf("schema/%s: %v", e.Name(), err))
+		}
+		s.setRoot(&s)
+		schemas[strings.TrimSuffix(e.Name(), ".json")] = &s
+	}
+	return schemas
+}
+
+func (s *Schema) setRoot(root *Schema) {
+	if s == nil {
+		return
+	}
+	s.root = root
+	for _, d := range s.Defs {
+		d.setRoot(root)
+	}
+	for _, p := range s.Properties {
+		p.setRoot(root)
+	}
+	if s.AdditionalProperties != nil {
+		s.AdditionalProperties.Schema.setRoot(root)
+	}
+	s.Items.setRoot(root)
+}
+
+// validateMessage checks a raw message against the schema of its type. ok is
+// false when there is no schema for the type, or the schema is for the other
+// direction.
+func validateMessage(data []byte, direction string) (msgType string, ok bool, err error) {
+	var msg struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return "", false, err
+	}
+	s, found := messageSchemas[msg.Type]
+	if !found || (s.Direction != direction && s.Direction != "both") {
+		return msg.Type, false, nil
+	}
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return msg.Type, true, err
+	}
+	return msg.Type, true, s.validate(v, "")
+}
+
+func (s *Schema) validate(v any, at string) error {
+	if s.Ref != "" {
+		name, found := strings.CutPrefix(s.Ref, "#/$defs/")
+		def := s.root.Defs[name]
+		if !found || def == nil {
+			return fmt.Errorf("%s: unresolvable $ref %q", pointerOrRoot(at), s.Ref)
+		}
+		return def.validate(v, at)
+	}
+	if len(s.Type) > 0 && !typeMatches(s.Type, v) {
+		return fmt.Errorf("%s: expected %s, got %s", pointerOrRoot(at), strings.Join(s.Type, " or "), jsonTypeOf(v))
+	}
+	if s.Const != nil && !reflect.DeepEqual(s.Const, v) {
+		return fmt.Errorf("%s: must be %v", pointerOrRoot(at), s.Const)
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if reflect.DeepEqual(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: must be one of %v", pointerOrRoot(at), s.Enum)
+		}
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s: missing property %q", pointerOrRoot(at), name)
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			sub, ok := s.Properties[k]
+			if !ok && s.AdditionalProperties != nil {
+				if !s.AdditionalProperties.Allowed {
+					return fmt.Errorf("%s: unknown property %q", pointerOrRoot(at), k)
+				}
+				sub = s.AdditionalProperties.Schema
+			}
+			if sub == nil {
+				continue
+			}
+			if err := sub.validate(v[k], at+"/"+escapePointer(k)); err != nil {
+				return err
+			}
+		}
+	case []any:
+		if s.Items != nil {
+			for i, item := range v {
+				if err := s.Items.validate(item, fmt.Sprintf("%s/%d", at, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.MinLength != nil && n < *s.MinLength {
+			return fmt.Errorf("%s: must be at least %d characters", pointerOrRoot(at), *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			return fmt.Errorf("%s: must be at most %d characters", pointerOrRoot(at), *s.MaxLength)
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			return fmt.Errorf("%s: must be at least %v", pointerOrRoot(at), *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			return fmt.Errorf("%s: must be at most %v", pointerOrRoot(at), *s.Maximum)
+		}
+	}
+	return nil
+}
+
+func typeMatches(types []string, v any) bool {
+	actual := jsonTypeOf(v)
+	for _, t := range types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonTypeOf(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func pointerOrRoot(at string) string {
+	if at == "" {
+		return "message"
+	}
+	return at
+}
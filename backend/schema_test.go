@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clientSamples are inbound messages, each expected to pass or fail
+// validation against its schema.
+var clientSamples = []struct {
+	msg   string
+	valid bool
+}{
+	{`{"type":"hello","payload":{"protocolVersion":1,"client":"web"}}`, true},
+	{`{"type":"hello","payload":{"protocolVersion":0}}`, false},
+	{`{"type":"hello","payload":{}}`, false},
+	{`{"type":"player_join","payload":{"name":"Alice","buyIn":500}}`, true},
+	{`{"type":"player_join","payload":{}}`, true},
+	{`{"type":"player_join","payload":{"name":""}}`, false},
+	{`{"type":"player_join","payload":{"name":"Alice","buyIn":0}}`, false},
+	{`{"type":"take_seat","payload":{"seat":3,"name":"Alice","buyIn":500}}`, true},
+	{`{"type":"take_seat","payload":{"seat":11}}`, false},
+	{`{"type":"stand_up","payload":{}}`, true},
+	{`{"type":"stand_up","payload":{"now":true}}`, false},
+	{`{"type":"player_ready","requestId":"r1","payload":{"isReady":true}}`, true},
+	{`{"type":"player_ready","payload":{"isReady":"yes"}}`, false},
+	{`{"type":"player_ready","requestId":"","payload":{"isReady":true}}`, false},
+	{`{"type":"player_action","payload":{"action":"raise","amount":40}}`, true},
+	{`{"type":"player_action","payload":{"action":"fold"}}`, true},
+	{`{"type":"player_action","payload":{"action":"bet","amount":40}}`, false},
+	{`{"type":"player_action","payload":{"action":"raise","amount":-1}}`, false},
+	{`{"type":"player_action","payload":{"action":"raise","amount":1.5}}`, false},
+	{`{"type":"chat_message","payload":{"message":"gl hf"}}`, true},
+	{`{"type":"chat_message","payload":{"message":""}}`, false},
+	{`{"type":"chat_message","payload":{"message":"` + strings.Repeat("♠", 500) + `"}}`, true},
+	{`{"type":"chat_message","payload":{"message":"` + strings.Repeat("♠", 501) + `"}}`, false},
+	{`{"type":"client_seed","payload":{"seed":"c0ffee"}}`, true},
+	{`{"type":"client_seed","payload":{"seed":"` + strings.Repeat("a", 65) + `"}}`, false},
+	{`{"type":"rebuy","payload":{}}`, true},
+	{`{"type":"add_on","payload":{}}`, true},
+	{`{"type":"add_on"}`, false},
+	{`{"type":"join_waitlist","payload":{"name":"Alice","buyIn":500}}`, true},
+	{`{"type":"join_waitlist","payload":{"buyIn":"500"}}`, false},
+	{`{"type":"leave_waitlist","payload":{}}`, true},
+	{`{"type":"accept_seat","payload":{}}`, true},
+	{`{"type":"resync","payload":{}}`, true},
+	{`{"type":"resync","payload":[]}`, false},
+	{`{"type":"get_leaderboard","payload":{"period":"weekly","board":"net","limit":10}}`, true},
+	{`{"type":"get_leaderboard","payload":{}}`, true},
+	{`{"type":"get_leaderboard","payload":{"period":"monthly"}}`, false},
+	{`{"type":"get_leaderboard","payload":{"limit":101}}`, false},
+	{`{"type":"replay_control","payload":{"command":"seek","frame":4}}`, true},
+	{`{"type":"replay_control","payload":{"command":"speed","speed":2.5}}`, true},
+	{`{"type":"replay_control","payload":{"command":"rewind"}}`, false},
+	{`{"type":"decision","requestId":"r1","payload":{"action":"call"}}`, true},
+	{`{"type":"decision","payload":{"action":"call"}}`, false},
+}
+
+func TestClientMessagesMatchSchemas(t *testing.T) {
+	for _, tt := range clientSamples {
+		msgType, known, err := validateMessage([]byte(tt.msg), "client")
+		if !known {
+			t.Errorf("%s: no client schema for %q", tt.msg, msgType)
+			continue
+		}
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: error = %v, want valid %v", tt.msg, err, tt.valid)
+		}
+	}
+}
+
+// serverMessage encodes an outbound message the way the hub does.
+func serverMessage(t *testing.T, msgType, requestID string, payload any) []byte {
+	t.Helper()
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := json.Marshal(Message{Type: msgType, RequestID: requestID, Payload: payloadBytes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// liveServerMessages plays a hand with a client attached to a player and
+// returns everything the table sent it, with a decision_request for the
+// player's first turn.
+func liveServerMessages(t *testing.T) [][]byte {
+	t.Helper()
+	h := newTestTable(t, "stacked:As Ad Ks Kd 7c 2h 3s Qc 8d 4s 3d 9h 3h 5c")
+	alice := sitDown(t, h, "Alice", 400)
+	bob := sitDown(t, h, "Bob", 1000)
+	carol := sitDown(t, h, "Carol", 2000)
+	client := &Client{ID: alice, hub: h, send: make(chan []byte, 256)}
+	h.gameStateMutex.Lock()
+	h.clients[alice] = client
+	h.gameStateMutex.Unlock()
+
+	dealHand(t, h, alice, bob, carol)
+	h.gameStateMutex.RLock()
+	v := h.botViewUnsafe(alice)
+	h.gameStateMutex.RUnlock()
+	msgs := [][]byte{serverMessage(t, "decision_request", "r1", DecisionRequestPayload{
+		HandID:       v.HandID,
+		TimeoutMs:    int(botDecisionTimeout.Milliseconds()),
+		LegalActions: v.legalActions(),
+		State:        v,
+	})}
+
+	act(t, h, alice, "raise", 400)
+	act(t, h, bob, "call", 0)
+	act(t, h, carol, "call", 0)
+	act(t, h, bob, "raise", 600)
+	act(t, h, carol, "call", 0)
+	act(t, h, carol, "check", 0)
+	act(t, h, carol, "check", 0)
+	endShowdown(t, h)
+	for len(client.send) > 0 {
+		msgs = append(msgs, <-client.send)
+	}
+	return msgs
+}
+
+func TestServerMessagesMatchSchemas(t *testing.T) {
+	now := time.Now()
+	msgs := [][]byte{
+		serverMessage(t, "welcome", "r1", WelcomePayload{ProtocolVersion: ProtocolVersion, PlayerID: "p1", TableID: "main"}),
+		serverMessage(t, "ack", "r1", struct{}{}),
+		serverMessage(t, "error", "", ErrorPayload{Code: CodeUnknownType, Message: "unknown message type", RequestType: "dance"}),
+		serverMessage(t, "action_rejected", "r2", ErrorPayload{Code: CodeNotYourTurn, Message: "it is not your turn"}),
+		serverMessage(t, "bot_seated", "", BotSeatedPayload{TableID: "main", PlayerID: "p1", Name: "Robo", Seat: 2, Chips: 400}),
+		serverMessage(t, "leaderboard", "", Leaderboard{Period: "all_time", Board: "net", Entries: []LeaderboardEntry{}}),
+		serverMessage(t, "leaderboard", "r3", Leaderboard{Period: "weekly", Board: "hands", Since: now,
+			Entries: []LeaderboardEntry{{Rank: 1, AccountID: "a1", Name: "Alice", Value: 12}}}),
+		serverMessage(t, "replay_state", "", ReplayStatePayload{HandID: "h1", Frame: 3, TotalFrames: 9, Playing: true, Speed: 1.5}),
+		serverMessage(t, "seat_offer", "", SeatOfferPayload{TableID: "main", Seat: 4, ExpiresAt: now, TimeoutMs: 30000}),
+		serverMessage(t, "seat_open", "", SeatOpenPayload{TableID: "main", OpenSeats: 1}),
+		serverMessage(t, "server_shutdown", "", ServerShutdownPayload{Message: "restarting", Deadline: now}),
+		serverMessage(t, "waitlist_position", "", WaitlistPositionPayload{TableID: "main", Position: 0, Length: 2}),
+	}
+	msgs = append(msgs, liveServerMessages(t)...)
+
+	seen := make(map[string]bool)
+	for _, msg := range msgs {
+		msgType, known, err := validateMessage(msg, "server")
+		if !known {
+			t.Errorf("no server schema for %q", msgType)
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s violates its schema: %v\n%s", msgType, err, msg)
+		}
+		seen[msgType] = true
+	}
+	for _, msgType := range []string{"game_state", "state_delta", "decision_request"} {
+		if !seen[msgType] {
+			t.Errorf("the hand sent no %s", msgType)
+		}
+	}
+
+	invalid := []string{
+		`{"type":"welcome","payload":{"protocolVersion":1,"playerId":"p1"}}`,
+		`{"type":"ack","payload":{"ok":true}}`,
+		`{"type":"error","payload":{"code":"x"}}`,
+		`{"type":"seat_offer","payload":{"tableId":"main","seat":0,"expiresAt":"","timeoutMs":1}}`,
+		`{"type":"state_delta","payload":[{"op":"replace","path":"/pot","value":40}]}`,
+		`{"type":"state_delta","seq":2,"payload":[{"op":"move","path":"/pot"}]}`,
+		`{"type":"waitlist_position","payload":{"tableId":"main","position":-1,"length":0}}`,
+		`{"type":"game_state","payload":{"pot":"40"}}`,
+	}
+	for _, msg := range invalid {
+		if _, _, err := validateMessage([]byte(msg), "server"); err == nil {
+			t.Errorf("%s passed validation", msg)
+		}
+	}
+}
+
+func TestEverySchemaHasSamples(t *testing.T) {
+	sampled := map[string]bool{
+		// TestServerMessagesMatchSchemas
+		"welcome": true, "ack": true, "error": true, "action_rejected": true,
+		"bot_seated": true, "leaderboard": true, "replay_state": true,
+		"seat_offer": true, "seat_open": true, "server_shutdown": true,
+		"waitlist_position": true, "game_state": true, "state_delta": true,
+		"decision_request": true,
+	}
+	for _, tt := range clientSamples {
+		var msg Message
+		if err := json.Unmarshal([]byte(tt.msg), &msg); err != nil {
+			t.Fatalf("%s: %v", tt.msg, err)
+		}
+		sampled[msg.Type] = true
+	}
+	for name := range messageSchemas {
+		if !sampled[name] {
+			t.Errorf("schema/%s.json has no sample messages", name)
+		}
+	}
+}
+
+func TestValidateMessageDirection(t *testing.T) {
+	tests := []struct {
+		msg, direction string
+		known, wantErr bool
+	}{
+		{`{"type":"player_ready","payload":{"isReady":true}}`, "client", true, false},
+		{`{"type":"player_ready","payload":{"isReady":true}}`, "server", false, false},
+		{`{"type":"ack","payload":{}}`, "client", false, false},
+		{`{"type":"dance","payload":{}}`, "client", false, false},
+		{`{"type":`, "client", false, true},
+	}
+	for _, tt := range tests {
+		_, known, err := validateMessage([]byte(tt.msg), tt.direction)
+		if known != tt.known || (err != nil) != tt.wantErr {
+			t.Errorf("validateMessage(%s, %s) = %v, %v; want known %v, error %v", tt.msg, tt.direction, known, err, tt.known, tt.wantErr)
+		}
+	}
+}
+
+func TestSchemaValidate(t *testing.T) {
+	const doc = `{
+		"$defs": {
+			"card": {"type": "object", "required": ["rank"], "properties": {"rank": {"type": "integer", "minimum": 2, "maximum": 14}}}
+		},
+		"type": "object",
+		"required": ["kind"],
+		"additionalProperties": false,
+		"properties": {
+			"kind": {"const": "hand"},
+			"name": {"type": ["string", "null"], "minLength": 2, "maxLength": 3},
+			"speed": {"type": "number"},
+			"suit": {"enum": ["s", "h", "d", "c"]},
+			"cards": {"type": "array", "items": {"$ref": "#/$defs/card"}},
+			"broken": {"$ref": "#/$defs/missing"},
+			"extra": {"type": "object", "additionalProperties": {"type": "boolean"}}
+		}
+	}`
+	var s Schema
+	if err := json.Unmarshal([]byte(doc), &s); err != nil {
+		t.Fatal(err)
+	}
+	s.setRoot(&s)
+
+	tests := []struct {
+		value string
+		err   string // substring of the error, "" when valid
+	}{
+		{`{"kind":"hand"}`, ""},
+		{`{"kind":"hand","name":null,"speed":1.5,"suit":"s"}`, ""},
+		{`{"kind":"hand","name":"♠♠♠","speed":2}`, ""},
+		{`[]`, "message: expected object, got array"},
+		{`{}`, `message: missing property "kind"`},
+		{`{"kind":"card"}`, "/kind: must be hand"},
+		{`{"kind":"hand","colour":"red"}`, `message: unknown property "colour"`},
+		{`{"kind":"hand","name":7}`, "/name: expected string or null, got integer"},
+		{`{"kind":"hand","name":"a"}`, "/name: must be at least 2 characters"},
+		{`{"kind":"hand","name":"abcd"}`, "/name: must be at most 3 characters"},
+		{`{"kind":"hand","speed":"fast"}`, "/speed: expected number, got string"},
+		{`{"kind":"hand","suit":"x"}`, "/suit: must be one of"},
+		{`{"kind":"hand","cards":[{"rank":14},{"rank":2}]}`, ""},
+		{`{"kind":"hand","cards":[{"rank":14},{"rank":1}]}`, "/cards/1/rank: must be at least 2"},
+		{`{"kind":"hand","cards":[{"rank":15}]}`, "/cards/0/rank: must be at most 14"},
+		{`{"kind":"hand","cards":[{}]}`, `/cards/0: missing property "rank"`},
+		{`{"kind":"hand","broken":1}`, `/broken: unresolvable $ref "#/$defs/missing"`},
+		{`{"kind":"hand","extra":{"a/b":true}}`, ""},
+		{`{"kind":"hand","extra":{"a/b":1}}`, "/extra/a~1b: expected boolean, got integer"},
+	}
+	for _, tt := range tests {
+		var v any
+		if err := json.Unmarshal([]byte(tt.value), &v); err != nil {
+			t.Fatal(err)
+		}
+		err := s.validate(v, "")
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.value, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: error %v, want %q", tt.value, err, tt.err)
+		}
+	}
+}
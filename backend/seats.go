@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+)
+
+// --- Seats ---
+//
+// A table has seats numbered 1 to MaxSeats. The hand is played in seat
+// order, and the button moves to the next occupied seat after the one it
+// was on, skipping empty seats.
+
+type TakeSeatPayload struct {
+	Seat  int    `json:"seat"`
+	Name  string `json:"name"`
+	BuyIn int    `json:"buyIn"` // 0 for the table default
+}
+
+// freeSeatUnsafe returns the lowest seat that is neither taken nor held for
+// the waiting list, or 0 if there is none.
+func (h *Hub) freeSeatUnsafe() int {
+	taken := make(map[int]bool, len(h.gameState.Players))
+	for _, p := range h.gameState.Players {
+		taken[p.Seat] = true
+	}
+	held := h.heldSeatsUnsafe()
+	for seat := 1; seat <= h.config.MaxSeats; seat++ {
+		if !taken[seat] && held[seat] == "" {
+			return seat
+		}
+	}
+	return 0
+}
+
+func (h *Hub) checkSeatUnsafe(seat int) error {
+	if seat < 1 || seat > h.config.MaxSeats {
+		return protocolErrorf(CodeInvalidMessage, "seat must be between 1 and %d", h.config.MaxSeats)
+	}
+	for _, p := range h.gameState.Players {
+		if p.Seat == seat {
+			return protocolErrorf(CodeSeatTaken, "seat %d is taken by %s", seat, p.Name)
+		}
+	}
+	if _, held := h.heldSeatsUnsafe()[seat]; held {
+		return protocolErrorf(CodeSeatTaken, "seat %d is held for the waiting list", seat)
+	}
+	return nil
+}
+
+// nextButtonIndexUnsafe finds the PlayerOrder index of the first player
+// seated after the previous button.
+func (h *Hub) nextButtonIndexUnsafe() int {
+	for i, id := range h.gameState.PlayerOrder {
+		if h.gameState.Players[id].Seat > h.gameState.ButtonSeat {
+			return i
+		}
+	}
+	return 0
+}
+
+// handleTakeSeat sits a spectator in the chosen seat, or moves a seated
+// player who is not in the running hand.
+func (h *Hub) handleTakeSeat(playerID string, payloadBytes json.RawMessage) error {
+	var payload TakeSeatPayload
+	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+		return protocolErrorf(CodeInvalidMessage, "invalid take_seat payload")
+	}
+
+	h.gameStateMutex.Lock()
+	defer h.gameStateMutex.Unlock()
+	player, seated := h.gameState.Players[playerID]
+	if !seated {
+		name, err := h.seatNameUnsafe(playerID, payload.Name)
+		if err != nil {
+			return err
+		}
+		if err := h.seatSpectatorUnsafe(playerID, name, payload.Seat, payload.BuyIn); err != nil {
+			return err
+		}
+		h.broadcastGameStateUnsafe()
+		return nil
+	}
+	if player.Seat == payload.Seat {
+		return nil
+	}
+	if h.gameState.GameStarted && slices.Contains(h.gameState.PlayerOrder, playerID) {
+		return protocolErrorf(CodeHandInProgress, "you can change seats when the hand ends")
+	}
+	if err := h.checkSeatUnsafe(payload.Seat); err != nil {
+		return err
+	}
+	player.Seat = payload.Seat
+	h.gameState.Players[playerID] = player
+	h.emitEventUnsafe(GameEvent{Type: EventSeatChanged, PlayerID: playerID, Seat: payload.Seat})
+	h.logUnsafe().Info("player changed seats", "player", player.Name, "seat", payload.Seat)
+	h.broadcastGameStateUnsafe()
+	return nil
+}
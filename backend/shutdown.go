@@ -0,0 +1,228 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// --- Graceful shutdown ---
+//
+// On SIGTERM every table stops dealing new hands and tells its clients. Hands
+// already running get a grace period to finish; any still running after it
+// are cancelled and every player gets back what they put in. Then seats,
+// stacks and button positions are written to a checkpoint, and the
+// connections are closed. The stacks stay on the table in the ledger. On the
+// next boot the checkpoint holds each player's seat and stack for them for a
+// while; a player who does not come back in time is cashed out.
+
+const (
+	defaultShutdownTimeout = 60 * time.Second
+	seatRestoreWindow      = 2 * time.Minute
+)
+
+// TableCheckpoint is what a table needs to carry on after a restart.
+type TableCheckpoint struct {
+	TableID    string           `json:"tableId"`
+	SavedAt    time.Time        `json:"savedAt"`
+	ButtonSeat int              `json:"buttonSeat"`
+	Seats      []SeatCheckpoint `json:"seats"`
+}
+
+type SeatCheckpoint struct {
+	Seat      int    `json:"seat"`
+	AccountID string `json:"accountId"`
+	Name      string `json:"name"`
+	Chips     int    `json:"chips"`
+}
+
+type ServerShutdownPayload struct {
+	Message  string    `json:"message"`
+	Deadline time.Time `json:"deadline"` // running hands are cancelled after this
+}
+
+// shutdown drains every table and saves the checkpoint.
+func (l *Lobby) shutdown(timeout time.Duration) {
+	hubs := l.tableList()
+	deadline := time.Now().Add(timeout)
+	for _, hub := range hubs {
+		hub.beginDrain(deadline)
+	}
+	for _, hub := range hubs {
+		for hub.handRunning() && time.Now().Before(deadline) {
+			time.Sleep(250 * time.Millisecond)
+		}
+	}
+	checkpoints := make([]TableCheckpoint, 0, len(hubs))
+	for _, hub := range hubs {
+		checkpoints = append(checkpoints, hub.close())
+	}
+	if err := l.store.SaveCheckpoint(checkpoints); err != nil {
+		slog.Error("saving checkpoint failed", "err", err)
+		return
+	}
+	slog.Info("checkpoint saved", "tables", len(checkpoints))
+}
+
+// restoreCheckpoint applies the checkpoint left by the last shutdown, once.
+func (l *Lobby) restoreCheckpoint() error {
+	checkpoints, err := l.store.LoadCheckpoint()
+	if err != nil || len(checkpoints) == 0 {
+		return err
+	}
+	for _, cp := range checkpoints {
+		if hub := l.table(cp.TableID); hub != nil {
+			hub.restore(cp)
+			continue
+		}
+		for _, s := range cp.Seats {
+			l.accounts.Release(s.AccountID, s.Chips) // the table is gone
+		}
+	}
+	return l.store.SaveCheckpoint(nil)
+}
+
+// beginDrain stops new hands and tells everyone at the table.
+func (h *Hub) beginDrain(deadline time.Time) {
+	h.gameStateMutex.Lock()
+	defer h.gameStateMutex.Unlock()
+	h.draining = true
+	msg := "The server is restarting. No new hands will be dealt."
+	if h.gameState.GameStarted {
+		msg = "The server is restarting after this hand."
+	}
+	for id := range h.clients {
+		h.pushUnsafe(id, "server_shutdown", ServerShutdownPayload{Message: msg, Deadline: deadline})
+	}
+	h.addSystemChatMessage(msg)
+	h.broadcastGameStateUnsafe()
+}
+
+func (h *Hub) handRunning() bool {
+	h.gameStateMutex.RLock()
+	defer h.gameStateMutex.RUnlock()
+	return h.gameState.GameStarted
+}
+
+func (h *Hub) isClosed() bool {
+	h.gameStateMutex.RLock()
+	defer h.gameStateMutex.RUnlock()
+	return h.closed
+}
+
+// close ends the table: it cancels a hand that is still running, returns
+// every stack to its account and disconnects everyone.
+func (h *Hub) close() TableCheckpoint {
+	h.gameStateMutex.Lock()
+	defer h.gameStateMutex.Unlock()
+	if h.gameState.GameStarted && h.gameState.GamePhase == "showdown" {
+		// The pot is already paid out; only the showdown display is left.
+		h.endGameUnsafe("Showdown finished.")
+		h.broadcastGameStateUnsafe()
+	} else if h.gameState.GameStarted {
+		h.cancelHandUnsafe("Hand cancelled: server restarting")
+		h.broadcastGameStateUnsafe()
+	}
+	cp := TableCheckpoint{TableID: h.config.ID, SavedAt: time.Now(), ButtonSeat: h.gameState.ButtonSeat}
+	for id, p := range h.gameState.Players {
+		if p.AccountID == "" {
+			continue
+		}
+		if h.bots[id] != nil {
+			h.accounts.Release(p.AccountID, p.Chips)
+			h.dropBotUnsafe(id) // bots are not seated again after a restart
+			continue
+		}
+		h.accounts.UpdateStack(p.AccountID, p.Chips)
+		cp.Seats = append(cp.Seats, SeatCheckpoint{Seat: p.Seat, AccountID: p.AccountID, Name: p.Name, Chips: p.Chips})
+	}
+	for _, s := range h.restoredSeats {
+		h.accounts.Release(s.AccountID, s.Chips) // held since the last restart
+	}
+	h.restoredSeats = nil
+	h.closed = true
+	closeMsg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server restarting")
+	for id, client := range h.clients {
+		client.closeMsg = closeMsg
+		close(client.send)
+		delete(h.clients, id)
+	}
+	for watcher := range h.watchers {
+		close(watcher.send)
+		delete(h.watchers, watcher)
+	}
+	h.log.Info("table closed", "seated", len(cp.Seats))
+	return cp
+}
+
+// cancelHandUnsafe ends the running hand without a winner, giving every
+// player back what they put in.
+func (h *Hub) cancelHandUnsafe(reason string) {
+	var refunds []Payout
+	if h.currentHand != nil {
+		for _, hp := range h.currentHand.Players {
+			p, ok := h.gameState.Players[hp.ID]
+			if !ok {
+				continue
+			}
+			if put := hp.StartingChips - p.Chips; put > 0 {
+				p.Chips = hp.StartingChips
+				refunds = append(refunds, Payout{PlayerID: hp.ID, Amount: put})
+			}
+			p.Bet = 0
+			h.gameState.Players[hp.ID] = p
+		}
+	}
+	h.gameState.Pot = 0
+	h.gameState.SidePots = []SidePot{}
+	h.emitEventUnsafe(GameEvent{Type: EventHandCancelled, Payouts: refunds, Reason: reason})
+	h.logUnsafe().Info("hand cancelled", "refunded", len(refunds))
+	h.endGameUnsafe(reason)
+}
+
+// restore holds each checkpointed player's seat and stack for their account
+// and puts the button back where it was.
+func (h *Hub) restore(cp TableCheckpoint) {
+	h.gameStateMutex.Lock()
+	defer h.gameStateMutex.Unlock()
+	h.gameState.ButtonSeat = cp.ButtonSeat
+	h.restoredSeats = make(map[string]SeatCheckpoint, len(cp.Seats))
+	for _, s := range cp.Seats {
+		if s.Seat < 1 || s.Seat > h.config.MaxSeats {
+			h.accounts.Release(s.AccountID, s.Chips)
+			continue
+		}
+		h.restoredSeats[s.AccountID] = s
+	}
+	time.AfterFunc(seatRestoreWindow, h.releaseRestoredSeats)
+	h.log.Info("table restored from checkpoint", "savedAt", cp.SavedAt, "seatsHeld", len(h.restoredSeats))
+}
+
+// restoredSeatUnsafe returns the seat and stack held for the account called
+// name since the restart.
+func (h *Hub) restoredSeatUnsafe(name string) (SeatCheckpoint, bool) {
+	if len(h.restoredSeats) == 0 {
+		return SeatCheckpoint{}, false
+	}
+	account, _, ok := h.accounts.Lookup(name)
+	if !ok {
+		return SeatCheckpoint{}, false
+	}
+	s, ok := h.restoredSeats[account.ID]
+	return s, ok
+}
+
+// releaseRestoredSeats cashes out the players who did not come back.
+func (h *Hub) releaseRestoredSeats() {
+	h.gameStateMutex.Lock()
+	defer h.gameStateMutex.Unlock()
+	if len(h.restoredSeats) == 0 {
+		return
+	}
+	for _, s := range h.restoredSeats {
+		h.accounts.Release(s.AccountID, s.Chips)
+	}
+	h.restoredSeats = nil
+	h.broadcastGameStateUnsafe()
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// restartLobby opens a lobby on dir, as a server boot would.
+func restartLobby(t *testing.T, dir string) *Lobby {
+	t.Helper()
+	store, err := openFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	lobby, err := newLobby(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lobby
+}
+
+func TestCheckpointRestoresSeatsAndStacks(t *testing.T) {
+	dir := t.TempDir()
+	lobby := restartLobby(t, dir)
+	h := lobby.table(defaultTableID)
+	alice := sitDown(t, h, "Alice", 1000)
+	bob := sitDown(t, h, "Bob", 600)
+	dealHand(t, h, alice, bob)
+	act(t, h, alice, "call", 0)
+	act(t, h, bob, "check", 0)
+	act(t, h, bob, "check", 0)
+	act(t, h, alice, "check", 0)
+	act(t, h, bob, "raise", 100)
+	act(t, h, alice, "call", 0)
+	before := snapshot(h)
+
+	// The hand in progress is cancelled and everyone gets back what they put in
+	lobby.shutdown(0)
+	if !h.isClosed() {
+		t.Fatal("the table is still open")
+	}
+
+	lobby = restartLobby(t, dir)
+	h = lobby.table(defaultTableID)
+	if report := lobby.ledgerReport(); !report.Balanced {
+		t.Fatalf("ledger does not balance after the restart: %v", report.Mismatches)
+	}
+	for _, name := range []string{"Alice", "Bob"} {
+		a, table, _ := lobby.accounts.Lookup(name)
+		if table != defaultTableID || a.InPlay == 0 {
+			t.Errorf("%s's stack left the table: table %q, in play %d", name, table, a.InPlay)
+		}
+	}
+
+	// Carol cannot take a held seat, and Alice gets hers back with her stack
+	carol := sitDown(t, h, "Carol", 1000)
+	if seat := snapshot(h).Players[carol].Seat; seat == before.Players[alice].Seat || seat == before.Players[bob].Seat {
+		t.Errorf("Carol was given held seat %d", seat)
+	}
+	bankroll := StartingBankroll - 1000
+	id := uuid.NewString()
+	h.gameStateMutex.Lock()
+	h.addSpectatorUnsafe(id, "Alice")
+	err := h.seatSpectatorUnsafe(id, "Alice", 0, 0)
+	h.gameStateMutex.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := snapshot(h).Players[id]
+	if p.Seat != before.Players[alice].Seat || p.Chips != 1000 {
+		t.Errorf("Alice is back in seat %d with %d chips, want seat %d with 1000", p.Seat, p.Chips, before.Players[alice].Seat)
+	}
+	if a, _, _ := lobby.accounts.Lookup("Alice"); a.Bankroll != bankroll {
+		t.Errorf("Alice's bankroll is %d, want %d", a.Bankroll, bankroll)
+	}
+
+	// Bob does not come back in time and is cashed out
+	h.releaseRestoredSeats()
+	if b, table, _ := lobby.accounts.Lookup("Bob"); table != "" || b.Bankroll != StartingBankroll {
+		t.Errorf("Bob has %d chips in his bankroll at table %q, want %d and no table", b.Bankroll, table, StartingBankroll)
+	}
+	if report := lobby.ledgerReport(); !report.Balanced {
+		t.Errorf("ledger does not balance: %v", report.Mismatches)
+	}
+	lobby.shutdown(0)
+}
+
+func TestRestoredSeatIsHeldForTheAccount(t *testing.T) {
+	dir := t.TempDir()
+	lobby := restartLobby(t, dir)
+	alice := sitDown(t, lobby.table(defaultTableID), "Alice", 1000)
+	seat := snapshot(lobby.table(defaultTableID)).Players[alice].Seat
+	lobby.shutdown(0)
+
+	lobby = restartLobby(t, dir)
+	h := lobby.table(defaultTableID)
+	h.gameStateMutex.Lock()
+	h.addSpectatorUnsafe("other", "Mallory")
+	err := h.seatSpectatorUnsafe("other", "Mallory", seat, 0)
+	h.gameStateMutex.Unlock()
+	if err == nil {
+		t.Errorf("another player took the seat held for Alice")
+	}
+	lobby.shutdown(0)
+}
+
+func TestDrainDealsNoNewHands(t *testing.T) {
+	h := newTestTable(t, "random")
+	alice := sitDown(t, h, "Alice", 1000)
+	bob := sitDown(t, h, "Bob", 1000)
+	h.setPaused(true)
+	for _, id := range []string{alice, bob} {
+		if err := h.handlePlayerReady(id, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h.beginDrain(time.Now().Add(time.Minute))
+	if err := h.handlePlayerReady(alice, true); err == nil {
+		t.Error("a player got ready during the drain")
+	}
+	h.setPaused(false)
+	if s := snapshot(h); s.GameStarted {
+		t.Error("resuming the table dealt a hand during the drain")
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"slices"
+)
+
+// --- Spectators ---
+//
+// A connection starts as a spectator: it sees the public table state and the
+// chat but cannot act. Joining with a name (player_join or take_seat) takes a
+// seat if the table has one free; stand_up gives it back between hands.
+// Spectators are told with a seat_open message whenever a seat frees up.
+
+// Spectator is a connection watching the table without a seat.
+type Spectator struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type SeatOpenPayload struct {
+	TableID   string `json:"tableId"`
+	OpenSeats int    `json:"openSeats"`
+}
+
+func (h *Hub) addSpectatorUnsafe(id, name string) {
+	if name == "" {
+		name = "Spectator-" + id[:8]
+	}
+	h.gameState.Spectators[id] = Spectator{ID: id, Name: name}
+}
+
+// openSeatsUnsafe counts the seats anyone may take: free and not held for
+// the waiting list.
+func (h *Hub) openSeatsUnsafe() int {
+	return max(0, h.config.MaxSeats-len(h.gameState.Players)-len(h.heldSeatsUnsafe()))
+}
+
+// seatSpectatorUnsafe gives a spectator a seat, playing as the account called
+// name and buying in for buyIn chips. Seat 0 picks the lowest free seat and
+// buy-in 0 the table default.
+func (h *Hub) seatSpectatorUnsafe(id, name string, seat, buyIn int) error {
+	if _, ok := h.gameState.Spectators[id]; !ok {
+		return protocolErrorf(CodeNotSeated, "not at this table")
+	}
+	// A seat held for them, on the waiting list or since a restart, is taken
+	// unless they ask for another one
+	restored, _ := h.restoredSeatUnsafe(name)
+	held := restored.Seat
+	if e := h.waitEntryUnsafe(id); e != nil && e.Seat != 0 {
+		held = e.Seat
+	}
+	if seat == 0 {
+		seat = held
+	}
+	if seat == 0 {
+		if seat = h.freeSeatUnsafe(); seat == 0 {
+			return protocolErrorf(CodeTableFull, "no seat is open; join the waiting list")
+		}
+	} else if seat != held {
+		if err := h.checkSeatUnsafe(seat); err != nil {
+			return err
+		}
+	}
+	player := Player{ID: id, Seat: seat, Hand: []Card{}, IsConnected: true}
+	if err := h.bindAccountUnsafe(&player, name, buyIn); err != nil {
+		return err
+	}
+	h.leaveWaitlistUnsafe(id)
+	delete(h.restoredSeats, player.AccountID)
+	delete(h.gameState.Spectators, id)
+	h.gameState.Players[id] = player
+	h.playerReady[id] = false
+	h.emitEventUnsafe(GameEvent{Type: EventPlayerJoined, PlayerID: id, Name: player.Name, Seat: seat, Amount: player.Chips, Connected: true})
+	h.logUnsafe().Info("player sat down", "player", player.Name, "seat", seat, "chips", player.Chips)
+	return nil
+}
+
+// handleStandUp moves a seated player back to the spectators. Players dealt
+// into the running hand have to wait for it to end.
+func (h *Hub) handleStandUp(playerID string) error {
+	h.gameStateMutex.Lock()
+	defer h.gameStateMutex.Unlock()
+	p, ok := h.gameState.Players[playerID]
+	if !ok {
+		return protocolErrorf(CodeNotSeated, "not seated at this table")
+	}
+	if h.gameState.GameStarted && slices.Contains(h.gameState.PlayerOrder, playerID) {
+		return protocolErrorf(CodeHandInProgress, "you can stand up when the hand ends")
+	}
+	h.removePlayerUnsafe(playerID)
+	h.addSpectatorUnsafe(playerID, p.Name)
+	h.broadcastGameStateUnsafe()
+	return nil
+}
+
+// notifySeatOpenUnsafe tells spectators when the number of open seats went
+// up since the last broadcast.
+func (h *Hub) notifySeatOpenUnsafe() {
+	open := h.openSeatsUnsafe()
+	defer func() { h.lastOpenSeats = open }()
+	if open <= h.lastOpenSeats {
+		return
+	}
+	for id := range h.gameState.Spectators {
+		h.pushUnsafe(id, "seat_open", SeatOpenPayload{TableID: h.config.ID, OpenSeats: open})
+	}
+}
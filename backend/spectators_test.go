@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// watch connects a spectator client logged in as name and returns a reader
+// of its state stream, past the first snapshot.
+func watch(t *testing.T, h *Hub, id, name string) *streamReader {
+	t.Helper()
+	client := &Client{ID: id, hub: h, send: make(chan []byte, 256), accountName: name}
+	h.registerClient(client)
+	r := &streamReader{t: t, client: client}
+	r.drain()
+	return r
+}
+
+// stateOf converts the state a reader rebuilt back to a GameState.
+func stateOf(t *testing.T, r *streamReader) GameState {
+	t.Helper()
+	data, err := json.Marshal(r.state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s GameState
+	if err := json.Unmarshal(data, &s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSpectatorsSeeNoHoleCards(t *testing.T) {
+	h := newTestTable(t, "random")
+	alice := sitDown(t, h, "Alice", 1000)
+	bob := sitDown(t, h, "Bob", 1000)
+	spectator := watch(t, h, "zoe", "Zoe")
+	player := &streamReader{t: t, client: &Client{ID: alice, hub: h, send: make(chan []byte, 256)}}
+	h.gameStateMutex.Lock()
+	h.clients[alice] = player.client
+	h.gameStateMutex.Unlock()
+
+	dealHand(t, h, alice, bob)
+	spectator.drain()
+	player.drain()
+
+	watched := stateOf(t, spectator)
+	if len(watched.Players) != 2 {
+		t.Fatalf("the spectator sees %d players, want 2", len(watched.Players))
+	}
+	for id, p := range watched.Players {
+		if len(p.Hand) != 0 || p.AccountID != "" {
+			t.Errorf("the spectator sees %s's hand %v and account %q", p.Name, p.Hand, p.AccountID)
+		}
+		if _, seated := watched.Spectators[id]; seated {
+			t.Errorf("%s is listed as a spectator", p.Name)
+		}
+	}
+	if _, ok := watched.Spectators["zoe"]; !ok {
+		t.Error("the spectator is not listed")
+	}
+
+	seen := stateOf(t, player)
+	if got := seen.Players[alice].Hand; len(got) != 2 {
+		t.Errorf("Alice sees her hand as %v", got)
+	}
+	if got := seen.Players[bob].Hand; len(got) != 0 {
+		t.Errorf("Alice sees Bob's hand %v", got)
+	}
+}
+
+func TestSpectatorsCannotPlay(t *testing.T) {
+	h := newTestTable(t, "random")
+	alice := sitDown(t, h, "Alice", 1000)
+	watch(t, h, "zoe", "Zoe")
+
+	// Alice and a spectator are not enough for a hand
+	if err := h.handlePlayerReady(alice, true); err != nil {
+		t.Fatal(err)
+	}
+	checkProtocolError(t, "spectator ready", h.handlePlayerReady("zoe", true), CodeNotSeated)
+	if snapshot(h).GameStarted {
+		t.Fatal("a hand was dealt with a spectator")
+	}
+
+	bob := sitDown(t, h, "Bob", 1000)
+	dealHand(t, h, alice, bob)
+	payload, _ := json.Marshal(PlayerActionPayload{Action: "fold"})
+	checkProtocolError(t, "spectator action", h.handlePlayerAction("zoe", payload), CodeNotSeated)
+	checkProtocolError(t, "spectator standing up", h.handleStandUp("zoe"), CodeNotSeated)
+	checkProtocolError(t, "standing up during the hand", h.handleStandUp(alice), CodeHandInProgress)
+}
+
+func TestSpectatorSeatLimit(t *testing.T) {
+	h := newTestTable(t, "random")
+	h.gameStateMutex.Lock()
+	h.config.MaxSeats = 2
+	h.gameStateMutex.Unlock()
+	alice := sitDown(t, h, "Alice", 1000)
+	sitDown(t, h, "Bob", 1000)
+	if _, err := h.accounts.Register("Zoe", "test"); err != nil {
+		t.Fatal(err)
+	}
+	zoe := watch(t, h, "zoe", "Zoe")
+
+	h.gameStateMutex.Lock()
+	err := h.seatSpectatorUnsafe("zoe", "Zoe", 0, 1000)
+	h.gameStateMutex.Unlock()
+	checkProtocolError(t, "full table", err, CodeTableFull)
+	if a, table, ok := h.accounts.Lookup("Zoe"); !ok || table != "" || a.Bankroll != StartingBankroll {
+		t.Errorf("the refused seat took %d chips to table %q", StartingBankroll-a.Bankroll, table)
+	}
+
+	// Alice stands up and the spectator is told a seat is open
+	if err := h.handleStandUp(alice); err != nil {
+		t.Fatal(err)
+	}
+	var open *SeatOpenPayload
+	for len(zoe.client.send) > 0 {
+		var msg Message
+		if err := json.Unmarshal(<-zoe.client.send, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Type == "seat_open" {
+			open = new(SeatOpenPayload)
+			json.Unmarshal(msg.Payload, open)
+		}
+	}
+	if open == nil || open.OpenSeats != 1 {
+		t.Fatalf("the spectator was told %+v, want one open seat", open)
+	}
+	h.gameStateMutex.Lock()
+	err = h.seatSpectatorUnsafe("zoe", "Zoe", 0, 1000)
+	h.gameStateMutex.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := snapshot(h); len(s.Players) != 2 || s.Players["zoe"].Chips != 1000 {
+		t.Errorf("after Zoe sat down: %d players, Zoe has %d chips", len(s.Players), s.Players["zoe"].Chips)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// --- Server-Sent Events ---
+//
+// GET /api/tables/{id}/events is a read-only stream for viewers that cannot
+// use WebSockets. It sends two kinds of events:
+//
+//	event: state   the public table state (as GET /api/tables/{id}), after every change
+//	event: game    one GameEvent from the event log, hole cards removed
+//
+// Event ids count up from 1 on each stream, across both kinds. A client that
+// reconnects starts a new stream with a fresh state event, so there is no
+// resuming from Last-Event-ID.
+
+const (
+	sseBuffer    = 64
+	sseKeepalive = 15 * time.Second
+)
+
+// sseWatcher is one SSE connection. Like a Client, it is dropped when it
+// cannot keep up.
+type sseWatcher struct {
+	send      chan sseMessage
+	accountID string // of the session token the stream was opened with, if any
+}
+
+// sseMessage is an event waiting for its id, which the stream assigns as it
+// writes it.
+type sseMessage struct {
+	event string
+	data  []byte
+}
+
+func serveTableEvents(lobby *Lobby, w http.ResponseWriter, r *http.Request) {
+	hub := lobby.table(r.PathValue("id"))
+	if hub == nil {
+		writeAPIError(w, http.StatusNotFound, "table not found")
+		return
+	}
+	if hub.isClosed() {
+		writeAPIError(w, http.StatusServiceUnavailable, "server is restarting")
+		return
+	}
+	// The stream is public; a session token only ties it to an account, so
+	// that banning the account closes it
+	account, err := lobby.authenticate(r)
+	if errors.Is(err, errAccountBanned) {
+		writeAPIError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if err != nil && !errors.Is(err, errNoSession) {
+		writeAPIError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // keep nginx from buffering the stream
+	w.WriteHeader(http.StatusOK)
+
+	watcher := hub.addWatcher(account.ID)
+	defer hub.removeWatcher(watcher)
+
+	keepalive := time.NewTicker(sseKeepalive)
+	defer keepalive.Stop()
+	var lastID uint64
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case msg, ok := <-watcher.send:
+			if !ok {
+				return
+			}
+			lastID++
+			if _, err := w.Write(sseEvent(msg.event, lastID, msg.data)); err != nil {
+				return
+			}
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// addWatcher registers an SSE connection and queues the current state for it.
+func (h *Hub) addWatcher(accountID string) *sseWatcher {
+	h.gameStateMutex.Lock()
+	defer h.gameStateMutex.Unlock()
+	watcher := &sseWatcher{send: make(chan sseMessage, sseBuffer), accountID: accountID}
+	h.watchers[watcher] = struct{}{}
+	if data := h.publicStateDataUnsafe(); data != nil {
+		watcher.send <- sseMessage{event: "state", data: data}
+	}
+	return watcher
+}
+
+func (h *Hub) removeWatcher(watcher *sseWatcher) {
+	h.gameStateMutex.Lock()
+	defer h.gameStateMutex.Unlock()
+	if _, ok := h.watchers[watcher]; ok {
+		delete(h.watchers, watcher)
+		close(watcher.send)
+	}
+}
+
+// broadcastPublicStateUnsafe is called from broadcastGameStateUnsafe.
+func (h *Hub) broadcastPublicStateUnsafe() {
+	if len(h.watchers) == 0 {
+		return
+	}
+	if data := h.publicStateDataUnsafe(); data != nil {
+		h.sendWatchersUnsafe(sseMessage{event: "state", data: data})
+	}
+}
+
+// broadcastPublicEventUnsafe is called from emitEventUnsafe.
+func (h *Hub) broadcastPublicEventUnsafe(e GameEvent) {
+	if len(h.watchers) == 0 {
+		return
+	}
+	if e.Type == EventHoleCardsDealt {
+		e.Cards = nil
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		h.logUnsafe().Error("marshaling event failed", "err", err)
+		return
+	}
+	h.sendWatchersUnsafe(sseMessage{event: "game", data: data})
+}
+
+func (h *Hub) publicStateDataUnsafe() []byte {
+	data, err := json.Marshal(TableDetail{TableSummary: h.summaryUnsafe(), State: h.publicStateUnsafe()})
+	if err != nil {
+		h.logUnsafe().Error("marshaling public state failed", "err", err)
+		return nil
+	}
+	return data
+}
+
+func (h *Hub) sendWatchersUnsafe(msg sseMessage) {
+	for watcher := range h.watchers {
+		select {
+		case watcher.send <- msg:
+		default:
+			h.log.Warn("SSE watcher fell behind, closing stream")
+			metrics.droppedWatchers.inc(h.config.ID)
+			delete(h.watchers, watcher)
+			close(watcher.send)
+		}
+	}
+}
+
+// sseEvent formats one event. data is single-line JSON, so it needs no
+// splitting into several data: lines.
+func sseEvent(event string, id uint64, data []byte) []byte {
+	return fmt.Appendf(nil, "event: %s\nid: %d\ndata: %s\n\n", event, id, data)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSSEIDsCountUpAcrossEventKinds(t *testing.T) {
+	lobby := newTestLobby(t)
+	mux := http.NewServeMux()
+	registerAPI(mux, lobby)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(srv.URL + "/api/tables/" + defaultTableID + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	r := bufio.NewReader(resp.Body)
+	if line, err := r.ReadString('\n'); err != nil || line != "event: state\n" {
+		t.Fatalf("stream starts with %q, %v", line, err)
+	}
+	kinds := map[string]bool{"state": true}
+	var want uint64 = 1
+
+	h := lobby.table(defaultTableID)
+	alice := sitDown(t, h, "Alice", 1000)
+	bob := sitDown(t, h, "Bob", 1000)
+	dealHand(t, h, alice, bob)
+	for want <= 10 {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kind, ok := strings.CutPrefix(line, "event: "); ok {
+			kinds[strings.TrimSpace(kind)] = true
+		}
+		v, ok := strings.CutPrefix(line, "id: ")
+		if !ok {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSpace(v), 10, 64)
+		if err != nil || id != want {
+			t.Fatalf("event id %q, want %d", v, want)
+		}
+		want++
+	}
+	if !kinds["state"] || !kinds["game"] {
+		t.Errorf("stream sent %v, want state and game events", kinds)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// --- Versioned state stream ---
+//
+// A client gets the full state once, as a "game_state" message, and then a
+// "state_delta" message per change whose payload is an RFC 6902 JSON Patch
+// against the previous state. Both carry the stream's sequence number in the
+// envelope's "seq" field; a delta always has seq = previous seq + 1. A client
+// that sees a gap sends "resync" and gets a fresh full snapshot.
+
+// PatchOp is one JSON Patch operation. Only add, remove and replace are
+// produced.
+type PatchOp struct {
+	Op    string
+	Path  string
+	Value any
+}
+
+func (op PatchOp) MarshalJSON() ([]byte, error) {
+	if op.Op == "remove" {
+		return json.Marshal(struct {
+			Op   string `json:"op"`
+			Path string `json:"path"`
+		}{op.Op, op.Path})
+	}
+	return json.Marshal(struct {
+		Op    string `json:"op"`
+		Path  string `json:"path"`
+		Value any    `json:"value"`
+	}{op.Op, op.Path, op.Value})
+}
+
+// diffJSON appends the operations that turn a into b. Both are values as
+// produced by json.Unmarshal into an interface{}.
+func diffJSON(a, b any, path string, ops []PatchOp) []PatchOp {
+	switch av := a.(type) {
+	case map[string]any:
+		bv, ok := b.(map[string]any)
+		if !ok {
+			break
+		}
+		for k := range av {
+			if _, ok := bv[k]; !ok {
+				ops = append(ops, PatchOp{Op: "remove", Path: path + "/" + escapePointer(k)})
+			}
+		}
+		for k, v := range bv {
+			if old, ok := av[k]; ok {
+				ops = diffJSON(old, v, path+"/"+escapePointer(k), ops)
+			} else {
+				ops = append(ops, PatchOp{Op: "add", Path: path + "/" + escapePointer(k), Value: v})
+			}
+		}
+		return ops
+	case []any:
+		bv, ok := b.([]any)
+		if !ok {
+			break
+		}
+		return diffArray(av, bv, path, ops)
+	}
+	if !reflect.DeepEqual(a, b) {
+		ops = append(ops, PatchOp{Op: "replace", Path: path, Value: b})
+	}
+	return ops
+}
+
+// diffArray handles the common shapes cheaply: same length (element-wise),
+// and a window that dropped items from the front and appended at the end,
+// like the capped chat history. Anything else replaces the whole array.
+func diffArray(a, b []any, path string, ops []PatchOp) []PatchOp {
+	if len(a) == len(b) {
+		changed := 0
+		for i := range a {
+			if !reflect.DeepEqual(a[i], b[i]) {
+				changed++
+			}
+		}
+		if changed*2 <= len(a) {
+			for i := range a {
+				ops = diffJSON(a[i], b[i], path+"/"+strconv.Itoa(i), ops)
+			}
+			return ops
+		}
+	}
+	for dropped := 0; dropped <= len(a); dropped++ {
+		kept := a[dropped:]
+		if len(kept) > len(b) || dropped+len(b)-len(kept) >= len(b) {
+			continue
+		}
+		if !reflect.DeepEqual(kept, b[:len(kept)]) {
+			continue
+		}
+		for i := 0; i < dropped; i++ {
+			ops = append(ops, PatchOp{Op: "remove", Path: path + "/0"})
+		}
+		for _, v := range b[len(kept):] {
+			ops = append(ops, PatchOp{Op: "add", Path: path + "/-", Value: v})
+		}
+		return ops
+	}
+	return append(ops, PatchOp{Op: "replace", Path: path, Value: b})
+}
+
+func escapePointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
+
+// publicView is the view key of spectators and SSE watchers. Each seated
+// player has their own view, keyed by their ID, which adds their hole cards.
+const publicView = ""
+
+// stateView is one viewer's stream: its own seq and the last state it was sent.
+type stateView struct {
+	seq     uint64
+	state   any    // decoded, for diffing
+	payload []byte // encoded, for snapshots
+	delta   []byte // message for the latest change, nil if nothing changed
+}
+
+// viewKeyUnsafe picks the view a client is streamed.
+func (h *Hub) viewKeyUnsafe(clientID string) string {
+	if _, seated := h.gameState.Players[clientID]; seated {
+		return clientID
+	}
+	return publicView
+}
+
+// playerViewUnsafe is the public state plus the player's own hand.
+func (h *Hub) playerViewUnsafe(playerID string, public GameState) GameState {
+	state := public
+	state.Players = maps.Clone(public.Players)
+	p := state.Players[playerID]
+	p.Hand = h.gameState.Players[playerID].Hand
+	state.Players[playerID] = p
+	return state
+}
+
+// updateViewUnsafe diffs state against what the view was last sent.
+func (h *Hub) updateViewUnsafe(key string, state GameState) *stateView {
+	v := h.views[key]
+	if v == nil {
+		v = &stateView{}
+		h.views[key] = v
+	}
+	payload, err := json.Marshal(state)
+	if err != nil {
+		h.logUnsafe().Error("marshaling game state failed", "err", err)
+		return v
+	}
+	var decoded any
+	if err := json.Unmarshal(payload, &decoded); err != nil {
+		h.logUnsafe().Error("decoding game state failed", "err", err)
+		return v
+	}
+
+	v.delta = nil
+	if v.state == nil {
+		v.seq++
+	} else if ops := diffJSON(v.state, decoded, "", nil); len(ops) > 0 {
+		v.seq++
+		opsBytes, err := json.Marshal(ops)
+		if err != nil {
+			h.logUnsafe().Error("marshaling state delta failed", "err", err)
+			return v
+		}
+		v.delta, err = json.Marshal(Message{Type: "state_delta", Seq: v.seq, Payload: opsBytes})
+		if err != nil {
+			slog.Error("marshaling message failed", "err", err)
+		}
+	}
+	v.state, v.payload = decoded, payload
+	return v
+}
+
+// streamStateUnsafe sends the current state to every client: a delta to the
+// clients that are in sync with their view, a full snapshot to the others.
+func (h *Hub) streamStateUnsafe() {
+	public := h.publicStateUnsafe()
+	updated := map[string]*stateView{publicView: h.updateViewUnsafe(publicView, public)}
+	for id, client := range h.clients {
+		key := h.viewKeyUnsafe(id)
+		v := updated[key]
+		if v == nil {
+			v = h.updateViewUnsafe(key, h.playerViewUnsafe(key, public))
+			updated[key] = v
+		}
+		msg := v.delta
+		if !client.synced || client.view != key {
+			// New connections, and anyone who just sat down or stood up
+			msg = snapshotMessage(v)
+			client.view = key
+		}
+		if msg == nil {
+			continue
+		}
+		if h.sendUnsafe(client, msg) {
+			client.synced = true
+		}
+	}
+	for key := range h.views {
+		if _, ok := updated[key]; !ok {
+			delete(h.views, key)
+		}
+	}
+}
+
+func snapshotMessage(v *stateView) []byte {
+	msg, err := json.Marshal(Message{Type: "game_state", Seq: v.seq, Payload: v.payload})
+	if err != nil {
+		slog.Error("marshaling message failed", "err", err)
+		return nil
+	}
+	return msg
+}
+
+// sendUnsafe queues msg for the client, dropping clients that cannot keep up.
+func (h *Hub) sendUnsafe(client *Client, msg []byte) bool {
+	select {
+	case client.send <- msg:
+		return true
+	default:
+		// Client channel is full, close connection
+		h.log.Warn("client channel full, closing connection", "client", client.ID)
+		metrics.droppedClients.inc(h.config.ID)
+		close(client.send)
+		delete(h.clients, client.ID)
+		return false
+	}
+}
+
+// handleResync sends a client a full snapshot of its view after it detected
+// a gap.
+func (h *Hub) handleResync(client *Client) {
+	h.gameStateMutex.Lock()
+	defer h.gameStateMutex.Unlock()
+	v := h.views[client.view]
+	if _, ok := h.clients[client.ID]; !ok || v == nil || v.payload == nil {
+		return
+	}
+	if msg := snapshotMessage(v); msg != nil {
+		client.synced = h.sendUnsafe(client, msg)
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// applyPatch applies an RFC 6902 patch of add, remove and replace
+// operations to doc, a value as produced by json.Unmarshal.
+func applyPatch(t *testing.T, doc any, patch []byte) any {
+	t.Helper()
+	var ops []struct {
+		Op    string `json:"op"`
+		Path  string `json:"path"`
+		Value any    `json:"value"`
+	}
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		t.Fatalf("%s: %v", patch, err)
+	}
+	for _, op := range ops {
+		doc = applyOp(t, doc, op.Op, op.Path, op.Value)
+	}
+	return doc
+}
+
+func applyOp(t *testing.T, doc any, op, path string, value any) any {
+	t.Helper()
+	if path == "" {
+		if op != "replace" {
+			t.Fatalf("%s of the whole document", op)
+		}
+		return value
+	}
+	tokens := strings.Split(path[1:], "/")
+	for i, tok := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(tok, "~1", "/"), "~0", "~")
+	}
+	last := tokens[len(tokens)-1]
+	parent := doc
+	for _, tok := range tokens[:len(tokens)-1] {
+		switch p := parent.(type) {
+		case map[string]any:
+			parent = p[tok]
+		case []any:
+			i, err := strconv.Atoi(tok)
+			if err != nil || i >= len(p) {
+				t.Fatalf("%s: bad index %q", path, tok)
+			}
+			parent = p[i]
+		default:
+			t.Fatalf("%s: %q is not in a container", path, tok)
+		}
+	}
+
+	var updated any
+	switch p := parent.(type) {
+	case map[string]any:
+		if _, ok := p[last]; !ok && op != "add" {
+			t.Fatalf("%s %s: no such key", op, path)
+		}
+		if op == "remove" {
+			delete(p, last)
+		} else {
+			p[last] = value
+		}
+		return doc
+	case []any:
+		i := len(p)
+		if last != "-" {
+			var err error
+			if i, err = strconv.Atoi(last); err != nil || i > len(p) || (op != "add" && i == len(p)) {
+				t.Fatalf("%s %s: bad index", op, path)
+			}
+		} else if op != "add" {
+			t.Fatalf("%s %s", op, path)
+		}
+		switch op {
+		case "add":
+			updated = append(p[:i:i], append([]any{value}, p[i:]...)...)
+		case "remove":
+			updated = append(p[:i:i], p[i+1:]...)
+		default:
+			p[i] = value
+			return doc
+		}
+	default:
+		t.Fatalf("%s: parent is not a container", path)
+	}
+	// Arrays change length, so the new slice goes back into the grandparent
+	return applyOp(t, doc, "replace", path[:strings.LastIndex(path, "/")], updated)
+}
+
+func decodeJSON(t *testing.T, s string) any {
+	t.Helper()
+	var v any
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestDiffJSONPatchRebuildsNewState(t *testing.T) {
+	chat := func(from, to int) string {
+		var items []string
+		for i := from; i < to; i++ {
+			items = append(items, `{"text":"message `+strconv.Itoa(i)+`"}`)
+		}
+		return `{"chat":[` + strings.Join(items, ",") + `]}`
+	}
+	tests := []struct {
+		name    string
+		old     string
+		new     string
+		wantOps []string // op and path of each operation, in order; nil to skip
+	}{
+		{"unchanged", `{"a":1,"b":[1,2]}`, `{"a":1,"b":[1,2]}`, []string{}},
+		{"key added", `{"a":1}`, `{"a":1,"b":{"c":2}}`, []string{"add /b"}},
+		{"key removed", `{"a":1,"b":2}`, `{"a":1}`, []string{"remove /b"}},
+		{"value replaced", `{"players":{"p1":{"chips":100}}}`, `{"players":{"p1":{"chips":80}}}`, []string{"replace /players/p1/chips"}},
+		{"tilde and slash in keys", `{"a/b":1,"c~d":{"~1":2}}`, `{"a/b":3,"c~d":{"~1":4,"e/~f":5}}`, nil},
+		{"escaped paths", `{"a/b":1}`, `{"a/b":2,"c~d":3}`, nil},
+		{"type change", `{"a":{"b":1}}`, `{"a":[1]}`, []string{"replace /a"}},
+		{"null to value", `{"a":null}`, `{"a":[]}`, []string{"replace /a"}},
+		{"element changed", `{"a":[1,2,3,4]}`, `{"a":[1,9,3,4]}`, []string{"replace /a/1"}},
+		{"window moved", chat(0, 50), chat(2, 52), []string{"remove /chat/0", "remove /chat/0", "add /chat/-", "add /chat/-"}},
+		{"appended", chat(0, 3), chat(0, 5), []string{"add /chat/-", "add /chat/-"}},
+		{"emptied", chat(0, 3), chat(0, 0), []string{"replace /chat"}},
+		{"mostly changed", `{"a":[1,2,3,4]}`, `{"a":[5,6,7,4]}`, []string{"replace /a"}},
+		{"reordered", `{"a":[1,2,3]}`, `{"a":[3,2,1,0]}`, []string{"replace /a"}},
+		{"window dropped too much", chat(0, 4), chat(3, 6), []string{"replace /chat"}},
+	}
+	for _, tt := range tests {
+		old, want := decodeJSON(t, tt.old), decodeJSON(t, tt.new)
+		ops := diffJSON(old, want, "", nil)
+		patch, err := json.Marshal(ops)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if tt.wantOps != nil {
+			got := []string{}
+			for _, op := range ops {
+				got = append(got, op.Op+" "+op.Path)
+			}
+			if !reflect.DeepEqual(got, tt.wantOps) {
+				t.Errorf("%s: ops %v, want %v", tt.name, got, tt.wantOps)
+			}
+		}
+		if got := applyPatch(t, decodeJSON(t, tt.old), patch); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: patch %s turns %s into %v", tt.name, patch, tt.old, got)
+		}
+	}
+}
+
+// streamReader follows a client's state stream the way a browser does.
+type streamReader struct {
+	t      *testing.T
+	client *Client
+	seq    uint64
+	state  any
+	deltas int
+}
+
+// next returns the type of the next state message after applying it.
+func (r *streamReader) next() string {
+	r.t.Helper()
+	for {
+		select {
+		case data := <-r.client.send:
+			if typ := r.apply(data); typ != "" {
+				return typ
+			}
+		default:
+			r.t.Fatal("no state message was sent")
+		}
+	}
+}
+
+// drain applies every queued state message.
+func (r *streamReader) drain() {
+	r.t.Helper()
+	for len(r.client.send) > 0 {
+		r.apply(<-r.client.send)
+	}
+}
+
+// apply follows one message and returns its type if it is a state message.
+func (r *streamReader) apply(data []byte) string {
+	r.t.Helper()
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		r.t.Fatal(err)
+	}
+	switch msg.Type {
+	case "game_state":
+		r.seq, r.state = msg.Seq, decodeJSON(r.t, string(msg.Payload))
+	case "state_delta":
+		if msg.Seq != r.seq+1 {
+			r.t.Fatalf("delta seq %d after %d", msg.Seq, r.seq)
+		}
+		r.seq, r.state = msg.Seq, applyPatch(r.t, r.state, msg.Payload)
+		r.deltas++
+	default:
+		return ""
+	}
+	return msg.Type
+}
+
+// check compares the state the client rebuilt with what the table holds
+// for its view.
+func (r *streamReader) check(h *Hub, stage string) {
+	r.t.Helper()
+	h.gameStateMutex.RLock()
+	v := h.views[r.client.view]
+	want, seq := decodeJSON(r.t, string(v.payload)), v.seq
+	h.gameStateMutex.RUnlock()
+	if r.seq != seq || !reflect.DeepEqual(r.state, want) {
+		r.t.Errorf("%s: client has seq %d, table %d; states equal: %v", stage, r.seq, seq, reflect.DeepEqual(r.state, want))
+	}
+}
+
+func TestStateStreamDeltasFollowTheTable(t *testing.T) {
+	h := newTestTable(t, "random")
+	if _, err := h.accounts.Register("Zoe", "test"); err != nil {
+		t.Fatal(err)
+	}
+	client := &Client{ID: "zoe", hub: h, send: make(chan []byte, 256), accountName: "Zoe"}
+	h.registerClient(client)
+	r := &streamReader{t: t, client: client}
+	if got := r.next(); got != "game_state" {
+		t.Fatalf("a new client got %s first, want game_state", got)
+	}
+	r.drain()
+	r.check(h, "after joining")
+
+	// Deltas while others sit down and play, including the capped chat window
+	alice := sitDown(t, h, "Alice", 1000)
+	bob := sitDown(t, h, "Bob", 1000)
+	dealHand(t, h, alice, bob)
+	r.drain()
+	deltas := r.deltas
+	for i := range 55 {
+		h.gameStateMutex.Lock()
+		h.addSystemChatMessage("message " + strconv.Itoa(i))
+		h.broadcastGameStateUnsafe()
+		h.gameStateMutex.Unlock()
+		r.drain()
+	}
+	if r.deltas-deltas != 55 {
+		t.Errorf("55 chat messages sent %d deltas", r.deltas-deltas)
+	}
+	act(t, h, alice, "call", 0)
+	r.drain()
+	r.check(h, "during the hand")
+
+	// Seated players get their own view, starting with a snapshot
+	if _, err := h.forceEndHand("test"); err != nil {
+		t.Fatal(err)
+	}
+	r.drain()
+	h.gameStateMutex.Lock()
+	err := h.seatSpectatorUnsafe(client.ID, "Zoe", 0, 1000)
+	h.broadcastGameStateUnsafe()
+	h.gameStateMutex.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.next(); got != "game_state" || client.view != client.ID {
+		t.Errorf("sitting down sent %s on view %q, want a game_state on the player's view", got, client.view)
+	}
+	r.drain()
+	r.check(h, "after sitting down")
+
+	if err := h.handleStandUp(client.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.next(); got != "game_state" || client.view != publicView {
+		t.Errorf("standing up sent %s on view %q, want a game_state on the public view", got, client.view)
+	}
+	r.drain()
+	r.check(h, "after standing up")
+
+	// A client that lost track gets the current snapshot
+	r.state = nil
+	h.handleResync(client)
+	if got := r.next(); got != "game_state" {
+		t.Errorf("resync sent %s, want game_state", got)
+	}
+	r.check(h, "after the resync")
+	if len(client.send) != 0 {
+		t.Errorf("resync sent %d more messages", len(client.send))
+	}
+}
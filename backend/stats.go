@@ -0,0 +1,263 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"sync"
+)
+
+// --- Player statistics ---
+//
+// StatsBook keeps running totals per account, built from every stored hand
+// history at startup and brought up to date as each hand is saved. The rates
+// are worked out from the totals when asked for:
+//
+//	VPIP      hands where the player put chips in before the flop by choice
+//	PFR       hands where the player raised before the flop
+//	3-bet     re-raises before the flop, of the times the player faced one raise
+//	AF        bets and raises after the flop per call
+//	WTSD      showdowns, of the flops the player saw
+//	W$SD      showdowns won
+//	bb/100    big blinds won per 100 hands
+//
+// Tables with hud set also attach a short summary to each seated player in
+// game_state.
+
+// statTotals are the counts the statistics are worked out from.
+type statTotals struct {
+	name            string
+	hands           int
+	vpip            int
+	pfr             int
+	threeBetChances int
+	threeBets       int
+	aggressive      int // bets and raises after the flop
+	calls           int // calls after the flop
+	sawFlop         int
+	showdowns       int
+	wonShowdowns    int
+	net             int
+	netBB           float64
+}
+
+// PlayerStats is a player's statistics, from GET /api/players/{id}/stats.
+// Rates are percentages except AF.
+type PlayerStats struct {
+	AccountID string  `json:"accountId"`
+	Name      string  `json:"name"`
+	Hands     int     `json:"hands"`
+	VPIP      float64 `json:"vpip"`
+	PFR       float64 `json:"pfr"`
+	ThreeBet  float64 `json:"threeBet"`
+	AF        float64 `json:"af"`
+	WTSD      float64 `json:"wtsd"`
+	WSD       float64 `json:"wsd"`
+	Net       int     `json:"net"` // chips won less chips lost
+	BBPer100  float64 `json:"bbPer100"`
+}
+
+// HUDStats is the part of PlayerStats shown next to a seat.
+type HUDStats struct {
+	Hands    int     `json:"hands"`
+	VPIP     float64 `json:"vpip"`
+	PFR      float64 `json:"pfr"`
+	ThreeBet float64 `json:"threeBet"`
+	AF       float64 `json:"af"`
+	WTSD     float64 `json:"wtsd"`
+}
+
+type StatsBook struct {
+	mu     sync.Mutex
+	totals map[string]*statTotals // account ID -> totals
+}
+
+func newStatsBook() *StatsBook {
+	return &StatsBook{totals: make(map[string]*statTotals)}
+}
+
+// replayHandHistories passes every stored hand to each recorder, to build the
+// statistics and leaderboards at startup. Hands from before hand histories
+// recorded account IDs are matched to accounts by name.
+func replayHandHistories(store Store, accounts *AccountBook, recorders ...func(*HandHistory)) error {
+	return store.EachHandHistory(func(hist *HandHistory) {
+		for i, hp := range hist.Players {
+			if hp.AccountID == "" {
+				if a, _, ok := accounts.Lookup(hp.Name); ok {
+					hist.Players[i].AccountID = a.ID
+				}
+			}
+		}
+		for _, record := range recorders {
+			record(hist)
+		}
+	})
+}
+
+// Record adds a finished hand to the totals of the accounts that played it.
+// Cancelled hands were refunded and are left out.
+func (s *StatsBook) Record(hist *HandHistory) {
+	if slices.ContainsFunc(hist.Events, func(e GameEvent) bool { return e.Type == EventHandCancelled }) {
+		return
+	}
+	showdown := slices.ContainsFunc(hist.Events, func(e GameEvent) bool { return e.Type == EventShowdown })
+	bigBlind := hist.BigBlind
+	if bigBlind == 0 {
+		// Recorded before hands kept the big blind
+		for _, e := range hist.Events {
+			if e.Type == EventBlindPosted {
+				bigBlind = max(bigBlind, e.LastBet)
+			}
+		}
+	}
+
+	// Walk the actions once, noting what each player did
+	type line struct {
+		vpip, pfr, threeBetChance, threeBet, foldedPreflop, folded bool
+		aggressive, calls                                          int
+	}
+	lines := make(map[string]*line, len(hist.Players))
+	for _, hp := range hist.Players {
+		lines[hp.ID] = &line{}
+	}
+	raises := 0
+	for _, a := range hist.Actions {
+		l := lines[a.PlayerID]
+		if l == nil {
+			continue
+		}
+		preflop := a.Phase == "pre-flop"
+		switch a.Action {
+		case "fold":
+			l.folded = true
+			l.foldedPreflop = l.foldedPreflop || preflop
+		case "call":
+			if preflop {
+				l.vpip = true
+			} else {
+				l.calls++
+			}
+		case "raise":
+			if preflop {
+				l.vpip, l.pfr = true, true
+			} else {
+				l.aggressive++
+			}
+		}
+		if preflop && (a.Action == "fold" || a.Action == "call" || a.Action == "raise") {
+			if raises == 1 && !l.threeBetChance {
+				l.threeBetChance = true
+				l.threeBet = a.Action == "raise"
+			}
+			if a.Action == "raise" {
+				raises++
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, hp := range hist.Players {
+		if hp.AccountID == "" {
+			continue
+		}
+		t := s.totals[hp.AccountID]
+		if t == nil {
+			t = &statTotals{}
+			s.totals[hp.AccountID] = t
+		}
+		l := lines[hp.ID]
+		t.name = hp.Name
+		t.hands++
+		t.vpip += count(l.vpip)
+		t.pfr += count(l.pfr)
+		t.threeBetChances += count(l.threeBetChance)
+		t.threeBets += count(l.threeBet)
+		t.aggressive += l.aggressive
+		t.calls += l.calls
+		if len(hist.Board) >= 3 && !l.foldedPreflop {
+			t.sawFlop++
+			if showdown && !l.folded {
+				t.showdowns++
+				t.wonShowdowns += count(slices.Contains(hist.Winners, hp.ID))
+			}
+		}
+		net := hp.EndingChips - hp.StartingChips
+		t.net += net
+		if bigBlind > 0 {
+			t.netBB += float64(net) / float64(bigBlind)
+		}
+	}
+}
+
+// Player returns an account's statistics; an account that has not played
+// gets zeroes.
+func (s *StatsBook) Player(accountID string) PlayerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.totals[accountID]
+	if t == nil {
+		return PlayerStats{AccountID: accountID}
+	}
+	return PlayerStats{
+		AccountID: accountID,
+		Name:      t.name,
+		Hands:     t.hands,
+		VPIP:      percent(t.vpip, t.hands),
+		PFR:       percent(t.pfr, t.hands),
+		ThreeBet:  percent(t.threeBets, t.threeBetChances),
+		AF:        ratio(t.aggressive, t.calls),
+		WTSD:      percent(t.showdowns, t.sawFlop),
+		WSD:       percent(t.wonShowdowns, t.showdowns),
+		Net:       t.net,
+		BBPer100:  round1(t.netBB * 100 / float64(max(t.hands, 1))),
+	}
+}
+
+// HUD returns the seat summary for an account.
+func (s *StatsBook) HUD(accountID string) *HUDStats {
+	p := s.Player(accountID)
+	return &HUDStats{Hands: p.Hands, VPIP: p.VPIP, PFR: p.PFR, ThreeBet: p.ThreeBet, AF: p.AF, WTSD: p.WTSD}
+}
+
+// hudUnsafe is the seat summary for an account, or nil if the table has no
+// HUD.
+func (h *Hub) hudUnsafe(accountID string) *HUDStats {
+	if !h.config.HUD || accountID == "" {
+		return nil
+	}
+	return h.stats.HUD(accountID)
+}
+
+// refreshHUDUnsafe brings every seat's summary up to date after a hand.
+func (h *Hub) refreshHUDUnsafe() {
+	if !h.config.HUD {
+		return
+	}
+	for id, p := range h.gameState.Players {
+		p.HUD = h.hudUnsafe(p.AccountID)
+		h.gameState.Players[id] = p
+	}
+}
+
+func count(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func percent(n, of int) float64 {
+	if of == 0 {
+		return 0
+	}
+	return round1(float64(n) * 100 / float64(of))
+}
+
+// ratio is n/of, or n when of is zero.
+func ratio(n, of int) float64 {
+	return round1(float64(n) / float64(max(of, 1)))
+}
+
+func round1(v float64) float64 {
+	return math.Round(v*10) / 10
+}
@@ -0,0 +1,104 @@
+package main
+
+import "testing"
+
+// fixtureHand builds the history of a hand at 10/20 blinds.
+func fixtureHand(players []HandPlayer, actions []HandAction, board string, winners []string, events ...GameEvent) *HandHistory {
+	hist := &HandHistory{BigBlind: 20, Players: players, Actions: actions, Winners: winners, Events: events}
+	if board != "" {
+		c, err := parseCards(board)
+		if err != nil {
+			panic(err)
+		}
+		hist.Board = c
+	}
+	return hist
+}
+
+func TestStatsBookRecord(t *testing.T) {
+	// Alice opens, Bob flats, Carol 3-bets from the small blind and Dave
+	// folds his big blind; Alice and Bob call, Bob folds the flop and Alice
+	// beats Carol at showdown
+	players := func(net ...int) []HandPlayer {
+		names := []string{"Alice", "Bob", "Carol", "Dave"}
+		hp := make([]HandPlayer, len(names))
+		for i, name := range names {
+			hp[i] = HandPlayer{ID: "p" + name, AccountID: "a" + name, Name: name, StartingChips: 2000, EndingChips: 2000 + net[i]}
+		}
+		return hp
+	}
+	showdown := fixtureHand(players(700, -200, -480, -20), []HandAction{
+		{Phase: "pre-flop", PlayerID: "pCarol", Action: "small_blind", Amount: 10},
+		{Phase: "pre-flop", PlayerID: "pDave", Action: "big_blind", Amount: 20},
+		{Phase: "pre-flop", PlayerID: "pAlice", Action: "raise", Amount: 60},
+		{Phase: "pre-flop", PlayerID: "pBob", Action: "call", Amount: 60},
+		{Phase: "pre-flop", PlayerID: "pCarol", Action: "raise", Amount: 200},
+		{Phase: "pre-flop", PlayerID: "pDave", Action: "fold"},
+		{Phase: "pre-flop", PlayerID: "pAlice", Action: "call", Amount: 140},
+		{Phase: "pre-flop", PlayerID: "pBob", Action: "call", Amount: 140},
+		{Phase: "flop", PlayerID: "pCarol", Action: "raise", Amount: 100},
+		{Phase: "flop", PlayerID: "pAlice", Action: "call", Amount: 100},
+		{Phase: "flop", PlayerID: "pBob", Action: "fold"},
+		{Phase: "turn", PlayerID: "pCarol", Action: "check"},
+		{Phase: "turn", PlayerID: "pAlice", Action: "raise", Amount: 180},
+		{Phase: "turn", PlayerID: "pCarol", Action: "call", Amount: 180},
+		{Phase: "river", PlayerID: "pCarol", Action: "check"},
+		{Phase: "river", PlayerID: "pAlice", Action: "check"},
+	}, "As Kd 7c 2h 3s", []string{"pAlice"}, GameEvent{Type: EventShowdown})
+
+	// Alice raises and everyone folds
+	walk := fixtureHand(players(30, 0, -10, -20), []HandAction{
+		{Phase: "pre-flop", PlayerID: "pCarol", Action: "small_blind", Amount: 10},
+		{Phase: "pre-flop", PlayerID: "pDave", Action: "big_blind", Amount: 20},
+		{Phase: "pre-flop", PlayerID: "pAlice", Action: "raise", Amount: 60},
+		{Phase: "pre-flop", PlayerID: "pBob", Action: "fold"},
+		{Phase: "pre-flop", PlayerID: "pCarol", Action: "fold"},
+		{Phase: "pre-flop", PlayerID: "pDave", Action: "fold"},
+	}, "", []string{"pAlice"})
+
+	// A cancelled hand was refunded and does not count
+	cancelled := fixtureHand(players(0, 0, 0, 0), []HandAction{
+		{Phase: "pre-flop", PlayerID: "pAlice", Action: "raise", Amount: 60},
+		{Phase: "pre-flop", PlayerID: "pBob", Action: "raise", Amount: 200},
+	}, "", nil, GameEvent{Type: EventHandCancelled})
+
+	book := newStatsBook()
+	for _, hist := range []*HandHistory{showdown, walk, cancelled} {
+		book.Record(hist)
+	}
+
+	tests := []struct {
+		account string
+		want    PlayerStats
+	}{
+		// Alice: in both pots by raising, never faced a single raise first;
+		// one flop call and one turn raise; won the only showdown she saw
+		{"aAlice", PlayerStats{Hands: 2, VPIP: 100, PFR: 100, ThreeBet: 0, AF: 1, WTSD: 100, WSD: 100, Net: 730, BBPer100: 1825}},
+		// Bob: flatted one raise and folded to another; folded the flop
+		{"aBob", PlayerStats{Hands: 2, VPIP: 50, PFR: 0, ThreeBet: 0, AF: 0, WTSD: 0, WSD: 0, Net: -200, BBPer100: -500}},
+		// Carol: 3-bet once in two chances; bet the flop, called the turn
+		{"aCarol", PlayerStats{Hands: 2, VPIP: 50, PFR: 50, ThreeBet: 50, AF: 1, WTSD: 100, WSD: 0, Net: -490, BBPer100: -1225}},
+		// Dave: only faced a 3-bet in the first hand, one raise in the second
+		{"aDave", PlayerStats{Hands: 2, VPIP: 0, PFR: 0, ThreeBet: 0, AF: 0, WTSD: 0, WSD: 0, Net: -40, BBPer100: -100}},
+	}
+	for _, tt := range tests {
+		got := book.Player(tt.account)
+		tt.want.AccountID, tt.want.Name = tt.account, tt.account[1:]
+		if got != tt.want {
+			t.Errorf("%s:\n got %+v\nwant %+v", tt.account, got, tt.want)
+		}
+	}
+
+	book.mu.Lock()
+	chances := map[string]int{"aAlice": 0, "aBob": 2, "aCarol": 2, "aDave": 1}
+	for account, want := range chances {
+		if got := book.totals[account].threeBetChances; got != want {
+			t.Errorf("%s had %d 3-bet chances, want %d", account, got, want)
+		}
+	}
+	book.mu.Unlock()
+
+	if got := book.Player("nobody"); got.Hands != 0 || got.VPIP != 0 {
+		t.Errorf("an account without hands has %+v", got)
+	}
+}
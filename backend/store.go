@@ -0,0 +1,500 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var errNotFound = errors.New("not found")
+
+// Store persists everything that has to survive a restart: accounts and
+// their chip balances, table configurations and hand histories.
+type Store interface {
+	LoadAccounts() ([]Account, error)
+	SaveAccount(Account) error
+	LoadTables() ([]TableConfig, error)
+	SaveTable(TableConfig) error
+	SaveHandHistory(*HandHistory) error
+	// LoadHandHistories returns up to limit of the most recent hands of a
+	// table, oldest first.
+	LoadHandHistories(tableID string, limit int) ([]*HandHistory, error)
+	HandHistory(id string) (*HandHistory, error)
+	// EachHandHistory calls fn with every stored hand of every table,
+	// oldest first.
+	EachHandHistory(fn func(*HandHistory)) error
+	// SaveCheckpoint records the tables at shutdown; nil clears it.
+	SaveCheckpoint([]TableCheckpoint) error
+	LoadCheckpoint() ([]TableCheckpoint, error)
+	// AppendAudit records an operator action, see admin.go. LoadAudit
+	// returns up to limit of the most recent entries, oldest first.
+	AppendAudit(AuditEntry) error
+	LoadAudit(limit int) ([]AuditEntry, error)
+	// AppendLedger records a chip movement, see ledger.go. LoadLedger
+	// returns up to limit of the most recent entries touching account,
+	// oldest first; account "" matches every entry and limit 0 returns all.
+	AppendLedger(LedgerEntry) error
+	LoadLedger(account string, limit int) ([]LedgerEntry, error)
+	// SessionKey returns the secret session tokens are signed with,
+	// creating it on first use so sessions survive a restart.
+	SessionKey() ([]byte, error)
+	Close() error
+}
+
+// fileStore keeps tables in a small JSON file that is rewritten atomically,
+// and accounts and hand histories in append-only JSON Lines files. An account
+// is appended whenever it changes and the latest line wins; the file is
+// compacted when it opens and when stale lines outnumber live ones:
+//
+//	<dir>/accounts.jsonl
+//	<dir>/tables.json
+//	<dir>/hands.jsonl
+//	<dir>/audit.jsonl
+//	<dir>/ledger.jsonl
+//	<dir>/session.key
+//	<dir>/checkpoint.json   only between a shutdown and the next boot
+//
+// They hold password hashes and everyone's hole cards, so all of them are
+// readable by the owner only.
+type fileStore struct {
+	mu       sync.Mutex
+	dir      string
+	accounts map[string]Account
+	accLog   *os.File
+	accLines int // lines in accounts.jsonl, live and stale
+	tables   map[string]TableConfig
+	hands    *os.File
+	handAt   map[string]int64 // hand ID -> offset in hands.jsonl
+	handsEnd int64
+	audit    *os.File
+	ledger   *os.File
+}
+
+func openFileStore(dir string) (*fileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &fileStore{
+		dir:      dir,
+		accounts: make(map[string]Account),
+		tables:   make(map[string]TableConfig),
+		handAt:   make(map[string]int64),
+	}
+	if err := s.loadAccounts(); err != nil {
+		return nil, err
+	}
+	if err := readJSONFile(filepath.Join(dir, "tables.json"), &s.tables); err != nil {
+		s.accLog.Close()
+		return nil, err
+	}
+	hands, err := openDataFile(filepath.Join(dir, "hands.jsonl"), os.O_RDWR|os.O_CREATE)
+	if err != nil {
+		s.accLog.Close()
+		return nil, err
+	}
+	s.hands = hands
+	if err := s.indexHands(); err != nil {
+		s.accLog.Close()
+		hands.Close()
+		return nil, err
+	}
+	audit, err := openDataFile(filepath.Join(dir, "audit.jsonl"), os.O_RDWR|os.O_CREATE|os.O_APPEND)
+	if err != nil {
+		s.accLog.Close()
+		hands.Close()
+		return nil, err
+	}
+	s.audit = audit
+	ledger, err := openDataFile(filepath.Join(dir, "ledger.jsonl"), os.O_RDWR|os.O_CREATE|os.O_APPEND)
+	if err != nil {
+		s.accLog.Close()
+		hands.Close()
+		audit.Close()
+		return nil, err
+	}
+	s.ledger = ledger
+	return s, nil
+}
+
+// loadAccounts replays accounts.jsonl, skipping a torn last line from a
+// crash, and compacts it. Accounts from the accounts.json of older versions
+// are carried over and the old file is removed.
+func (s *fileStore) loadAccounts() error {
+	legacy := filepath.Join(s.dir, "accounts.json")
+	if err := readJSONFile(legacy, &s.accounts); err != nil {
+		return err
+	}
+	f, err := os.Open(filepath.Join(s.dir, "accounts.jsonl"))
+	if err == nil {
+		r := bufio.NewReader(f)
+		for {
+			line, err := r.ReadBytes('\n')
+			var a Account
+			if len(line) > 0 && line[len(line)-1] == '\n' && json.Unmarshal(line, &a) == nil && a.ID != "" {
+				s.accounts[a.ID] = a
+			}
+			if err != nil {
+				break
+			}
+		}
+		f.Close()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := s.compactAccounts(); err != nil {
+		return err
+	}
+	if err := os.Remove(legacy); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// compactAccounts rewrites accounts.jsonl atomically with one line per
+// account and reopens it for appending.
+func (s *fileStore) compactAccounts() error {
+	var buf bytes.Buffer
+	for _, a := range s.accounts {
+		line, err := json.Marshal(a)
+		if err != nil {
+			return err
+		}
+		buf.Write(append(line, '\n'))
+	}
+	path := filepath.Join(s.dir, "accounts.jsonl")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	if s.accLog != nil {
+		s.accLog.Close()
+	}
+	s.accLog = f
+	s.accLines = len(s.accounts)
+	return nil
+}
+
+// openDataFile opens one of the store's logs, creating it readable by the
+// owner only and tightening the mode of a file left by an older version.
+func openDataFile(path string, flag int) (*os.File, error) {
+	f, err := os.OpenFile(path, flag, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Chmod(0o600); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// indexHands records where each hand starts in hands.jsonl. A torn last line
+// from a crash is cut off so later appends start on a clean line.
+func (s *fileStore) indexHands() error {
+	if _, err := s.hands.Seek(0, 0); err != nil {
+		return err
+	}
+	r := bufio.NewReader(s.hands)
+	var offset int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			var head struct {
+				ID string `json:"id"`
+			}
+			if json.Unmarshal(line, &head) == nil && head.ID != "" {
+				s.handAt[head.ID] = offset
+			}
+			offset += int64(len(line))
+		}
+		if err != nil {
+			break
+		}
+	}
+	s.handsEnd = offset
+	return s.hands.Truncate(offset)
+}
+
+func readJSONFile(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// writeJSONFile replaces path atomically so a crash never leaves a half
+// written file behind.
+func writeJSONFile(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *fileStore) LoadAccounts() ([]Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	accounts := make([]Account, 0, len(s.accounts))
+	for _, a := range s.accounts {
+		accounts = append(accounts, a)
+	}
+	return accounts, nil
+}
+
+func (s *fileStore) SaveAccount(a Account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accounts[a.ID] = a
+	line, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	if _, err := s.accLog.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	s.accLines++
+	if s.accLines > 2*len(s.accounts)+64 {
+		return s.compactAccounts()
+	}
+	return nil
+}
+
+func (s *fileStore) LoadTables() ([]TableConfig, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tables := make([]TableConfig, 0, len(s.tables))
+	for _, t := range s.tables {
+		tables = append(tables, t)
+	}
+	return tables, nil
+}
+
+func (s *fileStore) SaveTable(t TableConfig) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tables[t.ID] = t
+	return writeJSONFile(filepath.Join(s.dir, "tables.json"), s.tables)
+}
+
+func (s *fileStore) SaveHandHistory(hist *HandHistory) error {
+	data, err := json.Marshal(hist)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.hands.WriteAt(data, s.handsEnd); err != nil {
+		return err
+	}
+	s.handAt[hist.ID] = s.handsEnd
+	s.handsEnd += int64(len(data))
+	return nil
+}
+
+func (s *fileStore) readHandAt(offset int64) (*HandHistory, error) {
+	if _, err := s.hands.Seek(offset, 0); err != nil {
+		return nil, err
+	}
+	line, err := bufio.NewReader(s.hands).ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	var hist HandHistory
+	if err := json.Unmarshal(line, &hist); err != nil {
+		return nil, err
+	}
+	return &hist, nil
+}
+
+func (s *fileStore) LoadHandHistories(tableID string, limit int) ([]*HandHistory, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.hands.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	var hands []*HandHistory
+	dec := json.NewDecoder(bufio.NewReader(s.hands))
+	for dec.More() {
+		var hist HandHistory
+		if err := dec.Decode(&hist); err != nil {
+			return nil, err
+		}
+		if hist.TableID != tableID {
+			continue
+		}
+		hands = append(hands, &hist)
+		if len(hands) > limit {
+			hands = hands[1:]
+		}
+	}
+	return hands, nil
+}
+
+func (s *fileStore) EachHandHistory(fn func(*HandHistory)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.hands.Seek(0, 0); err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bufio.NewReader(s.hands))
+	for dec.More() {
+		var hist HandHistory
+		if err := dec.Decode(&hist); err != nil {
+			return err
+		}
+		fn(&hist)
+	}
+	return nil
+}
+
+func (s *fileStore) HandHistory(id string) (*HandHistory, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	offset, ok := s.handAt[id]
+	if !ok {
+		return nil, errNotFound
+	}
+	return s.readHandAt(offset)
+}
+
+func (s *fileStore) SaveCheckpoint(tables []TableCheckpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := filepath.Join(s.dir, "checkpoint.json")
+	if tables == nil {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	return writeJSONFile(path, tables)
+}
+
+func (s *fileStore) LoadCheckpoint() ([]TableCheckpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var tables []TableCheckpoint
+	err := readJSONFile(filepath.Join(s.dir, "checkpoint.json"), &tables)
+	return tables, err
+}
+
+func (s *fileStore) AppendAudit(e AuditEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.audit.Write(append(line, '\n'))
+	return err
+}
+
+func (s *fileStore) LoadAudit(limit int) ([]AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.audit.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	entries := []AuditEntry{}
+	dec := json.NewDecoder(bufio.NewReader(s.audit))
+	for dec.More() {
+		var e AuditEntry
+		if err := dec.Decode(&e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+		if len(entries) > limit {
+			entries = entries[1:]
+		}
+	}
+	return entries, nil
+}
+
+func (s *fileStore) AppendLedger(e LedgerEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.ledger.Write(append(line, '\n'))
+	return err
+}
+
+func (s *fileStore) LoadLedger(account string, limit int) ([]LedgerEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.ledger.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	entries := []LedgerEntry{}
+	dec := json.NewDecoder(bufio.NewReader(s.ledger))
+	for dec.More() {
+		var e LedgerEntry
+		if err := dec.Decode(&e); err != nil {
+			return nil, err
+		}
+		if account != "" && e.From != account && e.To != account {
+			continue
+		}
+		entries = append(entries, e)
+		if limit > 0 && len(entries) > limit {
+			entries = entries[1:]
+		}
+	}
+	return entries, nil
+}
+
+func (s *fileStore) SessionKey() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := filepath.Join(s.dir, "session.key")
+	key, err := os.ReadFile(path)
+	if err == nil && len(key) >= 32 {
+		return key, nil
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, key, 0o600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (s *fileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return errors.Join(s.accLog.Close(), s.hands.Close(), s.audit.Close(), s.ledger.Close())
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStoreAccountsSurviveReopen(t *testing.T) {
+	dir := t.TempDir()
+	// An accounts.json left by an older version is carried over
+	if err := writeJSONFile(filepath.Join(dir, "accounts.json"), map[string]Account{
+		"old": {ID: "old", Name: "Old", Bankroll: 50},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := openFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 500 {
+		if err := s.SaveAccount(Account{ID: "a", Name: "Alice", Bankroll: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SaveAccount(Account{ID: "b", Name: "Bob", Bankroll: 7}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	// A torn line from a crash is skipped
+	f, err := os.OpenFile(filepath.Join(dir, "accounts.jsonl"), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"id":"a","name":"Alice","bankr`)
+	f.Close()
+
+	s, err = openFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	accounts, _ := s.LoadAccounts()
+	got := make(map[string]int)
+	for _, a := range accounts {
+		got[a.ID] = a.Bankroll
+	}
+	want := map[string]int{"old": 50, "a": 499, "b": 7}
+	if len(got) != len(want) {
+		t.Fatalf("loaded %v, want %v", got, want)
+	}
+	for id, bankroll := range want {
+		if got[id] != bankroll {
+			t.Errorf("account %s has %d, want %d", id, got[id], bankroll)
+		}
+	}
+	if s.accLines != len(want) {
+		t.Errorf("accounts.jsonl has %d lines after compaction, want %d", s.accLines, len(want))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "accounts.json")); !os.IsNotExist(err) {
+		t.Error("the old accounts.json is still there")
+	}
+}
+
+func TestFileStoreFilesAreOwnerOnly(t *testing.T) {
+	dir := t.TempDir()
+	// hands.jsonl as an older version left it
+	if err := os.WriteFile(filepath.Join(dir, "hands.jsonl"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := openFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.SaveTable(TableConfig{ID: "main"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SessionKey(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"accounts.jsonl", "tables.json", "hands.jsonl", "audit.jsonl", "ledger.jsonl", "session.key"} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := info.Mode().Perm(); mode != 0o600 {
+			t.Errorf("%s has mode %o, want 600", name, mode)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// --- Waiting list ---
+//
+// Spectators can queue for a seat. When a seat opens it is held for the first
+// person in line, who gets a seat_offer and has seatOfferWindow to accept it;
+// an offer that runs out drops them from the list and moves the seat on.
+// Everyone in line is pushed their position whenever the list changes.
+
+const seatOfferWindow = 20 * time.Second
+
+type waitEntry struct {
+	ID    string
+	Name  string
+	BuyIn int
+	// Seat is the seat held for this entry while an offer is open, 0 otherwise.
+	Seat    int
+	Expires time.Time
+	timer   *time.Timer
+}
+
+type JoinWaitlistPayload struct {
+	Name  string `json:"name"`
+	BuyIn int    `json:"buyIn"` // 0 for the table default
+}
+
+type WaitlistPositionPayload struct {
+	TableID  string `json:"tableId"`
+	Position int    `json:"position"` // 1 is next in line, 0 means not on the list
+	Length   int    `json:"length"`
+}
+
+type SeatOfferPayload struct {
+	TableID   string    `json:"tableId"`
+	Seat      int       `json:"seat"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	TimeoutMs int64     `json:"timeoutMs"`
+}
+
+func (h *Hub) handleJoinWaitlist(playerID string, payloadBytes json.RawMessage) error {
+	var payload JoinWaitlistPayload
+	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+		return protocolErrorf(CodeInvalidMessage, "invalid join_waitlist payload")
+	}
+
+	h.gameStateMutex.Lock()
+	defer h.gameStateMutex.Unlock()
+	if _, seated := h.gameState.Players[playerID]; seated {
+		return protocolErrorf(CodeAlreadySeated, "already seated at this table")
+	}
+	if _, watching := h.gameState.Spectators[playerID]; !watching {
+		return protocolErrorf(CodeNotSeated, "not at this table")
+	}
+	name, err := h.seatNameUnsafe(playerID, payload.Name)
+	if err != nil {
+		return err
+	}
+	if e := h.waitEntryUnsafe(playerID); e != nil {
+		e.Name, e.BuyIn = name, payload.BuyIn
+		return nil
+	}
+	h.waitlist = append(h.waitlist, &waitEntry{ID: playerID, Name: name, BuyIn: payload.BuyIn})
+	h.waitlistChanged = true
+	h.log.Info("joined the waiting list", "name", name, "waiting", len(h.waitlist))
+	h.broadcastGameStateUnsafe()
+	return nil
+}
+
+func (h *Hub) handleLeaveWaitlist(playerID string) error {
+	h.gameStateMutex.Lock()
+	defer h.gameStateMutex.Unlock()
+	if h.waitEntryUnsafe(playerID) == nil {
+		return nil
+	}
+	h.leaveWaitlistUnsafe(playerID)
+	h.pushUnsafe(playerID, "waitlist_position", WaitlistPositionPayload{TableID: h.config.ID, Length: len(h.waitlist)})
+	h.broadcastGameStateUnsafe()
+	return nil
+}
+
+// handleAcceptSeat sits the player in the seat they were offered.
+func (h *Hub) handleAcceptSeat(playerID string) error {
+	h.gameStateMutex.Lock()
+	defer h.gameStateMutex.Unlock()
+	e := h.waitEntryUnsafe(playerID)
+	if e == nil || e.Seat == 0 {
+		return protocolErrorf(CodeNoSeatOffer, "no seat is being offered to you")
+	}
+	if err := h.seatSpectatorUnsafe(playerID, e.Name, 0, e.BuyIn); err != nil {
+		h.log.Info("could not take the offered seat", "name", e.Name, "err", err)
+		h.broadcastGameStateUnsafe()
+		return err
+	}
+	h.broadcastGameStateUnsafe()
+	return nil
+}
+
+func (h *Hub) waitEntryUnsafe(playerID string) *waitEntry {
+	for _, e := range h.waitlist {
+		if e.ID == playerID {
+			return e
+		}
+	}
+	return nil
+}
+
+// leaveWaitlistUnsafe takes a player off the list and returns the seat that
+// was held for them, if any.
+func (h *Hub) leaveWaitlistUnsafe(playerID string) int {
+	for i, e := range h.waitlist {
+		if e.ID != playerID {
+			continue
+		}
+		if e.timer != nil {
+			e.timer.Stop()
+		}
+		h.waitlist = append(h.waitlist[:i], h.waitlist[i+1:]...)
+		h.waitlistChanged = true
+		return e.Seat
+	}
+	return 0
+}
+
+// heldSeatsUnsafe returns the seats held for open offers and for players
+// returning after a restart, mapped to who they are held for.
+func (h *Hub) heldSeatsUnsafe() map[int]string {
+	held := make(map[int]string)
+	for _, e := range h.waitlist {
+		if e.Seat != 0 {
+			held[e.Seat] = e.ID
+		}
+	}
+	for accountID, s := range h.restoredSeats {
+		held[s.Seat] = accountID
+	}
+	return held
+}
+
+// updateWaitlistUnsafe offers open seats down the list and pushes positions
+// if the list changed. Called from broadcastGameStateUnsafe.
+func (h *Hub) updateWaitlistUnsafe() {
+	for _, e := range h.waitlist {
+		if e.Seat != 0 {
+			continue
+		}
+		seat := h.freeSeatUnsafe()
+		if seat == 0 {
+			break
+		}
+		h.offerSeatUnsafe(e, seat)
+	}
+	h.gameState.WaitingList = len(h.waitlist)
+	if !h.waitlistChanged {
+		return
+	}
+	h.waitlistChanged = false
+	for i, e := range h.waitlist {
+		h.pushUnsafe(e.ID, "waitlist_position", WaitlistPositionPayload{TableID: h.config.ID, Position: i + 1, Length: len(h.waitlist)})
+	}
+}
+
+func (h *Hub) offerSeatUnsafe(e *waitEntry, seat int) {
+	e.Seat = seat
+	e.Expires = time.Now().Add(seatOfferWindow)
+	e.timer = time.AfterFunc(seatOfferWindow, func() { h.expireSeatOffer(e) })
+	h.log.Info("offering seat", "seat", seat, "name", e.Name)
+	h.pushUnsafe(e.ID, "seat_offer", SeatOfferPayload{
+		TableID:   h.config.ID,
+		Seat:      seat,
+		ExpiresAt: e.Expires,
+		TimeoutMs: seatOfferWindow.Milliseconds(),
+	})
+}
+
+// expireSeatOffer drops a player who let their offer run out.
+func (h *Hub) expireSeatOffer(e *waitEntry) {
+	h.gameStateMutex.Lock()
+	defer h.gameStateMutex.Unlock()
+	if h.waitEntryUnsafe(e.ID) != e || e.Seat == 0 {
+		return // accepted or left in the meantime
+	}
+	h.log.Info("seat offer expired", "name", e.Name)
+	h.leaveWaitlistUnsafe(e.ID)
+	h.pushUnsafe(e.ID, "waitlist_position", WaitlistPositionPayload{TableID: h.config.ID, Length: len(h.waitlist)})
+	h.broadcastGameStateUnsafe()
+}
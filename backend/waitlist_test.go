@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// pushed drains a client's queue and returns the payloads of the messages of
+// type typ, in order.
+func pushed(t *testing.T, client *Client, typ string) []json.RawMessage {
+	t.Helper()
+	var payloads []json.RawMessage
+	for len(client.send) > 0 {
+		var msg Message
+		if err := json.Unmarshal(<-client.send, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Type == typ {
+			payloads = append(payloads, msg.Payload)
+		}
+	}
+	return payloads
+}
+
+// lastOffer returns the last seat_offer pushed to client, or nil.
+func lastOffer(t *testing.T, client *Client) *SeatOfferPayload {
+	t.Helper()
+	offers := pushed(t, client, "seat_offer")
+	if len(offers) == 0 {
+		return nil
+	}
+	var offer SeatOfferPayload
+	if err := json.Unmarshal(offers[len(offers)-1], &offer); err != nil {
+		t.Fatal(err)
+	}
+	return &offer
+}
+
+func joinWaitlist(t *testing.T, h *Hub, id, name string) error {
+	t.Helper()
+	payload, _ := json.Marshal(JoinWaitlistPayload{Name: name, BuyIn: 1000})
+	return h.handleJoinWaitlist(id, payload)
+}
+
+func TestWaitlistOffersSeatsInOrder(t *testing.T) {
+	h := newTestTable(t, "random")
+	h.gameStateMutex.Lock()
+	h.config.MaxSeats = 2
+	h.gameStateMutex.Unlock()
+	alice := sitDown(t, h, "Alice", 1000)
+	sitDown(t, h, "Bob", 1000)
+	clients := make(map[string]*Client)
+	for _, name := range []string{"Zoe", "Yan", "Xu"} {
+		if _, err := h.accounts.Register(name, "test"); err != nil {
+			t.Fatal(err)
+		}
+		clients[name] = watch(t, h, name, name).client
+	}
+	for _, name := range []string{"Zoe", "Yan"} {
+		if err := joinWaitlist(t, h, name, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkProtocolError(t, "seated player joining", joinWaitlist(t, h, alice, "Alice"), CodeAlreadySeated)
+	var position WaitlistPositionPayload
+	positions := pushed(t, clients["Yan"], "waitlist_position")
+	if len(positions) > 0 {
+		json.Unmarshal(positions[len(positions)-1], &position)
+	}
+	if position.Position != 2 || position.Length != 2 {
+		t.Errorf("Yan is told position %d of %d, want 2 of 2", position.Position, position.Length)
+	}
+
+	// Alice's seat is offered to Zoe and held for her
+	seat := snapshot(h).Players[alice].Seat
+	if err := h.handleStandUp(alice); err != nil {
+		t.Fatal(err)
+	}
+	offer := lastOffer(t, clients["Zoe"])
+	if offer == nil || offer.Seat != seat || offer.TimeoutMs != seatOfferWindow.Milliseconds() {
+		t.Fatalf("Zoe was offered %+v, want seat %d for %v", offer, seat, seatOfferWindow)
+	}
+	if left := time.Until(offer.ExpiresAt); left <= 0 || left > seatOfferWindow {
+		t.Errorf("the offer expires in %v", left)
+	}
+	if offer := lastOffer(t, clients["Yan"]); offer != nil {
+		t.Errorf("Yan was offered seat %d before Zoe answered", offer.Seat)
+	}
+	h.gameStateMutex.Lock()
+	err := h.seatSpectatorUnsafe("Xu", "Xu", 0, 1000)
+	h.gameStateMutex.Unlock()
+	checkProtocolError(t, "taking the held seat", err, CodeTableFull)
+	checkProtocolError(t, "accepting without an offer", h.handleAcceptSeat("Yan"), CodeNoSeatOffer)
+
+	// Zoe lets the offer run out, so she leaves the list and Yan is next
+	h.gameStateMutex.Lock()
+	e := h.waitEntryUnsafe("Zoe")
+	e.timer.Stop()
+	h.gameStateMutex.Unlock()
+	h.expireSeatOffer(e)
+	position = WaitlistPositionPayload{Position: -1}
+	positions = pushed(t, clients["Zoe"], "waitlist_position")
+	if len(positions) > 0 {
+		json.Unmarshal(positions[len(positions)-1], &position)
+	}
+	if position.Position != 0 {
+		t.Errorf("Zoe is told position %d after her offer expired, want 0", position.Position)
+	}
+	if offer := lastOffer(t, clients["Yan"]); offer == nil || offer.Seat != seat {
+		t.Fatalf("Yan was offered %+v, want seat %d", offer, seat)
+	}
+	checkProtocolError(t, "accepting an expired offer", h.handleAcceptSeat("Zoe"), CodeNoSeatOffer)
+
+	if err := h.handleAcceptSeat("Yan"); err != nil {
+		t.Fatal(err)
+	}
+	s := snapshot(h)
+	if p, ok := s.Players["Yan"]; !ok || p.Seat != seat || p.Chips != 1000 {
+		t.Errorf("Yan sits in seat %d with %d chips, want seat %d with 1000", p.Seat, p.Chips, seat)
+	}
+	if s.WaitingList != 0 {
+		t.Errorf("%d still waiting", s.WaitingList)
+	}
+
+	// A timer that fires after the seat was taken does nothing
+	h.expireSeatOffer(&waitEntry{ID: "Yan", Seat: seat})
+	if _, ok := snapshot(h).Players["Yan"]; !ok {
+		t.Error("a late expiry unseated Yan")
+	}
+}
+
+func TestLeavingTheWaitlistMovesTheOfferOn(t *testing.T) {
+	h := newTestTable(t, "random")
+	h.gameStateMutex.Lock()
+	h.config.MaxSeats = 2
+	h.gameStateMutex.Unlock()
+	alice := sitDown(t, h, "Alice", 1000)
+	sitDown(t, h, "Bob", 1000)
+	clients := make(map[string]*Client)
+	for _, name := range []string{"Zoe", "Yan"} {
+		if _, err := h.accounts.Register(name, "test"); err != nil {
+			t.Fatal(err)
+		}
+		clients[name] = watch(t, h, name, name).client
+		if err := joinWaitlist(t, h, name, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := h.handleStandUp(alice); err != nil {
+		t.Fatal(err)
+	}
+	if lastOffer(t, clients["Zoe"]) == nil {
+		t.Fatal("Zoe was offered no seat")
+	}
+
+	if err := h.handleLeaveWaitlist("Zoe"); err != nil {
+		t.Fatal(err)
+	}
+	if offer := lastOffer(t, clients["Yan"]); offer == nil {
+		t.Fatal("the seat Zoe turned down was not offered to Yan")
+	}
+	if err := h.handleAcceptSeat("Yan"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,3 +1,46 @@
+// applyPatch applies the JSON Patch operations the server sends in
+// state_delta messages (add, remove and replace only) and returns the
+// patched document.
+function applyPatch(doc, ops) {
+    for (const op of ops) {
+        const keys = op.path.split('/').slice(1)
+            .map(k => k.replace(/~1/g, '/').replace(/~0/g, '~'));
+        if (keys.length === 0) {
+            doc = op.value;
+            continue;
+        }
+        let parent = doc;
+        for (const key of keys.slice(0, -1)) {
+            parent = parent[Array.isArray(parent) ? Number(key) : key];
+            if (parent === undefined || parent === null) {
+                throw new Error(`bad patch path ${op.path}`);
+            }
+        }
+        const last = keys[keys.length - 1];
+        if (Array.isArray(parent)) {
+            const index = last === '-' ? parent.length : Number(last);
+            if (op.op === 'add') parent.splice(index, 0, op.value);
+            else if (op.op === 'remove') parent.splice(index, 1);
+            else parent[index] = op.value;
+        } else if (op.op === 'remove') {
+            delete parent[last];
+        } else {
+            parent[last] = op.value;
+        }
+    }
+    return doc;
+}
+
+// Version of the WebSocket protocol this client speaks, see PROTOCOL.md.
+const PROTOCOL_VERSION = 1;
+
+// The server serves this page, so talk to the host it came from. Opened as a
+// file, fall back to a local server.
+const SERVER_HOST = window.location.host || 'localhost:8080';
+const WS_BASE = `${window.location.protocol === 'https:' ? 'wss' : 'ws'}://${SERVER_HOST}`;
+const API_BASE = `${window.location.protocol === 'https:' ? 'https' : 'http'}://${SERVER_HOST}`;
+const TOKEN_KEY = 'dpoker.sessionToken';
+
 class GameScene extends Phaser.Scene {
     constructor() {
         super({ key: 'GameScene' });
@@ -6,9 +49,16 @@ class GameScene extends Phaser.Scene {
         this.isReady = false;
         this.myId = null;
         this.gameState = {};
+        this.stateSeq = null; // null until the first snapshot arrives
+        this.nextRequestId = 1;
         this.reconnectAttempts = 0;
-        this.playerName = '';
+        this.playerName = ''; // the logged-in account's name
+        this.authToken = localStorage.getItem(TOKEN_KEY);
+        this.wantsSeat = false; // sit down again after a reconnect
+        this.waitlistPosition = 0; // 0 when not on the waiting list
+        this.seatOffer = null;
         this.dealerChip = null;
+        this.replayHandId = new URLSearchParams(window.location.search).get('replay');
     }
 
     preload() {
@@ -24,7 +74,12 @@ class GameScene extends Phaser.Scene {
         this.setupAnimations();
         this.initializeUIElements();
         this.setupDebugListener();
-        this.connectToServer();
+        if (this.replayHandId) {
+            this.setupReplayControls();
+            this.connectToServer();
+            return;
+        }
+        this.restoreSession().then(() => this.connectToServer());
     }
 
     createCardTextures() {
@@ -239,14 +294,8 @@ class GameScene extends Phaser.Scene {
     }
 
     setupUI() {
-        this.playerPositions = [
-            { x: 400, y: 520, name: 'bottom' },
-            { x: 100, y: 350, name: 'left' },
-            { x: 180, y: 150, name: 'topleft' },
-            { x: 400, y: 120, name: 'top' },
-            { x: 620, y: 150, name: 'topright' },
-            { x: 700, y: 350, name: 'right' }
-        ];
+        // Seats sit on an ellipse around the table, your own seat at the bottom
+        this.seatLayout = { x: 400, y: 335, rx: 300, ry: 200 };
     }
 
     setupAnimations() {
@@ -261,7 +310,14 @@ class GameScene extends Phaser.Scene {
     initializeUIElements() {
         // Get UI elements
         this.playerNameInput = document.getElementById('player-name');
+        this.passwordInput = document.getElementById('player-password');
+        this.loginForm = document.getElementById('login-form');
+        this.accountInfo = document.getElementById('account-info');
+        this.accountName = document.getElementById('account-name');
+        this.buyInInput = document.getElementById('buy-in');
         this.readyBtn = document.getElementById('ready-btn');
+        this.standBtn = document.getElementById('stand-btn');
+        this.rebuyBtn = document.getElementById('rebuy-btn');
         this.chatMessages = document.getElementById('chat-messages');
         this.chatInput = document.getElementById('chat-input');
         this.chatSendBtn = document.getElementById('chat-send');
@@ -281,16 +337,30 @@ class GameScene extends Phaser.Scene {
             return;
         }
 
-        this.playerNameInput.addEventListener('input', (e) => {
-            this.playerName = e.target.value.trim();
-            if (this.playerName && this.socket && this.socket.readyState === WebSocket.OPEN) {
-                this.sendMessage({ type: 'player_join', payload: { name: this.playerName } });
-            }
+        document.getElementById('login-btn').addEventListener('click', () => this.logIn('login'));
+        document.getElementById('register-btn').addEventListener('click', () => this.logIn('register'));
+        document.getElementById('logout-btn').addEventListener('click', () => this.logOut());
+        this.passwordInput.addEventListener('keypress', (e) => {
+            if (e.key === 'Enter') this.logIn('login');
         });
 
         this.readyBtn.addEventListener('click', () => {
             if (!this.playerName) {
-                this.showMessage('Please enter your name first!', 'warning');
+                this.showMessage('Please log in first!', 'warning');
+                return;
+            }
+            if (!this.isSeated()) {
+                this.wantsSeat = true;
+                if (this.seatOffer) {
+                    this.sendMessage({ type: 'accept_seat', payload: {} });
+                } else if (this.waitlistPosition > 0) {
+                    this.wantsSeat = false;
+                    this.sendMessage({ type: 'leave_waitlist', payload: {} });
+                } else if (this.tableIsFull()) {
+                    this.sendMessage({ type: 'join_waitlist', payload: this.buyInPayload() });
+                } else {
+                    this.sendMessage({ type: 'player_join', payload: this.buyInPayload() });
+                }
                 return;
             }
             this.isReady = !this.isReady;
@@ -298,6 +368,15 @@ class GameScene extends Phaser.Scene {
             this.sendMessage({ type: 'player_ready', payload: { isReady: this.isReady } });
         });
 
+        this.standBtn.addEventListener('click', () => {
+            this.wantsSeat = false;
+            this.sendMessage({ type: 'stand_up', payload: {} });
+        });
+
+        this.rebuyBtn.addEventListener('click', () => {
+            this.sendMessage({ type: 'rebuy', payload: {} });
+        });
+
         this.chatSendBtn.addEventListener('click', () => this.sendChatMessage());
         this.chatInput.addEventListener('keypress', (e) => {
             if (e.key === 'Enter') this.sendChatMessage();
@@ -335,25 +414,129 @@ class GameScene extends Phaser.Scene {
         }
     }
 
+    // restoreSession checks a token saved by an earlier visit and drops it if
+    // it has expired.
+    async restoreSession() {
+        if (!this.authToken) {
+            this.showAccount(null);
+            return;
+        }
+        try {
+            const res = await fetch(`${API_BASE}/api/auth/session`, {
+                headers: { Authorization: `Bearer ${this.authToken}` }
+            });
+            if (res.ok) {
+                this.showAccount(await res.json());
+                return;
+            }
+        } catch (err) {
+            console.error('Could not check the session:', err);
+        }
+        this.authToken = null;
+        localStorage.removeItem(TOKEN_KEY);
+        this.showAccount(null);
+    }
+
+    async logIn(action) {
+        const name = this.playerNameInput.value.trim();
+        const password = this.passwordInput.value;
+        if (!name || !password) {
+            this.showMessage('Enter a name and a password', 'warning');
+            return;
+        }
+        let body;
+        try {
+            const res = await fetch(`${API_BASE}/api/auth/${action}`, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ name, password })
+            });
+            body = await res.json();
+            if (!res.ok) {
+                this.showMessage(body.error || 'Login failed', 'error');
+                return;
+            }
+        } catch (err) {
+            this.showMessage('Could not reach the server', 'error');
+            return;
+        }
+        this.authToken = body.token;
+        localStorage.setItem(TOKEN_KEY, body.token);
+        this.passwordInput.value = '';
+        this.showAccount(body.account);
+        this.showMessage(action === 'register' ? `Welcome, ${body.account.name}!` : `Logged in as ${body.account.name}`, 'info');
+        this.reconnectNow();
+    }
+
+    logOut() {
+        this.authToken = null;
+        localStorage.removeItem(TOKEN_KEY);
+        this.wantsSeat = false;
+        this.showAccount(null);
+        this.reconnectNow();
+    }
+
+    // buyInPayload is the buy-in the player typed, if any; the server uses
+    // the table default otherwise.
+    buyInPayload() {
+        const amount = parseInt(this.buyInInput.value, 10);
+        return amount > 0 ? { buyIn: amount } : {};
+    }
+
+    showAccount(account) {
+        this.playerName = account ? account.name : '';
+        this.loginForm.style.display = account ? 'none' : 'block';
+        this.accountInfo.style.display = account ? 'flex' : 'none';
+        this.accountName.textContent = account ? account.name : '';
+    }
+
+    // reconnectNow opens a new connection so the server sees the current
+    // session; the server only checks it when the connection opens.
+    reconnectNow() {
+        if (this.socket) {
+            this.socket.onclose = null;
+            this.socket.close();
+        }
+        this.connectToServer();
+    }
+
     connectToServer() {
         this.updateConnectionStatus('connecting', 'Connecting...');
-        this.socket = new WebSocket('ws://localhost:8080/ws');
+        let url = this.replayHandId
+            ? `${WS_BASE}/replay?hand=${encodeURIComponent(this.replayHandId)}`
+            : `${WS_BASE}/ws`;
+        if (this.authToken && !this.replayHandId) {
+            // Browsers cannot set headers on a WebSocket, so the token goes in the URL
+            url += `?token=${encodeURIComponent(this.authToken)}`;
+        }
+        this.socket = new WebSocket(url);
         
         this.socket.onopen = () => {
             this.updateConnectionStatus('connected', 'Connected');
             this.reconnectAttempts = 0;
             
-            if (this.playerName) {
-                this.sendMessage({ type: 'player_join', payload: { name: this.playerName } });
+            if (!this.replayHandId) {
+                this.sendMessage({ type: 'hello', payload: { protocolVersion: PROTOCOL_VERSION, client: 'd-poker-web' } });
+                if (this.playerName && this.wantsSeat) {
+                    this.sendMessage({ type: 'player_join', payload: this.buyInPayload() });
+                }
             }
             
             // Debug message
             console.log('WebSocket connected successfully');
         };
 
-        this.socket.onclose = () => {
-            this.updateConnectionStatus('disconnected', 'Disconnected');
-            this.scheduleReconnect();
+        this.socket.onclose = (event) => {
+            // 1008: an operator removed this connection, so don't come back
+            if (event.code === 1008) {
+                this.updateConnectionStatus('disconnected', `Removed from the table: ${event.reason}`);
+                return;
+            }
+            // 1001: the server is restarting and will be back shortly
+            this.updateConnectionStatus('disconnected', event.code === 1001 ? 'Server restarting...' : 'Disconnected');
+            if (!this.replayHandId) {
+                this.scheduleReconnect();
+            }
         };
 
         this.socket.onerror = () => {
@@ -385,6 +568,35 @@ class GameScene extends Phaser.Scene {
         });
     }
 
+    // Replay keys: space play/pause, right arrow step, +/- speed, Home restart
+    setupReplayControls() {
+        this.replaySpeed = 1;
+        this.replayPlaying = true;
+        window.addEventListener('keydown', (e) => {
+            const control = (payload) => this.sendMessage({ type: 'replay_control', payload });
+            switch (e.key) {
+                case ' ':
+                    control({ command: this.replayPlaying ? 'pause' : 'play' });
+                    break;
+                case 'ArrowRight':
+                    control({ command: 'step' });
+                    break;
+                case '+':
+                    control({ command: 'speed', speed: this.replaySpeed * 2 });
+                    break;
+                case '-':
+                    control({ command: 'speed', speed: this.replaySpeed / 2 });
+                    break;
+                case 'Home':
+                    control({ command: 'seek', frame: 0 });
+                    break;
+                default:
+                    return;
+            }
+            e.preventDefault();
+        });
+    }
+
     updateConnectionStatus(status, text) {
         this.statusIndicator.className = `status-indicator status-${status}`;
         this.connectionText.textContent = text;
@@ -393,21 +605,84 @@ class GameScene extends Phaser.Scene {
     handleMessage(msg) {
         console.log('Received message:', msg);
         switch (msg.type) {
-            case 'player_id':
-                this.myId = msg.payload.id;
-                console.log('My player ID set to:', this.myId);
+            case 'welcome':
+                this.myId = msg.payload.playerId;
+                console.log(`Protocol v${msg.payload.protocolVersion}, my player ID:`, this.myId);
+                break;
+            case 'action_rejected':
+                this.showMessage(msg.payload.message, 'warning');
+                // Nothing changed on the server, so bring the action bar back
+                this.updateGameState(this.gameState);
+                break;
+            case 'seat_open':
+                if (!this.isSeated()) {
+                    this.showMessage(`A seat is open (${msg.payload.openSeats} free). Take a seat to play!`, 'info');
+                }
+                break;
+            case 'waitlist_position':
+                if (msg.payload.position === 0 && this.seatOffer && !this.isSeated()) {
+                    this.showMessage('Your seat offer expired', 'warning');
+                }
+                this.waitlistPosition = msg.payload.position;
+                if (msg.payload.position === 0) {
+                    this.seatOffer = null;
+                }
+                this.updateReadyButton();
+                break;
+            case 'server_shutdown':
+                this.showMessage(msg.payload.message, 'warning');
+                break;
+            case 'seat_offer':
+                this.seatOffer = msg.payload;
+                this.showMessage(`Seat ${msg.payload.seat} is yours if you accept within ${Math.round(msg.payload.timeoutMs / 1000)}s`, 'info');
+                this.updateReadyButton();
+                break;
+            case 'error':
+                console.error(`Server rejected ${msg.payload.requestType || 'message'}: [${msg.payload.code}] ${msg.payload.message}`);
+                if (msg.payload.code === 'unsupported_version') {
+                    this.updateConnectionStatus('disconnected', 'Please reload: client is out of date');
+                } else if (msg.payload.requestType !== 'resync') {
+                    this.showMessage(msg.payload.message, 'error');
+                }
                 break;
             case 'game_state':
                 this.gameState = msg.payload;
+                this.stateSeq = msg.seq ?? null;
                 console.log('Game state updated:', this.gameState);
                 this.updateGameState(this.gameState);
                 break;
+            case 'state_delta':
+                if (this.stateSeq === null || msg.seq !== this.stateSeq + 1) {
+                    // Missed an update; ask for a full snapshot and drop deltas until it arrives
+                    console.warn(`State delta ${msg.seq} does not follow ${this.stateSeq}, resyncing`);
+                    this.stateSeq = null;
+                    this.sendMessage({ type: 'resync', payload: {} });
+                    break;
+                }
+                try {
+                    this.gameState = applyPatch(this.gameState, msg.payload);
+                } catch (err) {
+                    console.error('Failed to apply state delta:', err);
+                    this.stateSeq = null;
+                    this.sendMessage({ type: 'resync', payload: {} });
+                    break;
+                }
+                this.stateSeq = msg.seq;
+                this.updateGameState(this.gameState);
+                break;
+            case 'replay_state':
+                this.replayPlaying = msg.payload.playing;
+                this.replaySpeed = msg.payload.speed;
+                this.updateConnectionStatus('connected',
+                    `Replay ${msg.payload.frame}/${msg.payload.totalFrames} ${msg.payload.playing ? '▶' : '⏸'} ${msg.payload.speed}x`);
+                break;
         }
     }
 
     updateGameState(state) {
         this.potAmount.textContent = `$${state.pot || 0}`;
-        this.playerCount.textContent = state.players ? Object.keys(state.players).length : 0;
+        this.playerCount.textContent = (state.players ? Object.keys(state.players).length : 0) +
+            (state.waitingList ? ` (${state.waitingList} waiting)` : '');
         this.gamePhase.textContent = this.formatGamePhase(state.gamePhase || 'waiting');
         
         if (state.players && state.players[this.myId]) {
@@ -418,10 +693,20 @@ class GameScene extends Phaser.Scene {
 
         if (state.playerReady) {
             this.isReady = state.playerReady[this.myId] || false;
-            this.updateReadyButton();
         }
-
-        this.readyBtn.style.display = state.gameStarted ? 'none' : 'block';
+        this.updateReadyButton();
+
+        // Spectators can take a seat at any time and are dealt in from the next hand
+        const seated = this.isSeated();
+        this.readyBtn.style.display = (seated && state.gameStarted) || this.replayHandId ? 'none' : 'block';
+        this.standBtn.style.display = seated && !this.inRunningHand(state) && !this.replayHandId ? 'block' : 'none';
+        // Busted players keep their seat for a minute to rebuy
+        const busted = seated && state.players[this.myId].chips === 0;
+        this.rebuyBtn.style.display = busted && !this.inRunningHand(state) && !this.replayHandId ? 'block' : 'none';
+        if (!this.replayHandId) {
+            const status = seated ? 'Connected' : 'Spectating';
+            this.updateConnectionStatus('connected', state.paused ? `${status} - table paused` : status);
+        }
 
         this.updateCommunityCards(state.communityCards || []);
         this.updatePlayers(state);
@@ -459,30 +744,73 @@ class GameScene extends Phaser.Scene {
         });
     }
 
+    seatPosition(seat, anchorSeat, maxSeats) {
+        const angle = Math.PI / 2 + 2 * Math.PI * (seat - anchorSeat) / maxSeats;
+        return {
+            x: this.seatLayout.x + this.seatLayout.rx * Math.cos(angle),
+            y: this.seatLayout.y + this.seatLayout.ry * Math.sin(angle)
+        };
+    }
+
     updatePlayers(state) {
         if (!state.players) return;
 
-        const allPlayerIds = Object.keys(state.players);
-        const otherPlayerIds = allPlayerIds.filter(id => id !== this.myId);
-        
         Object.values(this.playerObjects).forEach(obj => obj.destroy());
         this.playerObjects = {};
 
-        allPlayerIds.forEach((playerId, index) => {
-            const player = state.players[playerId];
-            if (!player.isConnected) return;
-
-            const isMe = playerId === this.myId;
-            const position = this.playerPositions[isMe ? 0 : (otherPlayerIds.indexOf(playerId) + 1) % 6];
-            
-            if (position) {
-                this.renderPlayer(player, position, state, isMe);
-            }
+        // Replays of hands recorded before seats existed have no seat numbers
+        const bySeat = {};
+        Object.values(state.players).forEach((player, index) => {
+            bySeat[player.seat || index + 1] = player;
         });
+        const maxSeats = Math.max(state.maxSeats || 6, ...Object.keys(bySeat).map(Number));
+        const me = state.players[this.myId];
+        const anchorSeat = me && me.seat ? me.seat : 1;
+        const canPickSeat = !this.replayHandId && !this.inRunningHand(state);
+
+        for (let seat = 1; seat <= maxSeats; seat++) {
+            const position = this.seatPosition(seat, anchorSeat, maxSeats);
+            const player = bySeat[seat];
+            if (player) {
+                if (player.isConnected) {
+                    this.renderPlayer(player, position, state, player.id === this.myId);
+                }
+            } else if (canPickSeat) {
+                this.renderEmptySeat(seat, position);
+            }
+        }
 
         this.updateDealerButton(state);
     }
 
+    renderEmptySeat(seat, position) {
+        const label = this.add.text(position.x, position.y, `Seat ${seat}\nSit here`, {
+            fontSize: '13px',
+            fill: '#ffd700',
+            fontFamily: 'Roboto',
+            align: 'center',
+            backgroundColor: 'rgba(0,0,0,0.4)',
+            padding: { x: 12, y: 8 }
+        }).setOrigin(0.5).setAlpha(0.7).setInteractive({ useHandCursor: true });
+        label.on('pointerover', () => label.setAlpha(1));
+        label.on('pointerout', () => label.setAlpha(0.7));
+        label.on('pointerdown', () => this.takeSeat(seat));
+        this.playerObjects[`seat-${seat}`] = label;
+    }
+
+    takeSeat(seat) {
+        if (this.isSeated()) {
+            this.sendMessage({ type: 'take_seat', payload: { seat } });
+            return;
+        }
+        if (!this.playerName) {
+            this.showMessage('Please log in first!', 'warning');
+            return;
+        }
+        this.wantsSeat = true;
+        this.sendMessage({ type: 'take_seat', payload: { seat, ...this.buyInPayload() } });
+    }
+
     renderPlayer(player, position, state, isMe) {
         const container = this.add.container(position.x, position.y);
         
@@ -543,9 +871,27 @@ class GameScene extends Phaser.Scene {
             container.add(foldText);
         }
 
-        if (player.hand && player.hand.length > 0) {
+        // Tables with a HUD send each seat's statistics
+        if (player.hud && player.hud.hands > 0) {
+            const { hands, vpip, pfr, af } = player.hud;
+            const hudText = this.add.text(0, 42, `${vpip}/${pfr}/${af} (${hands})`, {
+                fontSize: '10px',
+                fill: '#bdc3c7',
+                fontFamily: 'Roboto'
+            }).setOrigin(0.5);
+            container.add(hudText);
+        }
+
+        // Other players' hole cards are only sent at showdown
+        if ((!player.hand || player.hand.length === 0) && player.isInHand && state.gameStarted) {
+            [0, 1].forEach(cardIndex => {
+                const cardImage = this.add.image((cardIndex - 0.5) * 45, -80, 'card-back');
+                cardImage.setScale(0.4);
+                container.add(cardImage);
+            });
+        } else if (player.hand && player.hand.length > 0) {
             player.hand.forEach((card, cardIndex) => {
-                const showCard = isMe || state.gamePhase === 'showdown';
+                const showCard = isMe || state.gamePhase === 'showdown' || this.replayHandId;
                 const cardKey = showCard ? `card-${card.rank}-${card.suit}` : 'card-back';
                 
                 const cardImage = this.add.image((cardIndex - 0.5) * 45, -80, cardKey);
@@ -604,8 +950,8 @@ class GameScene extends Phaser.Scene {
             players: state.players ? Object.keys(state.players) : []
         });
 
-        if (!state.gameStarted || !state.playerOrder || !this.myId) {
-            console.log('Hiding action bar - game not started or missing data');
+        if (!state.gameStarted || !state.playerOrder || !this.myId || state.paused) {
+            console.log('Hiding action bar - game not started, paused or missing data');
             this.actionBar.style.display = 'none';
             return;
         }
@@ -649,8 +995,9 @@ class GameScene extends Phaser.Scene {
             if (msg.playerId === 'system') {
                 messageDiv.innerHTML = `<i class="fas fa-info-circle"></i> ${msg.message}`;
             } else {
-                const playerName = this.gameState.players && this.gameState.players[msg.playerId] ? 
-                                 this.gameState.players[msg.playerId].name : 'Player';
+                const sender = (this.gameState.players && this.gameState.players[msg.playerId]) ||
+                               (this.gameState.spectators && this.gameState.spectators[msg.playerId]);
+                const playerName = sender ? sender.name : 'Player';
                 messageDiv.innerHTML = `<strong>${playerName}:</strong> ${msg.message}`;
             }
             
@@ -756,9 +1103,42 @@ class GameScene extends Phaser.Scene {
         }, 4000);
     }
 
+    isSeated() {
+        return !!(this.gameState.players && this.gameState.players[this.myId]);
+    }
+
+    // Seats held for the waiting list count as taken
+    tableIsFull() {
+        const state = this.gameState;
+        const seated = state.players ? Object.keys(state.players).length : 0;
+        return seated >= (state.maxSeats || 6) || (state.waitingList || 0) > 0;
+    }
+
+    inRunningHand(state) {
+        return !!(state.gameStarted && state.playerOrder && state.playerOrder.includes(this.myId));
+    }
+
     updateReadyButton() {
         const btn = this.readyBtn;
-        if (this.isReady) {
+        if (this.isSeated() && (this.seatOffer || this.waitlistPosition)) {
+            this.seatOffer = null;
+            this.waitlistPosition = 0;
+        }
+        if (!this.isSeated() && !this.replayHandId) {
+            if (this.seatOffer) {
+                btn.innerHTML = `<i class="fas fa-chair"></i> Accept Seat ${this.seatOffer.seat}`;
+                btn.classList.remove('ready');
+            } else if (this.waitlistPosition > 0) {
+                btn.innerHTML = `<i class="fas fa-times"></i> Leave Waiting List (#${this.waitlistPosition})`;
+                btn.classList.add('ready');
+            } else if (this.tableIsFull()) {
+                btn.innerHTML = '<i class="fas fa-list-ol"></i> Join Waiting List';
+                btn.classList.remove('ready');
+            } else {
+                btn.innerHTML = '<i class="fas fa-chair"></i> Take a Seat';
+                btn.classList.remove('ready');
+            }
+        } else if (this.isReady) {
             btn.innerHTML = '<i class="fas fa-times"></i> Cancel';
             btn.classList.add('ready');
         } else {
@@ -769,6 +1149,9 @@ class GameScene extends Phaser.Scene {
 
     sendMessage(message) {
         if (this.socket && this.socket.readyState === WebSocket.OPEN) {
+            if (!this.replayHandId) {
+                message.requestId = String(this.nextRequestId++);
+            }
             this.socket.send(JSON.stringify(message));
         }
     }